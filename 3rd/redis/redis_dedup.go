@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+const (
+	// DedupPrefix 去重记录的 key 前缀
+	DedupPrefix = "braid-dedup-"
+
+	dedupProcessing = "processing-"
+	dedupDone       = "done"
+)
+
+// Dedup 基于 redis 的 pubsub 消息去重存储（实现 pubsub.DedupStore），可以在多个节点之间去重
+type Dedup struct {
+	client *Client
+}
+
+// NewDedup 构建 Dedup
+func NewDedup(client *Client) *Dedup {
+	return &Dedup{client: client}
+}
+
+func millis(d time.Duration) int64 {
+	ms := int64(d / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	return ms
+}
+
+// Claim 通过 set nx px 获得处理中的租约（值为 processing- 加上租约的 token），key 已经存在时返回它的状态
+func (d *Dedup) Claim(key string, lease time.Duration) (pubsub.DedupState, string, error) {
+	conn := d.client.pool.Get()
+	defer conn.Close()
+
+	token := pubsub.NewDedupToken()
+	_, err := redis.String(conn.Do("SET", DedupPrefix+key, dedupProcessing+token, "NX", "PX", millis(lease)))
+	if err == nil {
+		return pubsub.DedupClaimed, token, nil
+	}
+	if err != redis.ErrNil {
+		return pubsub.DedupClaimed, "", err
+	}
+
+	val, err := redis.String(conn.Do("GET", DedupPrefix+key))
+	if err == redis.ErrNil {
+		// 在两次请求之间过期，视为正在处理（重新投递之后再次尝试
+		return pubsub.DedupInFlight, "", nil
+	}
+	if err != nil {
+		return pubsub.DedupClaimed, "", err
+	}
+
+	if val == dedupDone {
+		return pubsub.DedupDone, "", nil
+	}
+	return pubsub.DedupInFlight, "", nil
+}
+
+// CompareSetScript 将 get 和 set px 整合成原子操作，值等于 ARGV[1] 时设置为 ARGV[2]（ARGV[3] 毫秒之后过期
+var CompareSetScript = redis.NewScript(1, `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	redis.call("set", KEYS[1], ARGV[2], "px", ARGV[3])
+	return 1
+else
+	return 0
+end`)
+
+// Commit 将 key 标记为已处理，并延长到 ttl；租约已经不属于 token 时返回 pubsub.ErrDedupLeaseLost
+func (d *Dedup) Commit(key string, token string, ttl time.Duration) error {
+	conn := d.client.pool.Get()
+	defer conn.Close()
+
+	status, err := redis.Int(CompareSetScript.Do(conn, DedupPrefix+key, dedupProcessing+token, dedupDone, millis(ttl)))
+	if err != nil {
+		return err
+	}
+	if status == 0 {
+		return pubsub.ErrDedupLeaseLost
+	}
+
+	return nil
+}
+
+// Release 删除 token 持有的租约；租约已经不属于 token 时返回 pubsub.ErrDedupLeaseLost
+func (d *Dedup) Release(key string, token string) error {
+	conn := d.client.pool.Get()
+	defer conn.Close()
+
+	status, err := redis.Int(GetDelScript.Do(conn, DedupPrefix+key, dedupProcessing+token))
+	if err != nil {
+		return err
+	}
+	if status == 0 {
+		return pubsub.ErrDedupLeaseLost
+	}
+
+	return nil
+}
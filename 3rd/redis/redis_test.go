@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/pojol/braid-go/mock"
+	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -93,3 +94,50 @@ func BenchmarkHGet(b *testing.B) {
 		c.HGet("benchmark_hget", "benchmark_01")
 	}
 }
+
+func TestDedup(t *testing.T) {
+
+	c := New()
+	err := c.Init(Config{
+		Address:        mock.RedisAddr,
+		ReadTimeOut:    time.Millisecond * time.Duration(5000),
+		WriteTimeOut:   time.Millisecond * time.Duration(5000),
+		ConnectTimeOut: time.Millisecond * time.Duration(2000),
+		IdleTimeout:    time.Millisecond * time.Duration(0),
+		MaxIdle:        16,
+		MaxActive:      128,
+	})
+	assert.Equal(t, err, nil)
+	defer c.Close()
+
+	d := NewDedup(c)
+	key := "TestDedup"
+	c.Del(DedupPrefix + key)
+
+	state, token, err := d.Claim(key, time.Second)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, state, pubsub.DedupClaimed)
+
+	state, _, err = d.Claim(key, time.Second)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, state, pubsub.DedupInFlight)
+
+	assert.Equal(t, d.Commit(key, token, time.Second), nil)
+	state, _, _ = d.Claim(key, time.Second)
+	assert.Equal(t, state, pubsub.DedupDone)
+	assert.Equal(t, d.Release(key, token), pubsub.ErrDedupLeaseLost)
+
+	// 没有 Commit 的租约（进程崩溃）过期后可以被再次处理
+	c.Del(DedupPrefix + key)
+	state, expired, _ := d.Claim(key, time.Millisecond*10)
+	assert.Equal(t, state, pubsub.DedupClaimed)
+
+	time.Sleep(time.Millisecond * 50)
+	state, token, _ = d.Claim(key, time.Second)
+	assert.Equal(t, state, pubsub.DedupClaimed)
+
+	// 过期的租约不能提交或者释放其他消费者的租约
+	assert.Equal(t, d.Commit(key, expired, time.Second), pubsub.ErrDedupLeaseLost)
+	assert.Equal(t, d.Release(key, expired), pubsub.ErrDedupLeaseLost)
+	assert.Equal(t, d.Release(key, token), nil)
+}
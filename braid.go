@@ -1,9 +1,12 @@
 package braid
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/balancer"
@@ -11,10 +14,12 @@ import (
 	"github.com/pojol/braid-go/module/elector"
 	"github.com/pojol/braid-go/module/linkcache"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/module/rpc/client"
 	"github.com/pojol/braid-go/module/rpc/server"
 	"github.com/pojol/braid-go/module/tracer"
+	"github.com/pojol/braid-go/modules/adminhttp"
 	"github.com/pojol/braid-go/modules/balancernormal"
 	"github.com/pojol/braid-go/modules/discoverconsul"
 	"github.com/pojol/braid-go/modules/electorconsul"
@@ -23,8 +28,11 @@ import (
 	"github.com/pojol/braid-go/modules/grpcserver"
 	"github.com/pojol/braid-go/modules/jaegertracing"
 	"github.com/pojol/braid-go/modules/linkerredis"
+	"github.com/pojol/braid-go/modules/metricsprom"
 	"github.com/pojol/braid-go/modules/moduleparm"
+	"github.com/pojol/braid-go/modules/pubsubmem"
 	"github.com/pojol/braid-go/modules/pubsubnsq"
+	"github.com/pojol/braid-go/modules/pubsubredis"
 	"github.com/pojol/braid-go/modules/zaplogger"
 )
 
@@ -44,11 +52,11 @@ const (
 )
 
 var (
-	ErrTypeConvFailed = errors.New("type conversion failed")
-
 	// 默认提供的模块
 	LoggerZap      = zaplogger.Name
 	PubsubNsq      = pubsubnsq.Name
+	PubsubMemory   = pubsubmem.Name
+	PubsubRedis    = pubsubredis.Name
 	DiscoverConsul = discoverconsul.Name
 	ElectorConsul  = electorconsul.Name
 	ElectorK8s     = electork8s.Name
@@ -57,249 +65,518 @@ var (
 	TracerJaeger   = jaegertracing.Name
 	BalancerSWRR   = balancernormal.Name
 	LinkcacheRedis = linkerredis.Name
+	AdminHTTP      = adminhttp.Name
+	MetricsProm    = metricsprom.Name
+
+	// ErrNotInitialized braid 还没有完成初始化
+	ErrNotInitialized = errors.New("braid is not initialized")
+)
+
+const (
+	stateBuilt int32 = iota
+	stateInited
+	stateRunning
+	stateClosed
 )
 
+var stateNames = map[int32]string{
+	stateBuilt:   "built",
+	stateInited:  "inited",
+	stateRunning: "running",
+	stateClosed:  "closed",
+}
+
+// moduleEntry 构建完成的模块
+type moduleEntry struct {
+	builder module.IBuilder
+	key     moduleKey
+	deps    []moduleKey
+	mod     interface{}
+	state   int32
+}
+
+func (e *moduleEntry) name() string {
+	return moduleName(e.builder)
+}
+
+func (e *moduleEntry) dependsOn(failed map[moduleKey]bool) bool {
+	for _, dep := range e.deps {
+		if failed[dep] {
+			return true
+		}
+	}
+	return false
+}
+
 // Braid framework instance
 type Braid struct {
 	name string // service name
+	parm Parm
 
 	logger logger.ILogger
 
-	builderMap map[module.ModuleType]module.IBuilder
+	builderMap map[moduleKey]module.IBuilder
+	builders   []module.IBuilder
+
+	// 按依赖顺序排列的模块
+	entries []*moduleEntry
+	inited  bool
 
-	modules []module.IModule
+	// braid 所处的阶段（用于健康检查
+	phase int32
 
-	client    client.IClient
-	server    server.IServer
-	tracer    tracer.ITracer
-	linkcache linkcache.ILinkCache
-	balancer  balancer.IBalancer
-	pubsub    pubsub.IPubsub
+	healthSrv *http.Server
+
+	// 构建完成的模块实例
+	mods map[moduleKey]interface{}
 
 	sync.RWMutex
 }
 
-var (
-	braidGlobal *Braid
-)
+func NewService(name string, opts ...Option) (*Braid, error) {
 
-func NewService(name string) (*Braid, error) {
+	p := defaultParm()
+	for _, opt := range opts {
+		opt(&p)
+	}
 
-	braidGlobal = &Braid{
+	b := &Braid{
 		name:       name,
-		builderMap: make(map[module.ModuleType]module.IBuilder),
+		parm:       p,
+		builderMap: make(map[moduleKey]module.IBuilder),
+		mods:       make(map[moduleKey]interface{}),
 	}
 
-	return braidGlobal, nil
+	// 兼容旧的全局接口，最后创建的实例会成为默认实例
+	SetDefault(b)
+
+	return b, nil
+}
+
+// invalidBuilder 占位用的构建器，用于在 Register 阶段报告不存在的模块或者错误的配置项
+type invalidBuilder struct {
+	name string
+	kind ErrKind
+	err  error
 }
 
+func (ib *invalidBuilder) Build(name string, buildOpts ...interface{}) interface{} { return nil }
+func (ib *invalidBuilder) Name() string                                            { return ib.name }
+func (ib *invalidBuilder) Type() module.ModuleType                                 { return 0 }
+func (ib *invalidBuilder) Depends() []module.Dependency                            { return nil }
+func (ib *invalidBuilder) AddModuleOption(opt interface{}) error                   { return nil }
+
+// Module 通过模块名获取构建器，并设置模块的配置项
+//
+// opts 中的 ModuleOption（WithInstance WithBind）由 braid 处理，其他的配置项会传递给模块，
+// 不属于该模块的配置项会在 Register 时以 OptionError 的形式返回
 func Module(name string, opts ...interface{}) module.IBuilder {
 	builder := module.GetBuilder(name)
-	if builder != nil {
-		for _, opt := range opts {
-			builder.AddModuleOption(opt)
-		}
+	if builder == nil {
+		return &invalidBuilder{name: name, kind: KindUnknownModule, err: ErrUnknownModule}
+	}
 
-		return builder
+	nb := &namedBuilder{IBuilder: builder}
+	named := false
+
+	for _, opt := range opts {
+		if mopt, ok := opt.(ModuleOption); ok {
+			mopt(nb)
+			named = true
+			continue
+		}
+		if err := builder.AddModuleOption(opt); err != nil {
+			return &invalidBuilder{name: builder.Name(), kind: KindOption, err: err}
+		}
 	}
 
-	panic(fmt.Errorf("unknow module %v", name))
+	if named {
+		return nb
+	}
+	return builder
 }
 
+// Register 注册并构建模块
+//
+// 构建失败的模块以及依赖于它的模块都不会被构建，所有的错误聚合为 Errors 返回
 func (b *Braid) Register(builders ...module.IBuilder) error {
+	var errs Errors
+
 	for _, build := range builders {
-		b.builderMap[build.Type()] = build
-	}
+		if ib, ok := build.(*invalidBuilder); ok {
+			errs = append(errs, &ModuleError{Module: ib.name, Kind: ib.kind, Err: ib.err})
+			continue
+		}
 
-	// init base module
-	if loggerBuilder, ok := b.builderMap[module.Logger]; ok {
-		li := loggerBuilder.Build(b.name)
-		ilog, t := li.(logger.ILogger)
-		if !t {
-			panic(ErrTypeConvFailed)
+		if v, ok := build.(module.IValidator); ok {
+			if err := v.Validate(); err != nil {
+				errs = append(errs, &ModuleError{Module: moduleName(build), Kind: KindOption, Err: err})
+				continue
+			}
 		}
-		b.logger = ilog
-	} else {
-		panic(fmt.Errorf("missing required dependencies => %v", "logger"))
-	}
 
-	if pubsubBuilder, ok := b.builderMap[module.Pubsub]; ok {
-		pbi := pubsubBuilder.Build(b.name, moduleparm.WithLogger(b.logger))
-		ipb, t := pbi.(pubsub.IPubsub)
-		if !t {
-			panic(ErrTypeConvFailed)
+		key := keyOf(build)
+		if _, ok := b.builderMap[key]; ok {
+			for k, v := range b.builders {
+				if keyOf(v) == key {
+					b.builders[k] = build
+				}
+			}
+		} else {
+			b.builders = append(b.builders, build)
 		}
-		b.pubsub = ipb
-	} else {
-		panic(fmt.Errorf("missing required dependencies => %v", "pub-sub"))
+		b.builderMap[key] = build
 	}
 
-	if balancerBuilder, ok := b.builderMap[module.Balancer]; ok {
-		bi := balancerBuilder.Build(b.name,
-			moduleparm.WithLogger(b.logger),
-			moduleparm.WithPubsub(b.pubsub),
-		)
-		ib, t := bi.(balancer.IBalancer)
-		if !t {
-			panic(ErrTypeConvFailed)
-		}
-		b.balancer = ib
-		b.modules = append(b.modules, ib)
+	if len(errs) > 0 {
+		return errs
 	}
 
-	if tracerBuilder, ok := b.builderMap[module.Tracer]; ok {
-		ti := tracerBuilder.Build(b.name, moduleparm.WithLogger(b.logger))
-		it, t := ti.(tracer.ITracer)
-		if !t {
-			panic(ErrTypeConvFailed)
-		}
-		b.tracer = it
-		b.modules = append(b.modules, it)
+	sorted, err := sortBuilders(b.builders)
+	if err != nil {
+		return err
 	}
 
-	if linkBuilder, ok := b.builderMap[module.Linkcache]; ok {
-		li := linkBuilder.Build(b.name,
-			moduleparm.WithLogger(b.logger),
-			moduleparm.WithPubsub(b.pubsub),
-		)
-		il, t := li.(linkcache.ILinkCache)
-		if !t {
-			panic(ErrTypeConvFailed)
-		}
-		b.linkcache = il
-		b.modules = append(b.modules, il)
+	keys := make([]moduleKey, 0, len(sorted))
+	for _, builder := range sorted {
+		keys = append(keys, keyOf(builder))
 	}
 
-	if discoverBuilder, ok := b.builderMap[module.Discover]; ok {
-		di := discoverBuilder.Build(b.name,
-			moduleparm.WithLogger(b.logger),
-			moduleparm.WithPubsub(b.pubsub),
-		)
-		id, t := di.(discover.IDiscover)
-		if !t {
-			panic(ErrTypeConvFailed)
+	b.entries = b.entries[:0]
+	b.mods = make(map[moduleKey]interface{})
+	failed := make(map[moduleKey]bool)
+
+	for _, builder := range sorted {
+		entry := &moduleEntry{builder: builder, key: keyOf(builder)}
+		for _, dep := range builder.Depends() {
+			if key, ok := resolveDepend(keys, builder, dep); ok {
+				entry.deps = append(entry.deps, key)
+			}
 		}
 
-		b.modules = append(b.modules, id)
-	}
+		if entry.dependsOn(failed) {
+			failed[entry.key] = true
+			continue
+		}
+
+		opts := []interface{}{moduleparm.WithNode(b)}
+		for _, dep := range entry.deps {
+			opts = append(opts, moduleparm.WithModule(dep.Type, b.mods[dep]))
+		}
 
-	if electorBuilder, ok := b.builderMap[module.Elector]; ok {
-		ei := electorBuilder.Build(b.name,
-			moduleparm.WithLogger(b.logger),
-			moduleparm.WithPubsub(b.pubsub),
-		)
-		ie, t := ei.(elector.IElector)
-		if !t {
-			panic(ErrTypeConvFailed)
+		entry.mod, err = b.build(builder, opts)
+		if err != nil {
+			errs = append(errs, err)
+			failed[entry.key] = true
+			continue
 		}
-		b.modules = append(b.modules, ie)
+
+		b.mods[entry.key] = entry.mod
+		b.entries = append(b.entries, entry)
 	}
 
-	// init function module
-	if clientBuilder, ok := b.builderMap[module.Client]; ok {
+	b.logger, _ = b.lookup(module.Logger, nil).(logger.ILogger)
 
-		baseOpts := []moduleparm.Option{}
-		baseOpts = append(baseOpts, moduleparm.WithLogger(b.logger))
-		baseOpts = append(baseOpts, moduleparm.WithPubsub(b.pubsub))
-		if b.tracer != nil {
-			baseOpts = append(baseOpts, moduleparm.WithTracer(b.tracer))
-		}
-		if b.linkcache != nil {
-			baseOpts = append(baseOpts, moduleparm.WithLinkcache(b.linkcache))
+	if len(errs) > 0 {
+		b.logErrors(errs)
+		return errs
+	}
+
+	return nil
+}
+
+func (b *Braid) build(builder module.IBuilder, opts []interface{}) (mod interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &ModuleError{Module: moduleName(builder), Kind: KindBuild, Err: recoverErr(r)}
 		}
-		if b.balancer != nil {
-			baseOpts = append(baseOpts, moduleparm.WithBalancer(b.balancer))
-		} else {
-			panic(fmt.Errorf("missing required dependencies => %v", "balancer"))
+	}()
+
+	mod = builder.Build(b.name, opts...)
+	if mod == nil {
+		return nil, &ModuleError{Module: moduleName(builder), Kind: KindBuild, Err: errors.New("nil module")}
+	}
+
+	err = checkType(builder.Type(), mod)
+	if err != nil {
+		return nil, &ModuleError{Module: moduleName(builder), Kind: KindTypeConv, Err: err}
+	}
+
+	return mod, nil
+}
+
+// checkType 检查构建好的内置模块是否实现了对应类型的接口
+func checkType(ty module.ModuleType, mod interface{}) error {
+	var ok bool
+
+	switch ty {
+	case module.Logger:
+		_, ok = mod.(logger.ILogger)
+	case module.Pubsub:
+		_, ok = mod.(pubsub.IPubsub)
+	case module.Balancer:
+		_, ok = mod.(balancer.IBalancer)
+	case module.Tracer:
+		_, ok = mod.(tracer.ITracer)
+	case module.Linkcache:
+		_, ok = mod.(linkcache.ILinkCache)
+	case module.Discover:
+		_, ok = mod.(discover.IDiscover)
+	case module.Elector:
+		_, ok = mod.(elector.IElector)
+	case module.Client:
+		_, ok = mod.(client.IClient)
+	case module.Server:
+		_, ok = mod.(server.IServer)
+	case module.Metrics:
+		_, ok = mod.(metrics.IMetrics)
+	default:
+		ok = true
+	}
+
+	if !ok {
+		return fmt.Errorf("%w => %v", ErrTypeConvFailed, ty)
+	}
+
+	return nil
+}
+
+// Init braid init
+//
+// 按照依赖顺序初始化模块，初始化失败的模块以及依赖于它的模块都会被跳过。
+// 只要有模块初始化失败，已经完成初始化的模块就会按逆序关闭（回滚），并返回聚合后的错误
+func (b *Braid) Init() error {
+	var errs Errors
+	failed := make(map[moduleKey]bool)
+
+	for _, e := range b.entries {
+		if e.dependsOn(failed) {
+			failed[e.key] = true
+			continue
 		}
 
-		islice := make([]interface{}, len(baseOpts))
-		for k, v := range baseOpts {
-			islice[k] = v
+		im, ok := e.mod.(module.IModule)
+		if !ok {
+			continue
 		}
-		ci := clientBuilder.Build(b.name, islice...)
-		ic, t := ci.(client.IClient)
-		if !t {
-			panic(ErrTypeConvFailed)
+
+		err := callInit(im)
+		if err != nil {
+			errs = append(errs, &ModuleError{Module: e.name(), Kind: KindInit, Err: err})
+			failed[e.key] = true
+			continue
 		}
-		b.client = ic
-		b.modules = append(b.modules, ic)
+
+		atomic.StoreInt32(&e.state, stateInited)
 	}
 
-	if serverBuilder, ok := b.builderMap[module.Server]; ok {
+	if len(errs) > 0 {
+		errs = append(errs, b.rollback()...)
+		b.logErrors(errs)
+		return errs
+	}
 
-		baseOpts := []moduleparm.Option{}
-		baseOpts = append(baseOpts, moduleparm.WithLogger(b.logger))
-		if b.tracer != nil {
-			baseOpts = append(baseOpts, moduleparm.WithTracer(b.tracer))
-		}
+	b.inited = true
+	return nil
+}
+
+// Run 运行braid
+//
+// 如果有模块运行失败，所有已经初始化的模块都会按逆序关闭（回滚），并返回聚合后的错误
+func (b *Braid) Run() error {
+	if !b.inited {
+		return ErrNotInitialized
+	}
+
+	fmt.Printf(banner, Version)
+
+	err := b.serveHealth()
+	if err != nil {
+		errs := Errors{err}
+		errs = append(errs, b.rollback()...)
+		b.logErrors(errs)
+		return errs
+	}
 
-		islice := make([]interface{}, len(baseOpts))
-		for k, v := range baseOpts {
-			islice[k] = v
+	for _, e := range b.entries {
+		if e.state != stateInited {
+			continue
 		}
-		si := serverBuilder.Build(b.name, islice...)
-		is, t := si.(server.IServer)
-		if !t {
-			panic(ErrTypeConvFailed)
+
+		err := callRun(e.mod.(module.IModule))
+		if err != nil {
+			errs := Errors{&ModuleError{Module: e.name(), Kind: KindRun, Err: err}}
+			errs = append(errs, b.rollback()...)
+			b.logErrors(errs)
+			return errs
 		}
-		b.server = is
-		b.modules = append(b.modules, is)
+
+		atomic.StoreInt32(&e.state, stateRunning)
 	}
 
+	atomic.StoreInt32(&b.phase, phaseRunning)
 	return nil
 }
 
-// Init braid init
-func (b *Braid) Init() {
-	var err error
+// rollback 按依赖的逆序关闭所有已经初始化的模块
+func (b *Braid) rollback() Errors {
+	var errs Errors
+
+	b.inited = false
+	atomic.StoreInt32(&b.phase, phaseStopping)
+
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		e := b.entries[i]
+		if e.state != stateInited && e.state != stateRunning {
+			continue
+		}
 
-	for _, mod := range b.modules {
-		err = mod.Init()
+		err := callClose(e.mod.(module.IModule))
 		if err != nil {
-			b.logger.Errorf("braid init err %v", err.Error())
-			break
+			errs = append(errs, &ModuleError{Module: e.name(), Kind: KindClose, Err: err})
 		}
+		atomic.StoreInt32(&e.state, stateClosed)
 	}
 
+	b.stopHealth()
+	return errs
 }
 
-// Run 运行braid
-func (b *Braid) Run() {
-	fmt.Printf(banner, Version)
+func (b *Braid) logErrors(errs Errors) {
+	for _, err := range errs {
+		b.logErrorf("braid err %v", err.Error())
+	}
+}
 
-	for _, mod := range b.modules {
-		mod.Run()
+func (b *Braid) logErrorf(format string, args ...interface{}) {
+	if b.logger != nil {
+		b.logger.Errorf(format, args...)
 	}
 }
 
-// GetClient get client interface
-func Client() client.IClient {
-	if braidGlobal != nil && braidGlobal.client != nil {
-		return braidGlobal.client
+func (b *Braid) logInfof(format string, args ...interface{}) {
+	if b.logger != nil {
+		b.logger.Infof(format, args...)
 	}
+}
+
+func callInit(im module.IModule) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverErr(r)
+		}
+	}()
+
+	return im.Init()
+}
+
+func callRun(im module.IModule) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverErr(r)
+		}
+	}()
+
+	im.Run()
 	return nil
 }
 
-func Server() server.IServer {
-	return braidGlobal.server
+func callClose(im module.IModule) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverErr(r)
+		}
+	}()
+
+	im.Close()
+	return nil
 }
 
-// Mailbox pub-sub
-func Pubsub() pubsub.IPubsub {
-	return braidGlobal.pubsub
+// Name 服务名
+func (b *Braid) Name() string {
+	return b.name
 }
 
-// Tracer tracing
-func Tracer() tracer.ITracer {
-	return braidGlobal.tracer
+// lookup 获取模块实例，没有指定实例名时获取默认实例
+func (b *Braid) lookup(ty module.ModuleType, instance []string) interface{} {
+	name := ""
+	if len(instance) > 0 {
+		name = instance[0]
+	}
+
+	keys := make([]moduleKey, 0, len(b.mods))
+	for _, e := range b.entries {
+		keys = append(keys, e.key)
+	}
+
+	key, ok := lookupInstance(keys, ty, name)
+	if !ok {
+		return nil
+	}
+	return b.mods[key]
 }
 
-// Close 关闭braid
-func (b *Braid) Close() {
+// Client rpc-client，可以通过 instance 指定实例名
+func (b *Braid) Client(instance ...string) client.IClient {
+	c, _ := b.lookup(module.Client, instance).(client.IClient)
+	return c
+}
 
-	for _, mod := range b.modules {
-		mod.Close()
+// Server rpc-server，可以通过 instance 指定实例名
+func (b *Braid) Server(instance ...string) server.IServer {
+	s, _ := b.lookup(module.Server, instance).(server.IServer)
+	return s
+}
+
+// Pubsub pub-sub，可以通过 instance 指定实例名
+func (b *Braid) Pubsub(instance ...string) pubsub.IPubsub {
+	ps, _ := b.lookup(module.Pubsub, instance).(pubsub.IPubsub)
+	return ps
+}
+
+// Tracer tracing，可以通过 instance 指定实例名
+func (b *Braid) Tracer(instance ...string) tracer.ITracer {
+	t, _ := b.lookup(module.Tracer, instance).(tracer.ITracer)
+	return t
+}
+
+// Linkcache link-cache，可以通过 instance 指定实例名
+func (b *Braid) Linkcache(instance ...string) linkcache.ILinkCache {
+	lc, _ := b.lookup(module.Linkcache, instance).(linkcache.ILinkCache)
+	return lc
+}
+
+// Metrics 指标，可以通过 instance 指定实例名
+func (b *Braid) Metrics(instance ...string) metrics.IMetrics {
+	m, _ := b.lookup(module.Metrics, instance).(metrics.IMetrics)
+	return m
+}
+
+// Logger 默认的 logger
+func (b *Braid) Logger() logger.ILogger {
+	return b.logger
+}
+
+// Modules 按依赖顺序获取节点中所有构建完成的模块
+func (b *Braid) Modules() []module.ModuleInfo {
+	infos := make([]module.ModuleInfo, 0, len(b.entries))
+
+	for _, e := range b.entries {
+		infos = append(infos, module.ModuleInfo{
+			Name:     e.builder.Name(),
+			Type:     e.key.Type,
+			Instance: e.key.Instance,
+			State:    stateNames[atomic.LoadInt32(&e.state)],
+			Module:   e.mod,
+		})
 	}
 
+	return infos
+}
+
+// Close 关闭braid
+//
+// 使用默认的截止时间按阶段关闭 braid（参考 Shutdown
+func (b *Braid) Close() {
+	b.Shutdown(context.Background())
 }
@@ -0,0 +1,91 @@
+package braid
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Stage braid 关闭的阶段
+type Stage int32
+
+const (
+	// StageServer 停止接收 rpc 请求（server 模块
+	StageServer Stage = iota + 1
+
+	// StageDeregister 从服务发现中注销（discover & elector 模块
+	StageDeregister
+
+	// StageFlush 投递 pubsub 中剩余的消息，并停止 topic（pubsub 模块
+	StageFlush
+
+	// StageLoops 停止其他模块的后台循环
+	StageLoops
+
+	// StageClose 关闭所有模块，释放连接等资源
+	StageClose
+)
+
+var stageNames = map[Stage]string{
+	StageServer:     "server",
+	StageDeregister: "deregister",
+	StageFlush:      "flush",
+	StageLoops:      "loops",
+	StageClose:      "close",
+}
+
+func (s Stage) String() string {
+	if name, ok := stageNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Parm braid 配置项
+type Parm struct {
+	// StageTimeout 关闭时每个阶段的截止时间
+	StageTimeout map[Stage]time.Duration
+
+	// Signals RunContext 中用于触发关闭的信号
+	Signals []os.Signal
+
+	// HealthAddr 健康检查接口（/healthz /readyz）的监听地址，为空时不启动
+	HealthAddr string
+}
+
+// Option config wraps
+type Option func(*Parm)
+
+// WithStageTimeout 设置关闭阶段的截止时间
+func WithStageTimeout(stage Stage, timeout time.Duration) Option {
+	return func(c *Parm) {
+		c.StageTimeout[stage] = timeout
+	}
+}
+
+// WithSignals 设置 RunContext 中触发关闭的信号（默认 SIGINT SIGTERM
+func WithSignals(sigs ...os.Signal) Option {
+	return func(c *Parm) {
+		c.Signals = sigs
+	}
+}
+
+// WithHealthAddr 在 Run 时启动健康检查的 http 服务，提供 /healthz（存活）和 /readyz（就绪）接口
+func WithHealthAddr(addr string) Option {
+	return func(c *Parm) {
+		c.HealthAddr = addr
+	}
+}
+
+func defaultParm() Parm {
+	return Parm{
+		StageTimeout: map[Stage]time.Duration{
+			StageServer:     time.Second * 10,
+			StageDeregister: time.Second * 5,
+			StageFlush:      time.Second * 5,
+			StageLoops:      time.Second * 5,
+			StageClose:      time.Second * 5,
+		},
+		Signals: []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+	}
+}
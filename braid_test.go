@@ -1,18 +1,26 @@
 package braid
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/pojol/braid-go/mock"
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/discover"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/discoverconsul"
 	"github.com/pojol/braid-go/modules/electorconsul"
+	"github.com/pojol/braid-go/modules/grpcserver"
 	"github.com/pojol/braid-go/modules/linkerredis"
 	"github.com/pojol/braid-go/modules/pubsubnsq"
 	"github.com/pojol/braid-go/modules/zaplogger"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMain(m *testing.M) {
@@ -107,3 +115,380 @@ func TestMutiPubsub(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestMultiInstance(t *testing.T) {
+
+	newInstance := func(name string) *Braid {
+		b, _ := NewService(name)
+		err := b.Register(
+			Module(zaplogger.Name),
+			Module(pubsubnsq.Name,
+				pubsubnsq.WithLookupAddr([]string{mock.NSQLookupdAddr}),
+				pubsubnsq.WithNsqdAddr([]string{mock.NsqdAddr}, []string{mock.NsqdHttpAddr}),
+			),
+		)
+		assert.Equal(t, err, nil)
+		return b
+	}
+
+	b1 := newInstance("TestMultiInstance_1")
+	b2 := newInstance("TestMultiInstance_2")
+
+	// 包级别的接口使用最后创建的实例
+	assert.Equal(t, Default(), b2)
+	assert.Equal(t, Pubsub(), b2.Pubsub())
+
+	assert.NotEqual(t, b1.Pubsub(), b2.Pubsub())
+	assert.Equal(t, b2.Name(), "TestMultiInstance_2")
+
+	// 两个实例中同名的 topic 互不影响
+	b1.Pubsub().RegistTopic("TestMultiInstance", pubsub.ScopeProc)
+	b2.Pubsub().RegistTopic("TestMultiInstance", pubsub.ScopeProc)
+
+	c1 := b1.Pubsub().GetTopic("TestMultiInstance").Sub("Normal")
+	c2 := b2.Pubsub().GetTopic("TestMultiInstance").Sub("Normal")
+
+	recv1 := make(chan string, 1)
+	recv2 := make(chan string, 1)
+	c1.Arrived(func(msg *pubsub.Message) { recv1 <- string(msg.Body) })
+	c2.Arrived(func(msg *pubsub.Message) { recv2 <- string(msg.Body) })
+
+	b2.Pubsub().GetTopic("TestMultiInstance").Pub(&pubsub.Message{Body: []byte("b2")})
+
+	select {
+	case body := <-recv2:
+		assert.Equal(t, body, "b2")
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	select {
+	case <-recv1:
+		t.FailNow()
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	ctx := NewContext(context.Background(), b2)
+	assert.Equal(t, FromContext(ctx), b2)
+	assert.Equal(t, FromContext(context.Background()), Default())
+
+	old := Default()
+	SetDefault(b1)
+	assert.Equal(t, Pubsub(), b1.Pubsub())
+	SetDefault(old)
+}
+
+func TestModules(t *testing.T) {
+
+	b, _ := NewService("TestModules")
+	err := b.Register(
+		Module(zaplogger.Name),
+		Module(pubsubnsq.Name,
+			pubsubnsq.WithLookupAddr([]string{mock.NSQLookupdAddr}),
+			pubsubnsq.WithNsqdAddr([]string{mock.NsqdAddr}, []string{mock.NsqdHttpAddr}),
+		),
+		Module(AdminHTTP),
+	)
+	assert.Equal(t, err, nil)
+
+	infos := b.Modules()
+	assert.Equal(t, len(infos), 3)
+	assert.Equal(t, infos[1].Name, pubsubnsq.Name)
+	assert.Equal(t, infos[1].State, "built")
+
+	b.Pubsub().RegistTopic("TestModules", pubsub.ScopeProc)
+	b.Pubsub().GetTopic("TestModules").Sub("Normal")
+
+	byt, err := json.Marshal(infos[1].Module.(module.IIntrospect).Introspect())
+	assert.Equal(t, err, nil)
+	assert.Contains(t, string(byt), `"channels":[{"backlog":0,"capacity":0,"cluster":false,"handlers":0,"name":"Normal"}]`)
+}
+
+type dependBuilder struct {
+	name    string
+	ty      module.ModuleType
+	depends []module.Dependency
+}
+
+func (db *dependBuilder) Build(name string, buildOpts ...interface{}) interface{} { return nil }
+func (db *dependBuilder) Name() string                                            { return db.name }
+func (db *dependBuilder) Type() module.ModuleType                                 { return db.ty }
+func (db *dependBuilder) Depends() []module.Dependency                            { return db.depends }
+func (db *dependBuilder) AddModuleOption(opt interface{}) error                   { return nil }
+
+func TestSortBuilders(t *testing.T) {
+
+	client := &dependBuilder{"client", module.Client, []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Balancer},
+		{Type: module.Tracer, Optional: true},
+	}}
+	balancer := &dependBuilder{"balancer", module.Balancer, []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Pubsub},
+	}}
+	ps := &dependBuilder{"pubsub", module.Pubsub, []module.Dependency{{Type: module.Logger}}}
+	log := &dependBuilder{"logger", module.Logger, nil}
+
+	sorted, err := sortBuilders([]module.IBuilder{client, balancer, ps, log})
+	assert.Equal(t, err, nil)
+
+	names := []string{}
+	for _, b := range sorted {
+		names = append(names, b.Name())
+	}
+	assert.Equal(t, names, []string{"logger", "pubsub", "balancer", "client"})
+
+	// missing required dependency
+	_, err = sortBuilders([]module.IBuilder{client, log})
+	assert.NotEqual(t, err, nil)
+
+	// cycle
+	a := &dependBuilder{"a", module.Discover, []module.Dependency{{Type: module.Elector}}}
+	b := &dependBuilder{"b", module.Elector, []module.Dependency{{Type: module.Discover}}}
+	_, err = sortBuilders([]module.IBuilder{log, a, b})
+	assert.NotEqual(t, err, nil)
+	assert.Contains(t, err.Error(), "a -> b -> a")
+}
+
+type recorder struct {
+	sync.Mutex
+	lst []string
+}
+
+func (r *recorder) add(s string) {
+	r.Lock()
+	r.lst = append(r.lst, s)
+	r.Unlock()
+}
+
+func (r *recorder) get() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string{}, r.lst...)
+}
+
+type lifecycleModule struct {
+	name    string
+	initErr error
+	record  *recorder
+}
+
+func (lm *lifecycleModule) Init() error {
+	lm.record.add("init " + lm.name)
+	return lm.initErr
+}
+func (lm *lifecycleModule) Run()   { lm.record.add("run " + lm.name) }
+func (lm *lifecycleModule) Close() { lm.record.add("close " + lm.name) }
+
+type lifecycleBuilder struct {
+	dependBuilder
+	mod *lifecycleModule
+}
+
+func (lb *lifecycleBuilder) Build(name string, buildOpts ...interface{}) interface{} { return lb.mod }
+
+func TestLifecycleErrors(t *testing.T) {
+	b, _ := NewService("TestLifecycleErrors")
+
+	err := b.Register(Module("unknown_module"))
+	assert.Equal(t, errors.Is(err, ErrUnknownModule), true)
+
+	record := &recorder{}
+	newBuilder := func(name string, ty module.ModuleType, initErr error, deps ...module.ModuleType) module.IBuilder {
+		lb := &lifecycleBuilder{
+			dependBuilder: dependBuilder{name: name, ty: ty},
+			mod:           &lifecycleModule{name: name, initErr: initErr, record: record},
+		}
+		for _, dep := range deps {
+			lb.depends = append(lb.depends, module.Dependency{Type: dep})
+		}
+		return lb
+	}
+
+	// 第三方模块类型
+	const (
+		typeA module.ModuleType = iota + 100
+		typeB
+		typeC
+		typeD
+	)
+
+	b, _ = NewService("TestLifecycleErrors")
+	err = b.Register(
+		newBuilder("a", typeA, nil),
+		newBuilder("b", typeB, errors.New("consul unreachable")),
+		newBuilder("c", typeC, errors.New("redis unreachable"), typeA),
+		newBuilder("d", typeD, nil, typeB),
+	)
+	assert.Equal(t, err, nil)
+
+	err = b.Init()
+	merrs := ModuleErrors(err)
+	assert.Equal(t, len(merrs), 2)
+	assert.Equal(t, merrs[0].Module, "b")
+	assert.Equal(t, merrs[0].Kind, KindInit)
+	assert.Equal(t, merrs[1].Module, "c")
+
+	// d 依赖于初始化失败的 b 所以不会被初始化，a 被回滚
+	assert.Equal(t, record.get(), []string{
+		"init a",
+		"init b",
+		"init c",
+		"close a",
+	})
+
+	assert.Equal(t, b.Run(), ErrNotInitialized)
+}
+
+func TestModuleOptions(t *testing.T) {
+	b, _ := NewService("TestModuleOptions")
+
+	// 将 grpcserver 的配置项传递给了 grpcclient
+	err := b.Register(Module(LoggerZap), Module(ClientGRPC, grpcserver.WithListen(":1202")))
+	merrs := ModuleErrors(err)
+	assert.Equal(t, len(merrs), 1)
+	assert.Equal(t, merrs[0].Module, ClientGRPC)
+	assert.Equal(t, merrs[0].Kind, KindOption)
+	assert.Equal(t, errors.Is(err, module.ErrOptionMismatch), true)
+
+	var oerr *module.OptionError
+	assert.Equal(t, errors.As(err, &oerr), true)
+	assert.Equal(t, oerr.Option, "grpcserver.WithListen")
+
+	// nsqd 的 tcp 地址和 http 地址数量不一致
+	b, _ = NewService("TestModuleOptions")
+	err = b.Register(
+		Module(LoggerZap),
+		Module(PubsubNsq,
+			pubsubnsq.WithNsqdAddr([]string{"127.0.0.1:4150", "127.0.0.1:4152"}, []string{"127.0.0.1:4151"}),
+		),
+		Module(ElectorConsul, electorconsul.WithConsulAddr("")),
+	)
+	merrs = ModuleErrors(err)
+	assert.Equal(t, len(merrs), 2)
+	assert.Equal(t, merrs[0].Module, PubsubNsq)
+	assert.Equal(t, merrs[0].Kind, KindOption)
+	assert.Equal(t, merrs[1].Module, ElectorConsul)
+	assert.Equal(t, errors.Is(err, module.ErrInvalidOption), true)
+}
+
+type shutdownModule struct {
+	lifecycleModule
+	block bool
+}
+
+func (sm *shutdownModule) Shutdown(ctx context.Context) error {
+	sm.record.add("shutdown " + sm.name)
+	if sm.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	const (
+		typeA module.ModuleType = iota + 100
+		typeB
+	)
+
+	record := &recorder{}
+	b, _ := NewService("TestShutdown", WithStageTimeout(StageLoops, time.Millisecond*100))
+
+	err := b.Register(
+		&lifecycleBuilder{
+			dependBuilder: dependBuilder{name: "a", ty: typeA},
+			mod:           &lifecycleModule{name: "a", record: record},
+		},
+		&shutdownBuilder{
+			dependBuilder: dependBuilder{name: "b", ty: typeB, depends: []module.Dependency{{Type: typeA}}},
+			mod:           &shutdownModule{lifecycleModule: lifecycleModule{name: "b", record: record}, block: true},
+		},
+	)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, b.Init(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = b.RunContext(ctx)
+	merrs := ModuleErrors(err)
+	assert.Equal(t, len(merrs), 1)
+	assert.Equal(t, merrs[0].Module, "b")
+	assert.Equal(t, merrs[0].Kind, KindShutdown)
+	assert.Equal(t, errors.Is(err, context.DeadlineExceeded), true)
+
+	assert.Equal(t, record.get(), []string{
+		"init a",
+		"init b",
+		"run a",
+		"run b",
+		"shutdown b",
+		"close b",
+		"close a",
+	})
+}
+
+func TestShutdownUnfinished(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	// 忽略 ctx 的关闭函数在截止时间之后仍然在后台执行
+	report := ShutdownReport{
+		runStage(ctx, StageLoops, "a", func() error { return nil }),
+		runStage(ctx, StageLoops, "b", func() error {
+			<-release
+			return nil
+		}),
+	}
+
+	assert.Equal(t, report[0].Unfinished, false)
+	assert.Equal(t, report[1].Unfinished, true)
+	assert.Equal(t, errors.Is(report[1].Err, context.DeadlineExceeded), true)
+	assert.Equal(t, report.Unfinished(), []string{"b/loops"})
+}
+
+type shutdownBuilder struct {
+	dependBuilder
+	mod *shutdownModule
+}
+
+func (sb *shutdownBuilder) Build(name string, buildOpts ...interface{}) interface{} { return sb.mod }
+
+func TestMemoryStack(t *testing.T) {
+	b, _ := NewService("TestMemoryStack")
+
+	// 不依赖 nsq 的完整模块栈
+	err := b.Register(
+		Module(LoggerZap),
+		Module(PubsubMemory),
+		Module(BalancerSWRR),
+		Module(ClientGRPC),
+		Module(ServerGRPC, grpcserver.WithListen(":14230")),
+	)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, b.Init(), nil)
+	assert.Equal(t, b.Run(), nil)
+
+	var arrived uint64
+	b.Pubsub().GetTopic(discover.ServiceUpdate).Sub("TestMemoryStack").Arrived(func(msg *pubsub.Message) {
+		atomic.AddUint64(&arrived, 1)
+	})
+	b.Pubsub().GetTopic(discover.ServiceUpdate).Pub(discover.EncodeUpdateMsg(
+		discover.EventAddService,
+		discover.Node{ID: "node_1", Name: "TestMemoryStack", Address: "127.0.0.1:14230"},
+	))
+
+	for i := 0; i < 100 && atomic.LoadUint64(&arrived) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, atomic.LoadUint64(&arrived), uint64(1))
+	assert.Equal(t, b.Health().Ready, true)
+
+	report := b.Shutdown(context.Background())
+	assert.Equal(t, report.Err(), nil)
+}
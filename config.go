@@ -0,0 +1,479 @@
+package braid
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pojol/braid-go/module"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrConfigUnknownKey 配置中存在无法识别的字段
+	ErrConfigUnknownKey = errors.New("unknown key")
+
+	// ErrConfigType 配置的值与期望的类型不匹配
+	ErrConfigType = errors.New("type mismatch")
+)
+
+const (
+	// EnvModules 通过环境变量声明模块列表，例如 BRAID_MODULES=ZapLogger,PubsubNsq,PubsubNsq:local
+	EnvModules = "BRAID_MODULES"
+
+	// EnvPrefix 通过环境变量设置（或覆盖）模块的配置项 BRAID_<MODULE>[_<INSTANCE>]_<KEY>
+	EnvPrefix = "BRAID_"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ConfigError 配置文件中的错误
+type ConfigError struct {
+	// Source 配置的来源（文件名或环境变量名
+	Source string
+
+	// Line 错误所在的行（环境变量为 0
+	Line int
+
+	Module string
+	Key    string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	pos := e.Source
+	if e.Line > 0 {
+		pos = fmt.Sprintf("%v:%d", e.Source, e.Line)
+	}
+
+	switch {
+	case e.Module != "" && e.Key != "":
+		return fmt.Sprintf("%v: module %v option %v: %v", pos, e.Module, e.Key, e.Err)
+	case e.Module != "":
+		return fmt.Sprintf("%v: module %v: %v", pos, e.Module, e.Err)
+	case e.Key != "":
+		return fmt.Sprintf("%v: %v: %v", pos, e.Key, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", pos, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// moduleConfig 配置中的一个模块
+type moduleConfig struct {
+	name     string
+	instance string
+	binds    map[module.ModuleType]string
+	options  map[string]interface{}
+
+	// 配置项的声明顺序，保证生成的 Option 顺序与配置一致
+	keys []string
+}
+
+// LoadConfig 从 YAML 或 JSON 文件以及环境变量中读取模块配置，并生成对应的构建器列表
+//
+// path 为空时只使用环境变量（BRAID_MODULES 声明模块列表），
+// 环境变量 BRAID_<MODULE>[_<INSTANCE>]_<KEY> 会覆盖配置文件中同名的配置项，值使用 YAML 语法（例如 [a, b]
+//
+//	modules:
+//	  - module: PubsubNsq
+//	    instance: local          # 可选，实例名
+//	    bind: {logger: main}     # 可选，依赖的模块实例
+//	    options:
+//	      lookup_addr: ["127.0.0.1:4161"]
+//	      nsqd_addr: [["127.0.0.1:4150"], ["127.0.0.1:4151"]]
+func LoadConfig(path string) ([]module.IBuilder, error) {
+	var mcs []*moduleConfig
+	var errs Errors
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		mcs, errs = parseConfig(path, data)
+	}
+
+	mcs, envErrs := parseEnv(mcs, os.Environ())
+	errs = append(errs, envErrs...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return configBuilders(mcs), nil
+}
+
+// ParseConfig 从 YAML 或 JSON 格式的数据中读取模块配置，并生成对应的构建器列表（不读取环境变量
+func ParseConfig(data []byte) ([]module.IBuilder, error) {
+	mcs, errs := parseConfig("config", data)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return configBuilders(mcs), nil
+}
+
+func configBuilders(mcs []*moduleConfig) []module.IBuilder {
+	builders := make([]module.IBuilder, 0, len(mcs))
+
+	for _, mc := range mcs {
+		opts := []interface{}{}
+		if mc.instance != "" {
+			opts = append(opts, WithInstance(mc.instance))
+		}
+		for ty, instance := range mc.binds {
+			opts = append(opts, WithBind(ty, instance))
+		}
+		for _, key := range mc.keys {
+			opts = append(opts, mc.options[key])
+		}
+
+		builders = append(builders, Module(mc.name, opts...))
+	}
+
+	return builders
+}
+
+func parseConfig(source string, data []byte) ([]*moduleConfig, Errors) {
+	var doc yaml.Node
+	var errs Errors
+
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, Errors{&ConfigError{Source: source, Err: err}}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, Errors{configErr(source, root, "", "", fmt.Errorf("%w: expect mapping", ErrConfigType))}
+	}
+
+	var mcs []*moduleConfig
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key, val := root.Content[i], root.Content[i+1]
+		if key.Value != "modules" {
+			errs = append(errs, configErr(source, key, "", key.Value, ErrConfigUnknownKey))
+			continue
+		}
+
+		if val.Kind != yaml.SequenceNode {
+			errs = append(errs, configErr(source, val, "", "modules", fmt.Errorf("%w: expect list", ErrConfigType)))
+			continue
+		}
+
+		for _, node := range val.Content {
+			mc, merrs := parseModule(source, node)
+			errs = append(errs, merrs...)
+			if mc != nil {
+				mcs = append(mcs, mc)
+			}
+		}
+	}
+
+	return mcs, errs
+}
+
+func parseModule(source string, node *yaml.Node) (*moduleConfig, Errors) {
+	var errs Errors
+
+	if node.Kind != yaml.MappingNode {
+		return nil, Errors{configErr(source, node, "", "", fmt.Errorf("%w: module expect mapping", ErrConfigType))}
+	}
+
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		switch key.Value {
+		case "module", "instance", "bind", "options":
+			fields[key.Value] = node.Content[i+1]
+		default:
+			errs = append(errs, configErr(source, key, "", key.Value, ErrConfigUnknownKey))
+		}
+	}
+
+	name, ok := fields["module"]
+	if !ok || name.Kind != yaml.ScalarNode || name.Value == "" {
+		return nil, append(errs, configErr(source, node, "", "module", errors.New("module name is required")))
+	}
+	if module.GetBuilder(name.Value) == nil {
+		return nil, append(errs, configErr(source, name, name.Value, "", ErrUnknownModule))
+	}
+
+	mc := newModuleConfig(name.Value)
+
+	if instance, ok := fields["instance"]; ok {
+		if instance.Kind != yaml.ScalarNode || instance.Tag != "!!str" {
+			errs = append(errs, configErr(source, instance, mc.name, "instance", fmt.Errorf("%w: expect string", ErrConfigType)))
+		}
+		mc.instance = instance.Value
+	}
+
+	if bind, ok := fields["bind"]; ok {
+		errs = append(errs, mc.parseBind(source, bind)...)
+	}
+
+	if opts, ok := fields["options"]; ok {
+		if opts.Kind != yaml.MappingNode {
+			errs = append(errs, configErr(source, opts, mc.name, "options", fmt.Errorf("%w: expect mapping", ErrConfigType)))
+		} else {
+			for i := 0; i < len(opts.Content); i += 2 {
+				key, val := opts.Content[i], opts.Content[i+1]
+				err := mc.setOption(key.Value, val)
+				if err != nil {
+					line := val
+					if errors.Is(err, ErrConfigUnknownKey) {
+						line = key
+					}
+					errs = append(errs, configErr(source, line, mc.name, key.Value, err))
+				}
+			}
+		}
+	}
+
+	return mc, errs
+}
+
+func newModuleConfig(name string) *moduleConfig {
+	return &moduleConfig{
+		name:    name,
+		binds:   make(map[module.ModuleType]string),
+		options: make(map[string]interface{}),
+	}
+}
+
+func (mc *moduleConfig) parseBind(source string, node *yaml.Node) Errors {
+	if node.Kind != yaml.MappingNode {
+		return Errors{configErr(source, node, mc.name, "bind", fmt.Errorf("%w: expect mapping", ErrConfigType))}
+	}
+
+	var errs Errors
+	for i := 0; i < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+
+		ty, ok := module.ParseType(key.Value)
+		if !ok {
+			errs = append(errs, configErr(source, key, mc.name, "bind."+key.Value, ErrConfigUnknownKey))
+			continue
+		}
+		if val.Kind != yaml.ScalarNode || val.Tag != "!!str" {
+			errs = append(errs, configErr(source, val, mc.name, "bind."+key.Value, fmt.Errorf("%w: expect string", ErrConfigType)))
+			continue
+		}
+
+		mc.binds[ty] = val.Value
+	}
+
+	return errs
+}
+
+// setOption 将配置的值转换为模块的 Option
+func (mc *moduleConfig) setOption(key string, node *yaml.Node) error {
+	fn, ok := module.GetOptions(mc.name)[key]
+	if !ok {
+		return ErrConfigUnknownKey
+	}
+
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+
+	args := make([]reflect.Value, 0, ft.NumIn())
+	if ft.NumIn() == 1 {
+		arg, err := decodeValue(node, ft.In(0))
+		if err != nil {
+			return err
+		}
+		args = append(args, arg)
+	} else {
+		if node.Kind != yaml.SequenceNode || len(node.Content) != ft.NumIn() {
+			return fmt.Errorf("%w: expect list of %d values", ErrConfigType, ft.NumIn())
+		}
+		for i, n := range node.Content {
+			arg, err := decodeValue(n, ft.In(i))
+			if err != nil {
+				return err
+			}
+			args = append(args, arg)
+		}
+	}
+
+	if _, ok := mc.options[key]; !ok {
+		mc.keys = append(mc.keys, key)
+	}
+	mc.options[key] = fv.Call(args)[0].Interface()
+
+	return nil
+}
+
+// decodeValue 将配置的值转换为 Option 函数的参数类型
+func decodeValue(node *yaml.Node, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+
+	mismatch := func(expect string) error {
+		return fmt.Errorf("%w: expect %v, got %v", ErrConfigType, expect, strings.TrimPrefix(node.Tag, "!!"))
+	}
+
+	if t.Kind() == reflect.Slice {
+		if node.Kind != yaml.SequenceNode {
+			return v, mismatch("list")
+		}
+		for _, n := range node.Content {
+			elem, err := decodeValue(n, t.Elem())
+			if err != nil {
+				return v, err
+			}
+			v = reflect.Append(v, elem)
+		}
+		return v, nil
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		return v, mismatch(t.Kind().String())
+	}
+
+	if t == durationType {
+		if node.Tag != "!!str" {
+			return v, mismatch("duration")
+		}
+		d, err := time.ParseDuration(node.Value)
+		if err != nil {
+			return v, fmt.Errorf("%w: %v", ErrConfigType, err)
+		}
+		v.SetInt(int64(d))
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if node.Tag != "!!str" {
+			return v, mismatch("string")
+		}
+		v.SetString(node.Value)
+	case reflect.Bool:
+		if node.Tag != "!!bool" {
+			return v, mismatch("bool")
+		}
+		b, _ := strconv.ParseBool(node.Value)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(node.Value, 0, 64)
+		if node.Tag != "!!int" || err != nil || v.OverflowInt(n) {
+			return v, mismatch(t.Kind().String())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(node.Value, 0, 64)
+		if node.Tag != "!!int" || err != nil || v.OverflowUint(n) {
+			return v, mismatch(t.Kind().String())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(node.Value, 64)
+		if (node.Tag != "!!float" && node.Tag != "!!int") || err != nil {
+			return v, mismatch("float")
+		}
+		v.SetFloat(f)
+	default:
+		return v, fmt.Errorf("%w: unsupported option type %v", ErrConfigType, t)
+	}
+
+	return v, nil
+}
+
+// parseEnv 读取环境变量中的模块声明和配置项
+func parseEnv(mcs []*moduleConfig, environ []string) ([]*moduleConfig, Errors) {
+	var errs Errors
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if idx := strings.Index(kv, "="); idx > 0 {
+			env[kv[:idx]] = kv[idx+1:]
+		}
+	}
+
+	if modules, ok := env[EnvModules]; ok {
+		for _, decl := range strings.Split(modules, ",") {
+			decl = strings.TrimSpace(decl)
+			if decl == "" {
+				continue
+			}
+
+			name, instance := decl, ""
+			if idx := strings.Index(decl, ":"); idx >= 0 {
+				name, instance = decl[:idx], decl[idx+1:]
+			}
+
+			if module.GetBuilder(name) == nil {
+				errs = append(errs, &ConfigError{Source: EnvModules, Module: name, Err: ErrUnknownModule})
+				continue
+			}
+			if findModuleConfig(mcs, name, instance) != nil {
+				continue
+			}
+
+			mc := newModuleConfig(name)
+			mc.instance = instance
+			mcs = append(mcs, mc)
+		}
+	}
+
+	for _, mc := range mcs {
+		prefix := EnvPrefix + strings.ToUpper(mc.name) + "_"
+		if mc.instance != "" {
+			prefix += strings.ToUpper(mc.instance) + "_"
+		}
+
+		keys := []string{}
+		for key := range module.GetOptions(mc.name) {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			envKey := prefix + strings.ToUpper(key)
+			val, ok := env[envKey]
+			if !ok {
+				continue
+			}
+
+			var doc yaml.Node
+			err := yaml.Unmarshal([]byte(val), &doc)
+			if err == nil && len(doc.Content) == 0 {
+				err = errors.New("empty value")
+			}
+			if err == nil {
+				err = mc.setOption(key, doc.Content[0])
+			}
+			if err != nil {
+				errs = append(errs, &ConfigError{Source: envKey, Module: mc.name, Key: key, Err: err})
+			}
+		}
+	}
+
+	return mcs, errs
+}
+
+func findModuleConfig(mcs []*moduleConfig, name, instance string) *moduleConfig {
+	for _, mc := range mcs {
+		if strings.EqualFold(mc.name, name) && mc.instance == instance {
+			return mc
+		}
+	}
+	return nil
+}
+
+func configErr(source string, node *yaml.Node, mod, key string, err error) *ConfigError {
+	return &ConfigError{Source: source, Line: node.Line, Module: mod, Key: key, Err: err}
+}
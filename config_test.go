@@ -0,0 +1,117 @@
+package braid
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/modules/zaplogger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+
+	builders, err := ParseConfig([]byte(`
+modules:
+  - module: ZapLogger
+  - module: PubsubNsq
+    options:
+      lookup_addr: ["127.0.0.1:4161"]
+      nsqd_addr: [["127.0.0.1:4150"], ["127.0.0.1:4151"]]
+      handler_concurrent: 2
+  - module: PubsubNsq
+    instance: local
+`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(builders), 3)
+	assert.Equal(t, keyOf(builders[2]), moduleKey{Type: module.Pubsub, Instance: "local"})
+
+	b, _ := NewService("TestParseConfig")
+	assert.Equal(t, b.Register(builders...), nil)
+	assert.NotEqual(t, b.Pubsub("local"), nil)
+
+	// json
+	builders, err = ParseConfig([]byte(`{
+  "modules": [
+    {"module": "ZapLogger", "options": {"lv": 1}}
+  ]
+}`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, builders[0].Name(), zaplogger.Name)
+}
+
+func TestParseConfigErrors(t *testing.T) {
+
+	_, err := ParseConfig([]byte(`
+modules:
+  - module: PubsubNsq
+    options:
+      lookup_addr: "127.0.0.1:4161"
+      unknown_key: 1
+      handler_concurrent: abc
+  - module: UnknownModule
+  - module: ZapLogger
+    instance: log
+    bind: {unknown: x}
+service: gate
+`))
+
+	var es Errors
+	assert.Equal(t, errors.As(err, &es), true)
+	assert.Equal(t, len(es), 6)
+
+	msgs := []string{
+		"config:5: module PubsubNsq option lookup_addr: type mismatch: expect list, got str",
+		"config:6: module PubsubNsq option unknown_key: unknown key",
+		"config:7: module PubsubNsq option handler_concurrent: type mismatch: expect int32, got str",
+		"config:8: module UnknownModule: unknown module",
+		"config:11: module ZapLogger option bind.unknown: unknown key",
+		"config:12: service: unknown key",
+	}
+	for k, msg := range msgs {
+		assert.Equal(t, es[k].Error(), msg)
+	}
+
+	assert.Equal(t, errors.Is(err, ErrConfigType), true)
+	assert.Equal(t, errors.Is(err, ErrUnknownModule), true)
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "braid")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "braid.yml")
+	ioutil.WriteFile(path, []byte(`
+modules:
+  - module: ZapLogger
+  - module: PubsubNsq
+    options:
+      lookup_addr: ["127.0.0.1:4161"]
+`), 0644)
+
+	os.Setenv("BRAID_PUBSUBNSQ_LOOKUP_ADDR", "[127.0.0.1:4261, 127.0.0.1:4361]")
+	os.Setenv("BRAID_MODULES", "PubsubNsq:local")
+	os.Setenv("BRAID_PUBSUBNSQ_LOCAL_HANDLER_CONCURRENT", "4")
+	defer func() {
+		os.Unsetenv("BRAID_PUBSUBNSQ_LOOKUP_ADDR")
+		os.Unsetenv("BRAID_MODULES")
+		os.Unsetenv("BRAID_PUBSUBNSQ_LOCAL_HANDLER_CONCURRENT")
+	}()
+
+	builders, err := LoadConfig(path)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(builders), 3)
+
+	b, _ := NewService("TestLoadConfig")
+	assert.Equal(t, b.Register(builders...), nil)
+	assert.NotEqual(t, b.Pubsub("local"), nil)
+
+	os.Setenv("BRAID_PUBSUBNSQ_LOCAL_HANDLER_CONCURRENT", "four")
+	_, err = LoadConfig(path)
+	assert.Equal(t, err.Error(), "BRAID_PUBSUBNSQ_LOCAL_HANDLER_CONCURRENT: module PubsubNsq option handler_concurrent: type mismatch: expect int32, got str")
+
+}
@@ -0,0 +1,142 @@
+package braid
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pojol/braid-go/module"
+)
+
+// sortBuilders 按照模块之间的依赖关系对构建器进行拓扑排序
+//
+// 排序是稳定的，相互之间没有依赖关系的模块会保持注册时的顺序。
+// 缺少必需的依赖，或者依赖之间形成了环都会返回错误。
+func sortBuilders(builders []module.IBuilder) ([]module.IBuilder, error) {
+
+	keys := make([]moduleKey, 0, len(builders))
+	provider := make(map[moduleKey]int)
+	for k, b := range builders {
+		keys = append(keys, keyOf(b))
+		provider[keyOf(b)] = k
+	}
+
+	// edges[i] 依赖于第 i 个构建器的构建器列表
+	edges := make([][]int, len(builders))
+	indegree := make([]int, len(builders))
+	var errs Errors
+
+	for k, b := range builders {
+		for _, dep := range b.Depends() {
+			key, ok := resolveDepend(keys, b, dep)
+			if !ok {
+				// 显式绑定的实例不存在时，即使是可选依赖也视为错误
+				if want := dependKey(b, dep); !dep.Optional || want.Instance != "" {
+					errs = append(errs, &ModuleError{
+						Module: moduleName(b),
+						Kind:   KindMissingDependency,
+						Err:    fmt.Errorf("required => %v", want),
+					})
+				}
+				continue
+			}
+
+			idx := provider[key]
+
+			edges[idx] = append(edges[idx], k)
+			indegree[k]++
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	sorted := make([]module.IBuilder, 0, len(builders))
+	visited := make([]bool, len(builders))
+
+	for len(sorted) < len(builders) {
+		next := -1
+		for k := range builders {
+			if !visited[k] && indegree[k] == 0 {
+				next = k
+				break
+			}
+		}
+
+		if next == -1 {
+			cycle := findCycle(builders, keys, provider, visited)
+			return nil, &ModuleError{
+				Module: cycle[0],
+				Kind:   KindDependencyCycle,
+				Err:    fmt.Errorf("%v", strings.Join(cycle, " -> ")),
+			}
+		}
+
+		visited[next] = true
+		sorted = append(sorted, builders[next])
+		for _, k := range edges[next] {
+			indegree[k]--
+		}
+	}
+
+	return sorted, nil
+}
+
+// findCycle 在未能完成排序的构建器中找出一条依赖环路，用于输出错误信息
+func findCycle(builders []module.IBuilder, keys []moduleKey, provider map[moduleKey]int, sorted []bool) []string {
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make([]int, len(builders))
+	var path []int
+	var cycle []int
+
+	var walk func(k int) bool
+	walk = func(k int) bool {
+		state[k] = visiting
+		path = append(path, k)
+
+		for _, dep := range builders[k].Depends() {
+			key, ok := resolveDepend(keys, builders[k], dep)
+			if !ok || sorted[provider[key]] {
+				continue
+			}
+			idx := provider[key]
+
+			if state[idx] == visiting {
+				for i, p := range path {
+					if p == idx {
+						cycle = append(cycle, path[i:]...)
+						cycle = append(cycle, idx)
+						return true
+					}
+				}
+			}
+
+			if state[idx] == unvisited && walk(idx) {
+				return true
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[k] = done
+		return false
+	}
+
+	for k := range builders {
+		if !sorted[k] && state[k] == unvisited && walk(k) {
+			break
+		}
+	}
+
+	names := make([]string, 0, len(cycle))
+	for _, k := range cycle {
+		names = append(names, moduleName(builders[k]))
+	}
+
+	return names
+}
@@ -0,0 +1,143 @@
+package braid
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrTypeConvFailed = errors.New("type conversion failed")
+
+	// ErrUnknownModule 没有找到对应名称的模块构建器
+	ErrUnknownModule = errors.New("unknown module")
+)
+
+// ErrKind 模块错误的类型
+type ErrKind int32
+
+const (
+	// KindUnknownModule 注册了一个不存在的模块
+	KindUnknownModule ErrKind = iota + 1
+
+	// KindMissingDependency 缺少必需的依赖模块
+	KindMissingDependency
+
+	// KindDependencyCycle 模块之间的依赖形成了环
+	KindDependencyCycle
+
+	// KindTypeConv 构建出的模块没有实现对应类型的接口
+	KindTypeConv
+
+	// KindBuild 模块构建失败
+	KindBuild
+
+	// KindInit 模块初始化失败
+	KindInit
+
+	// KindRun 模块运行失败
+	KindRun
+
+	// KindClose 模块关闭失败
+	KindClose
+
+	// KindShutdown 模块在关闭阶段中停止失败（或超时
+	KindShutdown
+
+	// KindOption 模块的配置项不属于该模块，或者配置项不合法
+	KindOption
+)
+
+var kindNames = map[ErrKind]string{
+	KindUnknownModule:     "unknown module",
+	KindMissingDependency: "missing dependency",
+	KindDependencyCycle:   "dependency cycle",
+	KindTypeConv:          "type conversion",
+	KindBuild:             "build",
+	KindInit:              "init",
+	KindRun:               "run",
+	KindClose:             "close",
+	KindShutdown:          "shutdown",
+	KindOption:            "option",
+}
+
+func (k ErrKind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind(%d)", int32(k))
+}
+
+// ModuleError 某个模块在 braid 的某个阶段中产生的错误
+type ModuleError struct {
+	Module string
+	Kind   ErrKind
+	Err    error
+}
+
+func (e *ModuleError) Error() string {
+	return fmt.Sprintf("module %v %v failed: %v", e.Module, e.Kind, e.Err)
+}
+
+func (e *ModuleError) Unwrap() error {
+	return e.Err
+}
+
+// Errors 一个阶段中所有模块产生的错误的集合
+type Errors []error
+
+func (es Errors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, err := range es {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is 集合中任意一个错误匹配 target 即视为匹配
+func (es Errors) Is(target error) bool {
+	for _, err := range es {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As 将集合中第一个匹配 target 类型的错误赋值给 target
+func (es Errors) As(target interface{}) bool {
+	for _, err := range es {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleErrors 获取错误中所包含的所有模块错误
+func ModuleErrors(err error) []*ModuleError {
+	var merrs []*ModuleError
+
+	var es Errors
+	if errors.As(err, &es) {
+		for _, e := range es {
+			merrs = append(merrs, ModuleErrors(e)...)
+		}
+		return merrs
+	}
+
+	var me *ModuleError
+	if errors.As(err, &me) {
+		merrs = append(merrs, me)
+	}
+
+	return merrs
+}
+
+// recoverErr 将 panic 的内容转换为 error
+func recoverErr(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
@@ -0,0 +1,83 @@
+package braid
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/pojol/braid-go/module/rpc/client"
+	"github.com/pojol/braid-go/module/rpc/server"
+	"github.com/pojol/braid-go/module/tracer"
+)
+
+type braidCtxKey struct{}
+
+var (
+	defaultBraid *Braid
+	defaultLock  sync.RWMutex
+)
+
+// NewContext 将 braid 实例保存到 ctx 中
+func NewContext(ctx context.Context, b *Braid) context.Context {
+	return context.WithValue(ctx, braidCtxKey{}, b)
+}
+
+// FromContext 获取保存在 ctx 中的 braid 实例
+//
+// 如果 ctx 中没有 braid 实例，则返回默认实例（可能为 nil
+func FromContext(ctx context.Context) *Braid {
+	if b, ok := ctx.Value(braidCtxKey{}).(*Braid); ok {
+		return b
+	}
+	return Default()
+}
+
+// SetDefault 设置默认的 braid 实例
+//
+// 包级别的 Client() Server() Pubsub() Tracer() 接口都是通过默认实例获取的，
+// 这些接口仅用于兼容旧的代码，在同一进程中运行多个 braid 实例时应使用实例上的接口
+func SetDefault(b *Braid) {
+	defaultLock.Lock()
+	defaultBraid = b
+	defaultLock.Unlock()
+}
+
+// Default 获取默认的 braid 实例（进程中最后被创建的实例，或者之后通过 SetDefault 设置的实例
+func Default() *Braid {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+
+	return defaultBraid
+}
+
+// Client 获取默认实例的 rpc-client
+func Client(instance ...string) client.IClient {
+	if b := Default(); b != nil {
+		return b.Client(instance...)
+	}
+	return nil
+}
+
+// Server 获取默认实例的 rpc-server
+func Server(instance ...string) server.IServer {
+	if b := Default(); b != nil {
+		return b.Server(instance...)
+	}
+	return nil
+}
+
+// Pubsub 获取默认实例的 pub-sub
+func Pubsub(instance ...string) pubsub.IPubsub {
+	if b := Default(); b != nil {
+		return b.Pubsub(instance...)
+	}
+	return nil
+}
+
+// Tracer 获取默认实例的 tracing
+func Tracer(instance ...string) tracer.ITracer {
+	if b := Default(); b != nil {
+		return b.Tracer(instance...)
+	}
+	return nil
+}
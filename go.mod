@@ -22,6 +22,7 @@ require (
 	github.com/uber/jaeger-client-go v2.22.1+incompatible
 	github.com/uber/jaeger-lib v2.2.0+incompatible
 	github.com/valyala/fasttemplate v1.1.0 // indirect
+	github.com/vmihailenco/msgpack/v4 v4.3.12
 	go.uber.org/atomic v1.5.1 // indirect
 	go.uber.org/zap v1.13.0
 	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a // indirect
@@ -31,6 +32,7 @@ require (
 	golang.org/x/tools v0.0.0-20200513154647-78b527d18275 // indirect
 	google.golang.org/grpc v1.29.1
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	k8s.io/apimachinery v0.19.2
 	k8s.io/client-go v0.19.2
 )
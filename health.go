@@ -0,0 +1,131 @@
+package braid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pojol/braid-go/module"
+)
+
+const (
+	phaseInit int32 = iota
+	phaseRunning
+	phaseStopping
+)
+
+// ModuleHealth 模块的健康状况
+type ModuleHealth struct {
+	Module      string    `json:"module"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// HealthReport braid 的健康状况
+type HealthReport struct {
+	// Live 存活，braid 还没有开始关闭
+	Live bool `json:"live"`
+
+	// Ready 就绪，braid 正在运行，并且所有实现了 module.IHealth 的模块都处于 up 或 degraded 状态
+	Ready bool `json:"ready"`
+
+	Modules []ModuleHealth `json:"modules"`
+}
+
+// Health 汇总所有模块的健康状况
+func (b *Braid) Health() HealthReport {
+	phase := atomic.LoadInt32(&b.phase)
+
+	report := HealthReport{
+		Live:    phase != phaseStopping,
+		Ready:   phase == phaseRunning,
+		Modules: []ModuleHealth{},
+	}
+
+	for _, e := range b.entries {
+		ih, ok := e.mod.(module.IHealth)
+		if !ok {
+			continue
+		}
+
+		h := ih.Health()
+		mh := ModuleHealth{
+			Module:      e.name(),
+			Status:      h.Status.String(),
+			LastSuccess: h.LastSuccess,
+		}
+		if h.LastErr != nil {
+			mh.Error = h.LastErr.Error()
+		}
+
+		if h.Status != module.HealthUp && h.Status != module.HealthDegraded {
+			report.Ready = false
+		}
+
+		report.Modules = append(report.Modules, mh)
+	}
+
+	return report
+}
+
+// HealthHandler 健康检查的 http 接口
+//
+// /healthz 存活检查，/readyz 就绪检查，检查通过返回 200，否则返回 503，body 为 json 格式的 HealthReport
+func (b *Braid) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	write := func(w http.ResponseWriter, ok bool, report HealthReport) {
+		w.Header().Set("Content-Type", "application/json")
+		if ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		report := b.Health()
+		write(w, report.Live, report)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := b.Health()
+		write(w, report.Ready, report)
+	})
+
+	return mux
+}
+
+// serveHealth 启动健康检查的 http 服务（需要通过 WithHealthAddr 设置监听地址
+func (b *Braid) serveHealth() error {
+	if b.parm.HealthAddr == "" || b.healthSrv != nil {
+		return nil
+	}
+
+	lis, err := net.Listen("tcp", b.parm.HealthAddr)
+	if err != nil {
+		return fmt.Errorf("braid health listen %v err %w", b.parm.HealthAddr, err)
+	}
+
+	b.healthSrv = &http.Server{Handler: b.HealthHandler()}
+	go b.healthSrv.Serve(lis)
+
+	return nil
+}
+
+func (b *Braid) stopHealth() {
+	if b.healthSrv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	b.healthSrv.Shutdown(ctx)
+	b.healthSrv = nil
+}
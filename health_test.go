@@ -0,0 +1,90 @@
+package braid
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/stretchr/testify/assert"
+)
+
+type healthModule struct {
+	lifecycleModule
+	health module.HealthRecorder
+}
+
+func (hm *healthModule) Health() module.Health {
+	return hm.health.Health()
+}
+
+type healthBuilder struct {
+	dependBuilder
+	mod *healthModule
+}
+
+func (hb *healthBuilder) Build(name string, buildOpts ...interface{}) interface{} { return hb.mod }
+
+func TestHealth(t *testing.T) {
+	record := &recorder{}
+	hm := &healthModule{lifecycleModule: lifecycleModule{name: "redis", record: record}}
+
+	b, _ := NewService("TestHealth")
+	err := b.Register(&healthBuilder{
+		dependBuilder: dependBuilder{name: "redis", ty: module.ModuleType(100)},
+		mod:           hm,
+	})
+	assert.Equal(t, err, nil)
+
+	srv := httptest.NewServer(b.HealthHandler())
+	defer srv.Close()
+
+	get := func(path string) (int, HealthReport) {
+		var report HealthReport
+		res, err := http.Get(srv.URL + path)
+		assert.Equal(t, err, nil)
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&report)
+		return res.StatusCode, report
+	}
+
+	// 还没有运行
+	code, _ := get("/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	code, _ = get("/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+
+	assert.Equal(t, b.Init(), nil)
+	assert.Equal(t, b.Run(), nil)
+
+	// 模块还没有报告过状态
+	code, report := get("/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, report.Modules[0].Status, "unknown")
+
+	hm.health.Record(nil)
+	code, report = get("/readyz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, report.Modules[0].Module, "redis")
+	assert.Equal(t, report.Modules[0].Status, "up")
+
+	hm.health.Record(errors.New("connection refused"))
+	code, report = get("/readyz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, report.Modules[0].Status, "down")
+	assert.Equal(t, report.Modules[0].Error, "connection refused")
+	code, _ = get("/healthz")
+	assert.Equal(t, code, http.StatusOK)
+
+	// 恢复后保留最近一次的错误
+	hm.health.Record(nil)
+	h := hm.Health()
+	assert.Equal(t, h.Status, module.HealthUp)
+	assert.Equal(t, h.LastErr.Error(), "connection refused")
+
+	b.Close()
+	code, _ = get("/healthz")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+}
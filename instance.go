@@ -0,0 +1,118 @@
+package braid
+
+import (
+	"fmt"
+
+	"github.com/pojol/braid-go/module"
+)
+
+// ModuleOption 用于描述模块实例的选项（区别于模块自身的 Option，这些选项由 braid 处理
+type ModuleOption func(*namedBuilder)
+
+// WithInstance 为模块指定实例名
+//
+// 同一类型的模块可以注册多个实例（例如基于 nsq 的集群 pubsub 和进程内的 pubsub），
+// 没有指定实例名的模块即为该类型的默认实例。
+func WithInstance(instance string) ModuleOption {
+	return func(nb *namedBuilder) {
+		nb.instance = instance
+	}
+}
+
+// WithBind 指定模块所依赖的某个类型的模块实例
+//
+// 没有指定时依赖默认实例，如果没有默认实例且该类型只注册了一个实例，则依赖这个实例
+func WithBind(ty module.ModuleType, instance string) ModuleOption {
+	return func(nb *namedBuilder) {
+		if nb.binds == nil {
+			nb.binds = make(map[module.ModuleType]string)
+		}
+		nb.binds[ty] = instance
+	}
+}
+
+// namedBuilder 带有实例名和依赖绑定的构建器
+type namedBuilder struct {
+	module.IBuilder
+
+	instance string
+	binds    map[module.ModuleType]string
+}
+
+// Validate 转发给实际的构建器
+func (nb *namedBuilder) Validate() error {
+	if v, ok := nb.IBuilder.(module.IValidator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// moduleKey 模块实例的标识
+type moduleKey struct {
+	Type     module.ModuleType
+	Instance string
+}
+
+func (k moduleKey) String() string {
+	if k.Instance == "" {
+		return k.Type.String()
+	}
+	return fmt.Sprintf("%v(%v)", k.Type, k.Instance)
+}
+
+func keyOf(builder module.IBuilder) moduleKey {
+	key := moduleKey{Type: builder.Type()}
+	if nb, ok := builder.(*namedBuilder); ok {
+		key.Instance = nb.instance
+	}
+	return key
+}
+
+// moduleName 用于日志和错误信息中的模块名
+func moduleName(builder module.IBuilder) string {
+	if nb, ok := builder.(*namedBuilder); ok && nb.instance != "" {
+		return fmt.Sprintf("%v(%v)", builder.Name(), nb.instance)
+	}
+	return builder.Name()
+}
+
+// resolveDepend 查找构建器的依赖所对应的模块实例
+func resolveDepend(keys []moduleKey, builder module.IBuilder, dep module.Dependency) (moduleKey, bool) {
+	instance := ""
+	if nb, ok := builder.(*namedBuilder); ok {
+		instance = nb.binds[dep.Type]
+	}
+	return lookupInstance(keys, dep.Type, instance)
+}
+
+// dependKey 依赖描述在错误信息中的名字
+func dependKey(builder module.IBuilder, dep module.Dependency) moduleKey {
+	key := moduleKey{Type: dep.Type}
+	if nb, ok := builder.(*namedBuilder); ok {
+		key.Instance = nb.binds[dep.Type]
+	}
+	return key
+}
+
+// lookupInstance 在 keys 中查找指定类型的模块实例
+//
+// 没有指定实例名时查找默认实例，如果没有默认实例且该类型只有一个实例，则返回这个实例
+func lookupInstance(keys []moduleKey, ty module.ModuleType, instance string) (moduleKey, bool) {
+	var candidates []moduleKey
+
+	for _, k := range keys {
+		if k.Type != ty {
+			continue
+		}
+		if k.Instance == instance {
+			return k, true
+		}
+		candidates = append(candidates, k)
+	}
+
+	if instance == "" && len(candidates) == 1 {
+		return candidates[0], true
+	}
+
+	return moduleKey{}, false
+}
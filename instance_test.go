@@ -0,0 +1,87 @@
+package braid
+
+import (
+	"testing"
+
+	"github.com/pojol/braid-go/mock"
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/modules/moduleparm"
+	"github.com/pojol/braid-go/modules/pubsubnsq"
+	"github.com/pojol/braid-go/modules/zaplogger"
+	"github.com/stretchr/testify/assert"
+)
+
+const bindTestName = "BindTest"
+
+// bindBuilder 记录构建时注入的 pubsub 实例
+type bindBuilder struct {
+	dependBuilder
+	parm moduleparm.BuildParm
+}
+
+func (bb *bindBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+	for _, opt := range buildOpts {
+		opt.(moduleparm.Option)(&bb.parm)
+	}
+	return &bb.parm
+}
+
+func init() {
+	module.Register(func() module.IBuilder {
+		return &bindBuilder{
+			dependBuilder: dependBuilder{
+				name:    bindTestName,
+				ty:      module.ModuleType(200),
+				depends: []module.Dependency{{Type: module.Pubsub}},
+			},
+		}
+	})
+}
+
+func TestNamedInstances(t *testing.T) {
+
+	nsqOpts := []interface{}{
+		pubsubnsq.WithLookupAddr([]string{mock.NSQLookupdAddr}),
+		pubsubnsq.WithNsqdAddr([]string{mock.NsqdAddr}, []string{mock.NsqdHttpAddr}),
+	}
+
+	b, _ := NewService("TestNamedInstances")
+	bind := Module(bindTestName, WithBind(module.Pubsub, "local"))
+	err := b.Register(
+		Module(zaplogger.Name),
+		bind,
+		Module(pubsubnsq.Name, nsqOpts...),
+		Module(pubsubnsq.Name, append(nsqOpts, WithInstance("local"))...),
+	)
+	assert.Equal(t, err, nil)
+
+	assert.NotEqual(t, b.Pubsub(), nil)
+	assert.NotEqual(t, b.Pubsub("local"), nil)
+	assert.Equal(t, b.Pubsub() == b.Pubsub("local"), false)
+	assert.Equal(t, b.Pubsub("unknown"), nil)
+
+	// 绑定到指定的实例
+	assert.Equal(t, bind.(*namedBuilder).IBuilder.(*bindBuilder).parm.PS == b.Pubsub("local"), true)
+
+	// 绑定的实例不存在
+	b, _ = NewService("TestNamedInstances")
+	err = b.Register(
+		Module(zaplogger.Name),
+		Module(pubsubnsq.Name, nsqOpts...),
+		Module(bindTestName, WithBind(module.Pubsub, "cluster")),
+	)
+	merrs := ModuleErrors(err)
+	assert.Equal(t, len(merrs), 1)
+	assert.Equal(t, merrs[0].Kind, KindMissingDependency)
+	assert.Equal(t, merrs[0].Error(), "module BindTest missing dependency failed: required => pubsub(cluster)")
+
+	// 只有一个命名实例时，未指定实例名的依赖和接口都会使用这个实例
+	b, _ = NewService("TestNamedInstances")
+	err = b.Register(
+		Module(zaplogger.Name),
+		Module(pubsubnsq.Name, append(nsqOpts, WithInstance("local"))...),
+		Module(bindTestName),
+	)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, b.Pubsub(), b.Pubsub("local"))
+}
@@ -1,6 +1,7 @@
 package braidsync
 
 import (
+	"context"
 	"sync"
 )
 
@@ -15,3 +16,19 @@ func (w *WaitGroupWrapper) Wrap(cb func()) {
 		w.Done()
 	}()
 }
+
+// WaitContext 等待所有的 goroutine 退出，或者 ctx 结束
+func (w *WaitGroupWrapper) WaitContext(ctx context.Context) error {
+	exit := make(chan struct{})
+	go func() {
+		w.Wait()
+		close(exit)
+	}()
+
+	select {
+	case <-exit:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,220 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// ErrClosed 队列已经关闭
+var ErrClosed = errors.New("queue closed")
+
+// MsgQueue 带有容量上限以及溢出策略的消息队列（容量为 0 时不限制长度
+//
+// 消费者通过 Pop 获取消息，队列为空时等待 Notify
+type MsgQueue struct {
+	sync.Mutex
+
+	// space 队列中有空余的位置时关闭（并替换为新的 chan），唤醒所有阻塞的发布者
+	space chan struct{}
+
+	msgs   []*pubsub.Message
+	parm   pubsub.ChannelParm
+	spill  *spillFile
+	closed bool
+
+	// warned 是否已经触发过积压警告（回落到阈值以下后重置
+	warned bool
+	onWarn func(backlog int)
+
+	notify chan struct{}
+}
+
+// NewMsgQueue 构建 MsgQueue，onWarn 在积压的消息数达到 parm.BacklogWarning 时调用
+func NewMsgQueue(parm pubsub.ChannelParm, onWarn func(backlog int)) *MsgQueue {
+	q := &MsgQueue{
+		parm:   parm,
+		onWarn: onWarn,
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}),
+	}
+
+	return q
+}
+
+// signalSpace 唤醒阻塞的发布者（调用方持有锁
+func (q *MsgQueue) signalSpace() {
+	close(q.space)
+	q.space = make(chan struct{})
+}
+
+func (q *MsgQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *MsgQueue) full() bool {
+	return q.parm.Capacity > 0 && len(q.msgs) >= q.parm.Capacity
+}
+
+func (q *MsgQueue) len() int {
+	n := len(q.msgs)
+	if q.spill != nil {
+		n += q.spill.count
+	}
+	return n
+}
+
+// Put 添加一条消息，返回因为溢出而被丢弃的消息
+//
+// OverflowBlock 策略下会阻塞直到队列中有空余的位置或者队列关闭
+func (q *MsgQueue) Put(msg *pubsub.Message) (*pubsub.Message, error) {
+	return q.PutContext(context.Background(), msg)
+}
+
+// PutContext 同 Put，OverflowBlock 策略下阻塞时 ctx 结束返回 ctx 的错误
+func (q *MsgQueue) PutContext(ctx context.Context, msg *pubsub.Message) (*pubsub.Message, error) {
+	var dropped *pubsub.Message
+
+	q.Lock()
+
+	if q.parm.Overflow == pubsub.OverflowBlock {
+		for q.full() && !q.closed {
+			space := q.space
+			q.Unlock()
+
+			select {
+			case <-space:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+			q.Lock()
+		}
+	}
+	if q.closed {
+		q.Unlock()
+		return nil, ErrClosed
+	}
+
+	switch {
+	case q.parm.Overflow == pubsub.OverflowSpill && (q.full() || (q.spill != nil && q.spill.count > 0)):
+		// 已经有消息写入磁盘时，新的消息也需要写入磁盘以保证顺序
+		if q.spill == nil {
+			sf, err := newSpillFile(q.parm.SpillDir)
+			if err != nil {
+				q.Unlock()
+				return nil, err
+			}
+			q.spill = sf
+		}
+		if err := q.spill.push(msg); err != nil {
+			q.Unlock()
+			return nil, err
+		}
+	case q.full() && q.parm.Overflow == pubsub.OverflowDropNewest:
+		dropped = msg
+	case q.full() && q.parm.Overflow == pubsub.OverflowDropOldest:
+		dropped = q.msgs[0]
+		q.msgs[0] = nil
+		q.msgs = append(q.msgs[1:], msg)
+	default:
+		q.msgs = append(q.msgs, msg)
+	}
+
+	backlog := q.len()
+	warn := q.parm.BacklogWarning > 0 && backlog >= q.parm.BacklogWarning && !q.warned
+	if warn {
+		q.warned = true
+	}
+	q.Unlock()
+
+	q.wake()
+	if warn && q.onWarn != nil {
+		q.onWarn(backlog)
+	}
+
+	return dropped, nil
+}
+
+// Pop 取出队列头部的消息，队列为空时返回 false
+func (q *MsgQueue) Pop() (*pubsub.Message, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.msgs) == 0 {
+		q.load()
+	}
+	if len(q.msgs) == 0 {
+		return nil, false
+	}
+
+	msg := q.msgs[0]
+	q.msgs[0] = nil
+	q.msgs = q.msgs[1:]
+	q.load()
+
+	if q.warned && q.len() < q.parm.BacklogWarning {
+		q.warned = false
+	}
+
+	q.signalSpace()
+
+	// 还有剩余的消息时唤醒其他的消费者
+	if len(q.msgs) > 0 {
+		q.wake()
+	}
+
+	return msg, true
+}
+
+// load 从磁盘中读回一条消息（无法读取的消息会被丢弃
+func (q *MsgQueue) load() {
+	for q.spill != nil && q.spill.count > 0 {
+		smsg, err := q.spill.pop()
+		if err == nil {
+			q.msgs = append(q.msgs, smsg)
+			return
+		}
+	}
+}
+
+// Notify 队列中有新消息时收到通知
+func (q *MsgQueue) Notify() <-chan struct{} {
+	return q.notify
+}
+
+// Len 积压的消息数（包括写入磁盘的消息
+func (q *MsgQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.len()
+}
+
+// Close 关闭队列，唤醒阻塞的发布者并删除磁盘上的文件
+func (q *MsgQueue) Close() {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+
+	if q.spill != nil {
+		q.spill.close()
+		q.spill = nil
+	}
+
+	q.signalSpace()
+}
+
+// Capacity 队列的容量（0 表示不限制
+func (q *MsgQueue) Capacity() int {
+	return q.parm.Capacity
+}
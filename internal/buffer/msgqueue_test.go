@@ -0,0 +1,126 @@
+package buffer
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMsg(i int) *pubsub.Message {
+	return &pubsub.Message{Body: []byte(strconv.Itoa(i)), Attempts: 1}
+}
+
+func popAll(q *MsgQueue) []string {
+	var bodies []string
+	for {
+		msg, ok := q.Pop()
+		if !ok {
+			return bodies
+		}
+		bodies = append(bodies, string(msg.Body))
+	}
+}
+
+func TestMsgQueueDrop(t *testing.T) {
+	q := NewMsgQueue(pubsub.NewChannelParm(pubsub.WithCapacity(2, pubsub.OverflowDropNewest)), nil)
+	for i := 0; i < 3; i++ {
+		dropped, err := q.Put(newMsg(i))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, dropped != nil, i == 2)
+	}
+	assert.Equal(t, popAll(q), []string{"0", "1"})
+
+	q = NewMsgQueue(pubsub.NewChannelParm(pubsub.WithCapacity(2, pubsub.OverflowDropOldest)), nil)
+	for i := 0; i < 3; i++ {
+		q.Put(newMsg(i))
+	}
+	assert.Equal(t, popAll(q), []string{"1", "2"})
+}
+
+func TestMsgQueueBlock(t *testing.T) {
+	q := NewMsgQueue(pubsub.NewChannelParm(pubsub.WithCapacity(1, pubsub.OverflowBlock)), nil)
+	q.Put(newMsg(0))
+
+	done := make(chan struct{})
+	go func() {
+		q.Put(newMsg(1))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("put should block")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	q.Pop()
+	<-done
+	assert.Equal(t, q.Len(), 1)
+
+	// 关闭后唤醒阻塞的发布者
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		q.Close()
+	}()
+	_, err := q.Put(newMsg(2))
+	assert.Equal(t, err, ErrClosed)
+}
+
+func TestMsgQueuePutContext(t *testing.T) {
+	q := NewMsgQueue(pubsub.NewChannelParm(pubsub.WithCapacity(1, pubsub.OverflowBlock)), nil)
+	q.Put(newMsg(0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	_, err := q.PutContext(ctx, newMsg(1))
+	assert.Equal(t, err, context.DeadlineExceeded)
+	assert.Equal(t, q.Len(), 1)
+
+	q.Pop()
+	_, err = q.PutContext(context.Background(), newMsg(2))
+	assert.Equal(t, err, nil)
+}
+
+func TestMsgQueueSpill(t *testing.T) {
+	q := NewMsgQueue(pubsub.NewChannelParm(
+		pubsub.WithCapacity(2, pubsub.OverflowSpill),
+		pubsub.WithSpillDir(t.TempDir()),
+	), nil)
+	defer q.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := q.Put(newMsg(i))
+		assert.Equal(t, err, nil)
+	}
+	assert.Equal(t, q.Len(), 5)
+
+	msg, _ := q.Pop()
+	assert.Equal(t, string(msg.Body), "0")
+	q.Put(newMsg(5))
+
+	assert.Equal(t, popAll(q), []string{"1", "2", "3", "4", "5"})
+	assert.Equal(t, q.Len(), 0)
+}
+
+func TestMsgQueueWarning(t *testing.T) {
+	var warns []int
+	q := NewMsgQueue(pubsub.NewChannelParm(pubsub.WithBacklogWarning(2, nil)), func(backlog int) {
+		warns = append(warns, backlog)
+	})
+
+	for i := 0; i < 3; i++ {
+		q.Put(newMsg(i))
+	}
+	assert.Equal(t, warns, []int{2})
+
+	// 回落到阈值以下后重新计算
+	q.Pop()
+	q.Pop()
+	q.Put(newMsg(3))
+	assert.Equal(t, warns, []int{2, 2})
+}
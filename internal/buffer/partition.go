@@ -0,0 +1,75 @@
+package buffer
+
+import "sync"
+
+// Partitions 按照分区键排队的消息
+//
+// 同一个分区同一时间只有一个处理者：第一条进入空闲分区的消息的调用方成为处理者，
+// 之后进入的消息排在分区中，由处理者按照进入的顺序依次取出，分区为空时处理者释放分区
+type Partitions struct {
+	sync.Mutex
+
+	// pending 正在被处理的分区中排队的消息（分区存在即表示有处理者
+	pending map[string][]interface{}
+	size    int
+}
+
+// NewPartitions 构建 Partitions
+func NewPartitions() *Partitions {
+	return &Partitions{pending: make(map[string][]interface{})}
+}
+
+// Enter 将 v 放入分区 key，分区空闲时返回 true（调用方成为分区的处理者，v 不会进入排队）
+func (p *Partitions) Enter(key string, v interface{}) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	queue, ok := p.pending[key]
+	if !ok {
+		p.pending[key] = nil
+		return true
+	}
+
+	p.pending[key] = append(queue, v)
+	p.size++
+	return false
+}
+
+// Next 处理者取出分区中的下一条消息，分区中没有消息时释放分区并返回 false
+func (p *Partitions) Next(key string) (interface{}, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	queue := p.pending[key]
+	if len(queue) == 0 {
+		delete(p.pending, key)
+		return nil, false
+	}
+
+	v := queue[0]
+	queue[0] = nil
+	p.pending[key] = queue[1:]
+	p.size--
+
+	return v, true
+}
+
+// Range 遍历在分区中排队的消息（fn 在持有锁时调用，不能再调用 Partitions 的方法
+func (p *Partitions) Range(fn func(v interface{})) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, queue := range p.pending {
+		for _, v := range queue {
+			fn(v)
+		}
+	}
+}
+
+// Len 在分区中排队的消息数
+func (p *Partitions) Len() int {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.size
+}
@@ -0,0 +1,42 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitions(t *testing.T) {
+	p := NewPartitions()
+
+	assert.Equal(t, p.Enter("a", 1), true)
+	assert.Equal(t, p.Enter("a", 2), false)
+	assert.Equal(t, p.Enter("a", 3), false)
+	assert.Equal(t, p.Enter("b", 4), true)
+	assert.Equal(t, p.Len(), 2)
+
+	v, ok := p.Next("a")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, v, 2)
+
+	v, ok = p.Next("a")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, v, 3)
+
+	_, ok = p.Next("a")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, p.Len(), 0)
+
+	// 分区释放后重新进入的消息成为新的处理者
+	assert.Equal(t, p.Enter("a", 5), true)
+
+	_, ok = p.Next("b")
+	assert.Equal(t, ok, false)
+
+	p.Enter("a", 6)
+	p.Enter("b", 7)
+	p.Enter("b", 8)
+	sum := 0
+	p.Range(func(v interface{}) { sum += v.(int) })
+	assert.Equal(t, sum, 6+8)
+}
@@ -0,0 +1,89 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// spillFile 写入磁盘的消息，格式为 长度(4, big endian) + 投递次数(4, big endian) + pubsub.Encode
+type spillFile struct {
+	f *os.File
+
+	roff  int64
+	woff  int64
+	count int
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	f, err := ioutil.TempFile(dir, "braid-spill-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spillFile{f: f}, nil
+}
+
+func (s *spillFile) push(msg *pubsub.Message) error {
+	data := pubsub.Encode(msg)
+
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:], uint32(msg.Attempts))
+	copy(buf[8:], data)
+
+	if _, err := s.f.WriteAt(buf, s.woff); err != nil {
+		return err
+	}
+
+	s.woff += int64(len(buf))
+	s.count++
+	return nil
+}
+
+func (s *spillFile) pop() (*pubsub.Message, error) {
+	var head [8]byte
+	if _, err := s.f.ReadAt(head[:], s.roff); err != nil {
+		// 文件已经损坏，丢弃剩余的消息
+		s.reset()
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(head[:]))
+	if _, err := s.f.ReadAt(data, s.roff+8); err != nil {
+		s.reset()
+		return nil, err
+	}
+
+	s.roff += int64(8 + len(data))
+	s.count--
+
+	// 全部读回之后清空文件
+	if s.count == 0 {
+		s.reset()
+	}
+
+	msg, err := pubsub.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	msg.Attempts = int(binary.BigEndian.Uint32(head[4:]))
+
+	return msg, nil
+}
+
+func (s *spillFile) reset() {
+	s.roff, s.woff, s.count = 0, 0, 0
+	s.f.Truncate(0)
+}
+
+func (s *spillFile) close() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
@@ -0,0 +1,106 @@
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Delayer 基于时间轮的延迟任务调度（例如进程内的延迟消息），在第一次调用 After 时才创建时间轮
+//
+// 到期的任务在单独的 goroutine 中执行，不会阻塞时间轮中的其他任务；
+// 任务的 ctx 带有 timeout 的超时，并且在 Stop 时取消
+type Delayer struct {
+	tick    time.Duration
+	slots   int
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	sync.Mutex
+	wheel   *TimeWheel
+	stopped bool
+
+	running sync.WaitGroup
+}
+
+// NewDelayer 创建 Delayer，timeout 为每个任务的超时时间（0 表示只在 Stop 时取消
+func NewDelayer(tick time.Duration, slotNum int, timeout time.Duration) *Delayer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Delayer{
+		tick:    tick,
+		slots:   slotNum,
+		timeout: timeout,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// After 在 delay 之后执行 fn，已经停止时返回 false
+func (d *Delayer) After(delay time.Duration, fn func(ctx context.Context)) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.stopped {
+		return false
+	}
+	if d.wheel == nil {
+		d.wheel = New(d.tick, d.slots)
+	}
+
+	d.wheel.After(delay, func() {
+		d.Lock()
+		if d.stopped {
+			d.Unlock()
+			return
+		}
+		d.running.Add(1)
+		d.Unlock()
+
+		go func() {
+			defer d.running.Done()
+
+			ctx, cancel := d.ctx, context.CancelFunc(func() {})
+			if d.timeout > 0 {
+				ctx, cancel = context.WithTimeout(d.ctx, d.timeout)
+			}
+			defer cancel()
+
+			fn(ctx)
+		}()
+	})
+
+	return true
+}
+
+// Len 等待执行的任务数
+func (d *Delayer) Len() int {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.wheel == nil {
+		return 0
+	}
+	return d.wheel.Len()
+}
+
+// Stop 停止调度，未到期的任务不再执行；取消正在执行的任务的 ctx，并等待它们返回
+func (d *Delayer) Stop() {
+	d.Lock()
+	if d.stopped {
+		d.Unlock()
+		return
+	}
+	d.stopped = true
+	wheel := d.wheel
+	d.Unlock()
+
+	d.cancel()
+	if wheel != nil {
+		wheel.Stop()
+	}
+
+	d.running.Wait()
+}
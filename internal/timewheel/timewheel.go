@@ -0,0 +1,115 @@
+// Package timewheel 时间轮，用于大量延迟任务的调度（例如进程内的延迟消息
+package timewheel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pojol/braid-go/internal/braidsync"
+)
+
+type task struct {
+	rounds int
+	fn     func()
+}
+
+// TimeWheel 单层时间轮，超过一圈的任务通过圈数记录
+//
+// 任务的精度为 tick，到期的任务在时间轮的协程中按顺序执行（不应该长时间阻塞
+type TimeWheel struct {
+	tick  time.Duration
+	slots [][]*task
+	pos   int
+	count int
+
+	sync.Mutex
+
+	done *braidsync.Switch
+	wg   braidsync.WaitGroupWrapper
+}
+
+// New 创建时间轮并开始转动
+func New(tick time.Duration, slotNum int) *TimeWheel {
+	tw := &TimeWheel{
+		tick:  tick,
+		slots: make([][]*task, slotNum),
+		done:  braidsync.NewSwitch(),
+	}
+
+	tw.wg.Wrap(tw.loop)
+	return tw
+}
+
+// After 在 delay 之后执行 fn（向上取整到 tick，至少等待一个 tick
+func (tw *TimeWheel) After(delay time.Duration, fn func()) {
+	ticks := int((delay + tw.tick - 1) / tw.tick)
+	if ticks <= 0 {
+		ticks = 1
+	}
+
+	tw.Lock()
+	defer tw.Unlock()
+
+	n := len(tw.slots)
+	idx := (tw.pos + ticks) % n
+	tw.slots[idx] = append(tw.slots[idx], &task{rounds: (ticks - 1) / n, fn: fn})
+	tw.count++
+}
+
+// Len 等待执行的任务数
+func (tw *TimeWheel) Len() int {
+	tw.Lock()
+	defer tw.Unlock()
+
+	return tw.count
+}
+
+func (tw *TimeWheel) loop() {
+	ticker := time.NewTicker(tw.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, fn := range tw.advance() {
+				fn()
+			}
+		case <-tw.done.Done():
+			return
+		}
+	}
+}
+
+// advance 转动一格，返回到期的任务
+func (tw *TimeWheel) advance() []func() {
+	tw.Lock()
+	defer tw.Unlock()
+
+	tw.pos = (tw.pos + 1) % len(tw.slots)
+
+	var expired []func()
+	remain := tw.slots[tw.pos][:0]
+	for _, t := range tw.slots[tw.pos] {
+		if t.rounds > 0 {
+			t.rounds--
+			remain = append(remain, t)
+			continue
+		}
+		expired = append(expired, t.fn)
+	}
+
+	// 清理被移除的任务，避免持有引用
+	for i := len(remain); i < len(tw.slots[tw.pos]); i++ {
+		tw.slots[tw.pos][i] = nil
+	}
+	tw.slots[tw.pos] = remain
+	tw.count -= len(expired)
+
+	return expired
+}
+
+// Stop 停止时间轮，未到期的任务不再执行
+func (tw *TimeWheel) Stop() {
+	tw.done.Open()
+	tw.wg.Wait()
+}
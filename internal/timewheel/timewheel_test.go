@@ -0,0 +1,80 @@
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeWheel(t *testing.T) {
+	tw := New(time.Millisecond*5, 4)
+	defer tw.Stop()
+
+	var lock sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+
+	begin := time.Now()
+	// 超过一圈的任务
+	for i, delay := range []int{60, 10, 30} {
+		i := i
+		wg.Add(1)
+		tw.After(time.Duration(delay)*time.Millisecond, func() {
+			lock.Lock()
+			order = append(order, i)
+			lock.Unlock()
+			wg.Done()
+		})
+	}
+	assert.Equal(t, tw.Len(), 3)
+
+	wg.Wait()
+	assert.Equal(t, order, []int{1, 2, 0})
+	assert.Equal(t, time.Since(begin) >= time.Millisecond*55, true)
+	assert.Equal(t, tw.Len(), 0)
+}
+
+func TestDelayer(t *testing.T) {
+	d := NewDelayer(time.Millisecond*5, 4, time.Millisecond*50)
+
+	// 阻塞的任务不影响其他任务的执行，超时后 ctx 结束
+	blocked := make(chan error)
+	d.After(time.Millisecond*5, func(ctx context.Context) {
+		<-ctx.Done()
+		blocked <- ctx.Err()
+	})
+
+	done := make(chan struct{})
+	d.After(time.Millisecond*10, func(ctx context.Context) {
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("task blocked by the previous one")
+	}
+	assert.Equal(t, <-blocked, context.DeadlineExceeded)
+
+	d.Stop()
+
+	// Stop 取消正在执行的任务并等待它们返回，之后的任务不再执行
+	d = NewDelayer(time.Millisecond*5, 4, 0)
+
+	running := make(chan struct{})
+	var canceled int32
+	d.After(time.Millisecond, func(ctx context.Context) {
+		close(running)
+		<-ctx.Done()
+		atomic.StoreInt32(&canceled, 1)
+	})
+	<-running
+
+	d.Stop()
+	assert.Equal(t, atomic.LoadInt32(&canceled), int32(1))
+	assert.Equal(t, d.After(time.Millisecond, func(ctx context.Context) {}), false)
+}
@@ -4,8 +4,6 @@
 package discover
 
 import (
-	"encoding/json"
-
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/pubsub"
 )
@@ -40,20 +38,19 @@ type UpdateMsg struct {
 }
 
 func EncodeUpdateMsg(event string, nod Node) *pubsub.Message {
-	byt, _ := json.Marshal(&UpdateMsg{
+	msg, _ := pubsub.Marshal(pubsub.ContentTypeJSON, &UpdateMsg{
 		Event: event,
 		Nod:   nod,
 	})
 
-	return &pubsub.Message{
-		Body: byt,
-	}
+	return msg
 }
 
-func DecodeUpdateMsg(msg *pubsub.Message) UpdateMsg {
+// DecodeUpdateMsg 解码服务变更消息，无法解码时返回 error（参考 pubsub.DecodeError
+func DecodeUpdateMsg(msg *pubsub.Message) (UpdateMsg, error) {
 	dmsg := UpdateMsg{}
-	json.Unmarshal(msg.Body, &dmsg)
-	return dmsg
+	err := pubsub.Unmarshal(msg, &dmsg)
+	return dmsg, err
 }
 
 // IDiscover discover interface
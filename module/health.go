@@ -0,0 +1,100 @@
+package module
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HealthStatus 模块的健康状态
+type HealthStatus int32
+
+const (
+	// HealthUnknown 还没有任何检查结果
+	HealthUnknown HealthStatus = iota
+
+	// HealthUp 模块工作正常
+	HealthUp
+
+	// HealthDegraded 模块可以工作，但是部分功能不可用（不影响就绪状态
+	HealthDegraded
+
+	// HealthDown 模块不可用
+	HealthDown
+)
+
+var healthNames = map[HealthStatus]string{
+	HealthUnknown:  "unknown",
+	HealthUp:       "up",
+	HealthDegraded: "degraded",
+	HealthDown:     "down",
+}
+
+func (hs HealthStatus) String() string {
+	if name, ok := healthNames[hs]; ok {
+		return name
+	}
+	return fmt.Sprintf("health(%d)", int32(hs))
+}
+
+// Health 模块的健康状况
+type Health struct {
+	Status HealthStatus
+
+	// LastErr 最近一次检查失败的错误（恢复后依旧保留，用于排查问题
+	LastErr error
+
+	// LastSuccess 最近一次检查成功的时间
+	LastSuccess time.Time
+}
+
+// IHealth 可选接口，用于向 braid 报告模块的健康状况
+//
+// Health 会被健康检查接口频繁调用，实现中不应该有阻塞的操作
+type IHealth interface {
+	Health() Health
+}
+
+// HealthRecorder 记录模块后台任务的执行结果，用于实现 IHealth
+type HealthRecorder struct {
+	sync.Mutex
+
+	lastErr     error
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+// Record 记录一次执行结果，err 为 nil 视为成功
+func (hr *HealthRecorder) Record(err error) {
+	hr.Lock()
+	defer hr.Unlock()
+
+	if err != nil {
+		hr.lastErr = err
+		hr.lastFailure = time.Now()
+	} else {
+		hr.lastSuccess = time.Now()
+	}
+}
+
+// Health 最近一次的执行结果决定模块的健康状态
+func (hr *HealthRecorder) Health() Health {
+	hr.Lock()
+	defer hr.Unlock()
+
+	h := Health{
+		LastErr:     hr.lastErr,
+		LastSuccess: hr.lastSuccess,
+	}
+
+	switch {
+	case hr.lastSuccess.IsZero() && hr.lastFailure.IsZero():
+		h.Status = HealthUnknown
+	case hr.lastFailure.After(hr.lastSuccess):
+		h.Status = HealthDown
+	default:
+		h.Status = HealthUp
+	}
+
+	return h
+}
@@ -0,0 +1,94 @@
+// 接口文件 metrics 指标，用于统计模块运行时的计数、状态和耗时
+//
+// 指标按照 name 和 labelNames 注册，通过 With(labelValues...) 获取某一组标签值的指标，
+// 标签值需要与注册时的 labelNames 一一对应。对于频繁调用的路径，应该预先获取并保存 With 的结果。
+package metrics
+
+import (
+	"github.com/pojol/braid-go/module"
+)
+
+// DefBuckets 默认的直方图分桶（单位 秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ICounter 只增不减的计数器
+type ICounter interface {
+	Inc()
+	Add(v float64)
+}
+
+// IGauge 可以任意设置的数值
+type IGauge interface {
+	Set(v float64)
+	Add(v float64)
+}
+
+// IHistogram 直方图，用于统计耗时等数值的分布
+type IHistogram interface {
+	Observe(v float64)
+}
+
+// ICounterVec 带标签的计数器
+type ICounterVec interface {
+	With(labelValues ...string) ICounter
+}
+
+// IGaugeVec 带标签的数值
+type IGaugeVec interface {
+	With(labelValues ...string) IGauge
+}
+
+// IHistogramVec 带标签的直方图
+type IHistogramVec interface {
+	With(labelValues ...string) IHistogram
+}
+
+// IMetrics metrics interface
+type IMetrics interface {
+	module.IModule
+
+	// Counter 注册（或获取已经注册的）计数器
+	Counter(name, help string, labelNames ...string) ICounterVec
+
+	// Gauge 注册（或获取已经注册的）数值
+	Gauge(name, help string, labelNames ...string) IGaugeVec
+
+	// Histogram 注册（或获取已经注册的）直方图，buckets 为空时使用 DefBuckets
+	Histogram(name, help string, buckets []float64, labelNames ...string) IHistogramVec
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Init() error { return nil }
+func (nopMetrics) Run()        {}
+func (nopMetrics) Close()      {}
+
+func (nopMetrics) Counter(name, help string, labelNames ...string) ICounterVec {
+	return nopCounterVec{}
+}
+func (nopMetrics) Gauge(name, help string, labelNames ...string) IGaugeVec {
+	return nopGaugeVec{}
+}
+func (nopMetrics) Histogram(name, help string, buckets []float64, labelNames ...string) IHistogramVec {
+	return nopHistogramVec{}
+}
+
+type nopCounterVec struct{}
+type nopGaugeVec struct{}
+type nopHistogramVec struct{}
+
+func (nopCounterVec) With(labelValues ...string) ICounter     { return nop{} }
+func (nopGaugeVec) With(labelValues ...string) IGauge         { return nop{} }
+func (nopHistogramVec) With(labelValues ...string) IHistogram { return nop{} }
+
+type nop struct{}
+
+func (nop) Inc()              {}
+func (nop) Add(v float64)     {}
+func (nop) Set(v float64)     {}
+func (nop) Observe(v float64) {}
+
+// Nop 不做任何事情的 metrics，用于没有注册 metrics 模块时
+func Nop() IMetrics {
+	return nopMetrics{}
+}
@@ -1,6 +1,8 @@
 package module
 
 import (
+	"context"
+	"fmt"
 	"strings"
 )
 
@@ -16,16 +18,60 @@ const (
 	Server
 	Pubsub
 	Logger
+	Admin
+	Metrics
 )
 
+var typeNames = map[ModuleType]string{
+	Discover:  "discover",
+	Balancer:  "balancer",
+	Elector:   "elector",
+	Linkcache: "linkcache",
+	Tracer:    "tracer",
+	Client:    "client",
+	Server:    "server",
+	Pubsub:    "pubsub",
+	Logger:    "logger",
+	Admin:     "admin",
+	Metrics:   "metrics",
+}
+
+func (mt ModuleType) String() string {
+	if name, ok := typeNames[mt]; ok {
+		return name
+	}
+	return fmt.Sprintf("module(%d)", int32(mt))
+}
+
+// Dependency 模块的依赖描述
+type Dependency struct {
+	// Type 依赖的模块类型
+	Type ModuleType
+
+	// Optional 可选依赖
+	//
+	// 可选依赖没有注册时会被忽略，注册了则同样会排在当前模块之前构建
+	Optional bool
+}
+
 // Builder builder
 type IBuilder interface {
 	Build(name string, buildOpts ...interface{}) interface{}
 
 	Name() string
+
+	// Type 构建出的模块所提供的类型
 	Type() ModuleType
 
-	AddModuleOption(opt interface{})
+	// Depends 构建模块时需要的其他模块
+	//
+	// braid 会依据依赖关系对模块进行排序，按依赖顺序执行 Build/Init/Run，并按逆序执行 Close
+	Depends() []Dependency
+
+	// AddModuleOption 添加模块的配置项
+	//
+	// 配置项不属于该模块时返回 OptionError（ErrOptionMismatch
+	AddModuleOption(opt interface{}) error
 }
 
 // IModule module
@@ -44,19 +90,85 @@ type IModule interface {
 	Close()
 }
 
+// IShutdown 可选接口，用于在 braid 关闭时按阶段停止模块
+//
+// 实现了这个接口的模块会在 Close 之前被调用 Shutdown，
+// 模块需要在这里停止对外提供的服务和后台循环，并在 ctx 结束时尽快返回
+type IShutdown interface {
+	Shutdown(ctx context.Context) error
+}
+
+// IIntrospect 可选接口，用于在 admin 等工具中查看模块的配置和运行时状态
+//
+// 返回值需要能够被序列化为 json
+type IIntrospect interface {
+	Introspect() interface{}
+}
+
+// ModuleInfo 节点中一个模块的信息
+type ModuleInfo struct {
+	Name     string
+	Type     ModuleType
+	Instance string
+
+	// State 模块所处的阶段 built inited running closed
+	State string
+
+	Module interface{}
+}
+
+// INode 节点的只读视图，用于获取节点中所有的模块
+type INode interface {
+	Name() string
+
+	Modules() []ModuleInfo
+}
+
+// BuilderCreator 构建器的创建函数
+type BuilderCreator func() IBuilder
+
 var (
-	m = make(map[string]IBuilder)
+	m = make(map[string]BuilderCreator)
 )
 
-// Register 注册balancer
-func Register(b IBuilder) {
-	m[strings.ToLower(b.Name())] = b
+// Register 注册构建器的创建函数
+func Register(creator BuilderCreator) {
+	m[strings.ToLower(creator().Name())] = creator
 }
 
 // GetBuilder 获取构建器
+//
+// 每次获取到的都是一个新的构建器，因此同一进程中的多个 braid 实例之间不会共享模块的配置项
 func GetBuilder(name string) IBuilder {
-	if b, ok := m[strings.ToLower(name)]; ok {
-		return b
+	if creator, ok := m[strings.ToLower(name)]; ok {
+		return creator()
 	}
 	return nil
 }
+
+var (
+	options = make(map[string]map[string]interface{})
+)
+
+// RegisterOptions 注册模块可以通过配置文件设置的配置项
+//
+// opts 的 key 为配置文件中使用的名字，value 为模块的 Option 函数（例如 WithLookupAddr），
+// 只有一个参数的函数直接使用配置的值，有多个参数的函数使用一个与参数数量相同的列表
+func RegisterOptions(name string, opts map[string]interface{}) {
+	options[strings.ToLower(name)] = opts
+}
+
+// GetOptions 获取模块可以通过配置文件设置的配置项
+func GetOptions(name string) map[string]interface{} {
+	return options[strings.ToLower(name)]
+}
+
+// ParseType 通过名字获取内置的模块类型
+func ParseType(name string) (ModuleType, bool) {
+	for ty, n := range typeNames {
+		if n == strings.ToLower(name) {
+			return ty, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+var (
+	// ErrOptionMismatch 配置项不属于该模块（例如将 grpcserver 的配置项传递给了 grpcclient
+	ErrOptionMismatch = errors.New("option does not belong to the module")
+
+	// ErrInvalidOption 配置项的值（或者配置项之间的组合）不合法
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// OptionError 模块配置项的错误
+type OptionError struct {
+	Module string
+
+	// Option 出错的配置项，检查配置项组合时为空
+	Option string
+
+	Err error
+}
+
+func (e *OptionError) Error() string {
+	if e.Option == "" {
+		return fmt.Sprintf("module %v options: %v", e.Module, e.Err)
+	}
+	return fmt.Sprintf("module %v option %v: %v", e.Module, e.Option, e.Err)
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// MismatchOption 构建器收到了不属于自己的配置项
+func MismatchOption(module string, opt interface{}) error {
+	return &OptionError{Module: module, Option: OptionName(opt), Err: ErrOptionMismatch}
+}
+
+// InvalidOption 配置项的值不合法，用于实现 Parm 的检查
+func InvalidOption(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %v", ErrInvalidOption, fmt.Sprintf(format, args...))
+}
+
+// OptionName 获取配置项的可读名称
+//
+// 配置项通常是 WithXxx 返回的闭包，这里返回创建它的函数名（例如 grpcserver.WithListen），
+// 其他类型的配置项返回它的类型名
+func OptionName(opt interface{}) string {
+	if opt == nil {
+		return "nil"
+	}
+
+	v := reflect.ValueOf(opt)
+	if v.Kind() != reflect.Func {
+		return fmt.Sprintf("%T", opt)
+	}
+
+	fn := runtime.FuncForPC(v.Pointer())
+	if fn == nil {
+		return fmt.Sprintf("%T", opt)
+	}
+
+	name := fn.Name()
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if idx := strings.Index(name, ".func"); idx >= 0 {
+		name = name[:idx]
+	}
+
+	return name
+}
+
+// IValidator 可选接口，构建器在 Register 阶段（Build 之前）检查配置项的组合是否合法
+type IValidator interface {
+	Validate() error
+}
@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"fmt"
+	"time"
+)
+
+// OverflowPolicy channel 中积压的消息达到容量上限时的处理策略
+type OverflowPolicy int32
+
+const (
+	// OverflowBlock 阻塞发布者，直到 channel 中有空余的位置
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest 丢弃新到达的消息
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃 channel 中最早的消息
+	OverflowDropOldest
+	// OverflowSpill 将超出容量的消息写入磁盘，消费时按顺序读回
+	OverflowSpill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowSpill:
+		return "spill"
+	}
+
+	return fmt.Sprintf("OverflowPolicy(%d)", int32(p))
+}
+
+// BacklogHandler channel 中积压的消息数达到警告阈值时的回调
+type BacklogHandler func(topic string, channel string, backlog int)
+
+// ChannelParm channel 的配置，只在 channel 第一次被 Sub 时生效
+type ChannelParm struct {
+	// Capacity channel 在内存中最多积压的消息数，0 表示不限制（默认
+	Capacity int
+
+	// Overflow 积压达到容量上限时的处理策略（默认 OverflowBlock
+	Overflow OverflowPolicy
+
+	// SpillDir OverflowSpill 写入磁盘的目录（默认系统的临时目录
+	SpillDir string
+
+	// BacklogWarning 积压的消息数达到这个值时调用 OnBacklog（回落到阈值以下后重新计算），0 表示不检查
+	BacklogWarning int
+	OnBacklog      BacklogHandler
+
+	// Dedup 消息去重的存储（默认不去重），DedupTTL 去重记录的有效期，DedupLease 处理中的消息的租约时间
+	Dedup      DedupStore
+	DedupTTL   time.Duration
+	DedupLease time.Duration
+}
+
+// ChannelOption channel config wraps
+type ChannelOption func(*ChannelParm)
+
+// WithCapacity channel 的容量以及达到容量上限时的处理策略
+func WithCapacity(capacity int, policy OverflowPolicy) ChannelOption {
+	return func(c *ChannelParm) {
+		c.Capacity = capacity
+		c.Overflow = policy
+	}
+}
+
+// WithSpillDir OverflowSpill 写入磁盘的目录
+func WithSpillDir(dir string) ChannelOption {
+	return func(c *ChannelParm) {
+		c.SpillDir = dir
+	}
+}
+
+// WithBacklogWarning 积压的消息数达到 threshold 时调用 fn（fn 为 nil 时输出警告日志
+func WithBacklogWarning(threshold int, fn BacklogHandler) ChannelOption {
+	return func(c *ChannelParm) {
+		c.BacklogWarning = threshold
+		c.OnBacklog = fn
+	}
+}
+
+// WithDedup 通过消息 ID 对 channel 中的消息去重，已经处理过的消息直接确认，正在处理的消息重新投递（ttl <= 0 时使用 DefaultDedupTTL
+//
+// 去重记录需要覆盖消息可能被重新投递的时间（重试以及中间件的超时重投
+func WithDedup(store DedupStore, ttl time.Duration) ChannelOption {
+	return func(c *ChannelParm) {
+		if ttl <= 0 {
+			ttl = DefaultDedupTTL
+		}
+		c.Dedup = store
+		c.DedupTTL = ttl
+	}
+}
+
+// WithDedupLease 处理中的消息的租约时间（默认 DefaultDedupLease
+//
+// 租约需要覆盖句柄的处理时间，租约过期后相同 ID 的消息会被再次处理；进程崩溃时重新投递的消息在租约过期后才能被处理
+func WithDedupLease(lease time.Duration) ChannelOption {
+	return func(c *ChannelParm) {
+		if lease > 0 {
+			c.DedupLease = lease
+		}
+	}
+}
+
+// NewChannelParm 默认配置加上 Sub 时传入的配置
+func NewChannelParm(opts ...ChannelOption) ChannelParm {
+	p := ChannelParm{
+		DedupLease: DefaultDedupLease,
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return p
+}
@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+const (
+	// HeaderContentType 消息体的编码格式（参考 Codec
+	HeaderContentType = "content-type"
+
+	// ContentTypeJSON json 编码
+	ContentTypeJSON = "application/json"
+	// ContentTypeProtobuf protobuf 编码（值需要实现 proto.Message
+	ContentTypeProtobuf = "application/x-protobuf"
+	// ContentTypeMsgpack msgpack 编码
+	ContentTypeMsgpack = "application/x-msgpack"
+)
+
+// ErrUnknownContentType 没有注册的编码格式
+var ErrUnknownContentType = errors.New("unknown pubsub content type")
+
+// Codec 消息体的编解码器
+type Codec interface {
+	// ContentType 编码格式，写入消息头 HeaderContentType
+	ContentType() string
+
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// DecodeError 消息体解码失败，重试不会改变结果，因此 AckHandler 返回这个错误时消息不会被重新投递，
+// 而是直接发布到死信 topic（没有设置时丢弃
+type DecodeError struct {
+	ContentType string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %v message err %v", e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	codecLock sync.RWMutex
+	codecs    = make(map[string]Codec)
+)
+
+// RegisterCodec 注册编解码器（同一编码格式后注册的覆盖先注册的
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	codecs[c.ContentType()] = c
+}
+
+// GetCodec 获取编码格式对应的编解码器
+func GetCodec(contentType string) (Codec, bool) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+
+	c, ok := codecs[contentType]
+	return c, ok
+}
+
+// ContentType 消息体的编码格式，没有设置时返回空字符串
+func (m *Message) ContentType() string {
+	return m.Header(HeaderContentType)
+}
+
+// Marshal 使用编码格式 contentType 将 v 编码为一条消息
+func Marshal(contentType string, v interface{}) (*Message, error) {
+	c, ok := GetCodec(contentType)
+	if !ok {
+		return nil, fmt.Errorf("%w %v", ErrUnknownContentType, contentType)
+	}
+
+	body, err := c.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encode %v message err %w", contentType, err)
+	}
+
+	msg := NewMessage(body)
+	msg.SetHeader(HeaderContentType, contentType)
+	return msg, nil
+}
+
+// Unmarshal 按照消息头中的编码格式将消息体解码到 v，没有编码格式的消息视为 json（兼容旧的消息
+//
+// 失败时返回 *DecodeError
+func Unmarshal(msg *Message, v interface{}) error {
+	contentType := msg.ContentType()
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+
+	c, ok := GetCodec(contentType)
+	if !ok {
+		return &DecodeError{ContentType: contentType, Err: ErrUnknownContentType}
+	}
+
+	if err := c.Unmarshal(msg.Body, v); err != nil {
+		return &DecodeError{ContentType: contentType, Err: err}
+	}
+
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	pm, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Marshal(pm)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	pm, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, pm)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return ContentTypeMsgpack }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(protobufCodec{})
+	RegisterCodec(msgpackCodec{})
+}
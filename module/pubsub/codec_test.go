@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+)
+
+type codecMsg struct {
+	Name  string
+	Count int
+}
+
+func TestCodec(t *testing.T) {
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeMsgpack} {
+		msg, err := Marshal(contentType, &codecMsg{Name: "braid", Count: 2})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, msg.ContentType(), contentType)
+
+		dmsg, err := Decode(Encode(msg))
+		assert.Equal(t, err, nil)
+
+		v := codecMsg{}
+		assert.Equal(t, Unmarshal(dmsg, &v), nil)
+		assert.Equal(t, v, codecMsg{Name: "braid", Count: 2})
+	}
+
+	msg, err := Marshal(ContentTypeProtobuf, &wrappers.StringValue{Value: "braid"})
+	assert.Equal(t, err, nil)
+	pv := wrappers.StringValue{}
+	assert.Equal(t, Unmarshal(msg, &pv), nil)
+	assert.Equal(t, pv.Value, "braid")
+
+	_, err = Marshal(ContentTypeProtobuf, &codecMsg{})
+	assert.Equal(t, err != nil, true)
+
+	_, err = Marshal("application/unknown", &codecMsg{})
+	assert.Equal(t, errors.Is(err, ErrUnknownContentType), true)
+
+	// 没有编码格式的旧消息视为 json
+	v := codecMsg{}
+	assert.Equal(t, Unmarshal(NewMessage([]byte(`{"Name":"old"}`)), &v), nil)
+	assert.Equal(t, v.Name, "old")
+
+	// 解码失败时返回 DecodeError，并且不会被重试
+	msg = NewMessage([]byte("{")).SetHeader(HeaderContentType, ContentTypeJSON)
+	err = Unmarshal(msg, &v)
+	var derr *DecodeError
+	assert.Equal(t, errors.As(err, &derr), true)
+	assert.Equal(t, derr.ContentType, ContentTypeJSON)
+
+	msg.Attempts = 1
+	_, ok := DefaultRetry.Next(msg, err)
+	assert.Equal(t, ok, false)
+}
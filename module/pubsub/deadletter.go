@@ -0,0 +1,162 @@
+package pubsub
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+const (
+	// DeadLetterChannel 死信 topic 中用于保存消息的 channel
+	//
+	// 设置死信 topic 时会创建这个 channel，消息在其中积压，直到通过 Replay 回放（或者由其他的工具检查
+	DeadLetterChannel = "braid-dead-letter"
+
+	// HeaderDeadTopic 死信消息的原始 topic
+	HeaderDeadTopic = "x-dead-topic"
+	// HeaderDeadChannel 死信消息的原始 channel
+	HeaderDeadChannel = "x-dead-channel"
+	// HeaderDeadAttempts 死信消息已经投递的次数
+	HeaderDeadAttempts = "x-dead-attempts"
+	// HeaderDeadError 死信消息最后一次处理失败的错误
+	HeaderDeadError = "x-dead-error"
+)
+
+// NewDeadLetter 生成发往死信 topic 的消息，消息头中记录原始的 topic channel 投递次数以及错误
+//
+// 消息的 ID 和其他元数据保持不变
+func NewDeadLetter(msg *Message, topicName, channelName string, err error) *Message {
+	dm := *msg
+	dm.Attempts = 0
+
+	dm.Headers = make(map[string]string, len(msg.Headers)+4)
+	for k, v := range msg.Headers {
+		dm.Headers[k] = v
+	}
+
+	dm.Headers[HeaderDeadTopic] = topicName
+	dm.Headers[HeaderDeadChannel] = channelName
+	dm.Headers[HeaderDeadAttempts] = strconv.Itoa(msg.Attempts)
+	if err != nil {
+		dm.Headers[HeaderDeadError] = err.Error()
+	}
+
+	return &dm
+}
+
+// Revive 还原死信消息，返回原始的 topic（不是死信消息时返回空字符串
+func Revive(msg *Message) (string, *Message) {
+	topicName := msg.Header(HeaderDeadTopic)
+	if topicName == "" {
+		return "", msg
+	}
+
+	rm := *msg
+	rm.Attempts = 0
+
+	rm.Headers = make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		switch k {
+		case HeaderDeadTopic, HeaderDeadChannel, HeaderDeadAttempts, HeaderDeadError:
+		default:
+			rm.Headers[k] = v
+		}
+	}
+
+	return topicName, &rm
+}
+
+// PrepareDeadLetter 注册死信 topic 并创建 DeadLetterChannel，保证之后发布的死信消息不会丢失，由 pubsub 的实现调用
+func PrepareDeadLetter(ps IPubsub, topicName string, scope ScopeTy) (ITopic, error) {
+	t, err := ps.RegistTopic(topicName, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Sub(DeadLetterChannel)
+	return t, nil
+}
+
+// deadBacklog 死信 topic 中 DeadLetterChannel 当前积压的消息数（包括消息中间件中积压以及延迟的消息
+func deadBacklog(t ITopic) int {
+	for _, cs := range t.Stats().Channels {
+		if cs.Name != DeadLetterChannel {
+			continue
+		}
+
+		backlog := cs.Backlog
+		for _, rc := range cs.Remote {
+			backlog += int(rc.Depth + rc.Deferred)
+		}
+		return backlog
+	}
+
+	return 0
+}
+
+// Replay 将死信 topic 中调用时已经积压的消息重新发布到原始的 topic，回放完这些消息（或者 ctx 结束）后停止，
+// 返回回放的消息数；之后到达的死信消息继续保留在死信 topic 中
+//
+// scope 为死信 topic 的作用域，原始的 topic 通过 IPubsub.Publish 以同样的作用域发布（不会重新注册）；
+// 发布失败的消息放回死信 topic，返回第一个发布错误。通常在修复了消费者的问题之后调用
+func Replay(ctx context.Context, ps IPubsub, deadTopic string, scope ScopeTy) (int, error) {
+	t, err := ps.RegistTopic(deadTopic, scope)
+	if err != nil {
+		return 0, err
+	}
+
+	c := t.Sub(DeadLetterChannel)
+	backlog := deadBacklog(t)
+	if backlog == 0 {
+		return 0, nil
+	}
+
+	var lock sync.Mutex
+	var handled, replayed int
+	var perr error
+	done := make(chan struct{})
+
+	sub := c.ArrivedAck(func(msg *Message) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		// 超过调用时积压数量的消息（在停止订阅之前到达）放回死信 topic
+		if handled >= backlog {
+			return t.PubContext(context.Background(), msg)
+		}
+
+		topicName, rm := Revive(msg)
+		if topicName != "" {
+			if err := ps.Publish(ctx, topicName, scope, rm); err != nil {
+				if perr == nil {
+					perr = err
+				}
+				if err = t.PubContext(context.Background(), msg); err != nil {
+					return err
+				}
+			} else {
+				replayed++
+			}
+		}
+
+		handled++
+		if handled == backlog {
+			close(done)
+		}
+		return nil
+	})
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	sub.Unsubscribe()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if perr == nil && handled < backlog {
+		perr = ctx.Err()
+	}
+	return replayed, perr
+}
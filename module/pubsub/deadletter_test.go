@@ -0,0 +1,33 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetter(t *testing.T) {
+	msg := NewMessage([]byte("body")).SetHeader("trace", "abc")
+	Stamp(msg, "service", "node")
+	msg.Attempts = 5
+
+	dm := NewDeadLetter(msg, "topic", "channel", errors.New("boom"))
+	assert.Equal(t, dm.ID, msg.ID)
+	assert.Equal(t, dm.Header(HeaderDeadTopic), "topic")
+	assert.Equal(t, dm.Header(HeaderDeadChannel), "channel")
+	assert.Equal(t, dm.Header(HeaderDeadAttempts), "5")
+	assert.Equal(t, dm.Header(HeaderDeadError), "boom")
+	assert.Equal(t, dm.Header("trace"), "abc")
+
+	// 原始消息的消息头不受影响
+	assert.Equal(t, msg.Header(HeaderDeadTopic), "")
+
+	topicName, rm := Revive(dm)
+	assert.Equal(t, topicName, "topic")
+	assert.Equal(t, rm.ID, msg.ID)
+	assert.Equal(t, rm.Headers, map[string]string{"trace": "abc"})
+
+	topicName, _ = Revive(msg)
+	assert.Equal(t, topicName, "")
+}
@@ -0,0 +1,200 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupTTL 去重记录默认的有效期
+	DefaultDedupTTL = time.Hour
+
+	// DefaultDedupLease 处理中的消息默认的租约时间（需要覆盖句柄的处理时间
+	DefaultDedupLease = time.Second * 30
+)
+
+// ErrDuplicateInFlight 相同 ID 的消息正在被处理，当前的消息作为处理失败重新投递
+var ErrDuplicateInFlight = errors.New("duplicate message is in flight")
+
+// ErrDedupLeaseLost Commit/Release 时租约已经不属于调用方（租约过期后被其他消费者重新占用
+var ErrDedupLeaseLost = errors.New("dedup lease lost")
+
+// DedupState 去重键的状态
+type DedupState int32
+
+const (
+	// DedupClaimed 调用方获得了处理中的租约
+	DedupClaimed DedupState = iota
+	// DedupInFlight 消息正在被处理（租约还没有过期
+	DedupInFlight
+	// DedupDone 消息已经处理过
+	DedupDone
+)
+
+// DedupStore 记录 channel 中已经处理过的消息（参考 MemDedup 以及 3rd/redis 中的 Dedup
+//
+// 去重分为两个阶段：处理之前通过 Claim 获得一个较短的租约，句柄成功之后通过 Commit 将记录延长到完整的有效期；
+// 进程在处理过程中崩溃时，租约过期后重新投递的消息可以被再次处理
+//
+// 每次 Claim 成功都会返回一个新的 token，Commit/Release 只在 key 仍然属于这个 token 时生效，
+// 否则返回 ErrDedupLeaseLost（租约过期后被其他消费者占用，不能覆盖或者删除对方的记录
+type DedupStore interface {
+	// Claim 在处理消息之前占用 key（lease 之后过期），返回租约的 token；key 已经存在时返回它的状态
+	Claim(key string, lease time.Duration) (DedupState, string, error)
+
+	// Commit 句柄处理成功后将 key 标记为已处理（ttl 之后过期
+	Commit(key string, token string, ttl time.Duration) error
+
+	// Release 消息处理失败后释放 key，使重新投递的消息可以被处理
+	Release(key string, token string) error
+}
+
+// NewDedupToken 生成一个随机的租约 token
+func NewDedupToken() string {
+	return NewMessageID()
+}
+
+// DedupKey 消息在 channel 中的去重键（不同 channel 之间互不影响
+func DedupKey(topic, channel string, msg *Message) string {
+	return topic + "/" + channel + "/" + msg.ID
+}
+
+// Deduplicate 包装 channel 的句柄，没有设置去重存储时原样返回
+//
+// 已经处理过的消息直接确认并记录到 rec；正在被处理的消息返回 ErrDuplicateInFlight（按照重试策略重新投递）；
+// 没有 ID 的消息不去重，去重存储出错时仍然处理消息（退化为至少一次），错误交给 onErr
+func (p ChannelParm) Deduplicate(topic, channel string, handler AckHandler, rec *ChannelRecorder, onErr func(error)) AckHandler {
+	if p.Dedup == nil {
+		return handler
+	}
+
+	store, ttl, lease := p.Dedup, p.DedupTTL, p.DedupLease
+	report := func(err error) {
+		if err != nil && onErr != nil {
+			onErr(err)
+		}
+	}
+
+	return func(msg *Message) error {
+		if msg.ID == "" {
+			return handler(msg)
+		}
+
+		key := DedupKey(topic, channel, msg)
+		state, token, err := store.Claim(key, lease)
+		if err != nil {
+			report(err)
+			return handler(msg)
+		}
+
+		switch state {
+		case DedupDone:
+			rec.Skipped(1)
+			return nil
+		case DedupInFlight:
+			return ErrDuplicateInFlight
+		}
+
+		if err = handler(msg); err != nil {
+			report(store.Release(key, token))
+			return err
+		}
+
+		report(store.Commit(key, token, ttl))
+		return nil
+	}
+}
+
+// dedupSweep MemDedup 清理过期记录的间隔
+const dedupSweep = time.Minute
+
+type dedupEntry struct {
+	token  string
+	done   bool
+	expire time.Time
+}
+
+// MemDedup 进程内的去重存储，只能对同一个进程中的消息去重（多个节点之间去重参考 3rd/redis 中的 Dedup
+type MemDedup struct {
+	sync.Mutex
+
+	keys  map[string]dedupEntry
+	sweep time.Time
+}
+
+// NewMemDedup 构建 MemDedup
+func NewMemDedup() *MemDedup {
+	return &MemDedup{
+		keys:  make(map[string]dedupEntry),
+		sweep: time.Now(),
+	}
+}
+
+// Claim 占用 key（过期的记录在 Claim 时顺带清理
+func (d *MemDedup) Claim(key string, lease time.Duration) (DedupState, string, error) {
+	now := time.Now()
+
+	d.Lock()
+	defer d.Unlock()
+
+	if now.Sub(d.sweep) >= dedupSweep {
+		for k, e := range d.keys {
+			if !now.Before(e.expire) {
+				delete(d.keys, k)
+			}
+		}
+		d.sweep = now
+	}
+
+	if e, ok := d.keys[key]; ok && now.Before(e.expire) {
+		if e.done {
+			return DedupDone, "", nil
+		}
+		return DedupInFlight, "", nil
+	}
+
+	token := NewDedupToken()
+	d.keys[key] = dedupEntry{token: token, expire: now.Add(lease)}
+	return DedupClaimed, token, nil
+}
+
+// owned key 是否仍然是 token 持有的租约（已经过期的租约也可以 Commit/Release，只要还没有被其他消费者占用
+func (d *MemDedup) owned(key string, token string) bool {
+	e, ok := d.keys[key]
+	return ok && !e.done && e.token == token
+}
+
+// Commit 将 key 标记为已处理
+func (d *MemDedup) Commit(key string, token string, ttl time.Duration) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.owned(key, token) {
+		return ErrDedupLeaseLost
+	}
+
+	d.keys[key] = dedupEntry{done: true, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release 释放 key
+func (d *MemDedup) Release(key string, token string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.owned(key, token) {
+		return ErrDedupLeaseLost
+	}
+
+	delete(d.keys, key)
+	return nil
+}
+
+// Len 还没有过期的记录数（包括还没有被清理的过期记录
+func (d *MemDedup) Len() int {
+	d.Lock()
+	defer d.Unlock()
+
+	return len(d.keys)
+}
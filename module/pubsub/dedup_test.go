@@ -0,0 +1,128 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type brokenStore struct{}
+
+func (brokenStore) Claim(key string, lease time.Duration) (DedupState, string, error) {
+	return DedupClaimed, "", errors.New("broken")
+}
+
+func (brokenStore) Commit(key string, token string, ttl time.Duration) error {
+	return nil
+}
+
+func (brokenStore) Release(key string, token string) error {
+	return nil
+}
+
+func TestMemDedup(t *testing.T) {
+	d := NewMemDedup()
+
+	state, expired, _ := d.Claim("a", time.Millisecond*10)
+	assert.Equal(t, state, DedupClaimed)
+	state, _, _ = d.Claim("a", time.Millisecond*10)
+	assert.Equal(t, state, DedupInFlight)
+
+	time.Sleep(time.Millisecond * 20)
+	state, token, _ := d.Claim("a", time.Minute)
+	assert.Equal(t, state, DedupClaimed)
+	assert.Equal(t, token != expired, true)
+
+	// 过期的租约被其他消费者重新占用后，不能提交或者释放对方的记录
+	assert.Equal(t, d.Commit("a", expired, time.Minute), ErrDedupLeaseLost)
+	assert.Equal(t, d.Release("a", expired), ErrDedupLeaseLost)
+	state, _, _ = d.Claim("a", time.Minute)
+	assert.Equal(t, state, DedupInFlight)
+
+	assert.Equal(t, d.Commit("a", token, time.Minute), nil)
+	state, _, _ = d.Claim("a", time.Minute)
+	assert.Equal(t, state, DedupDone)
+	assert.Equal(t, d.Release("a", token), ErrDedupLeaseLost)
+
+	state, token, _ = d.Claim("b", time.Minute)
+	assert.Equal(t, state, DedupClaimed)
+	assert.Equal(t, d.Release("b", token), nil)
+	state, _, _ = d.Claim("b", time.Minute)
+	assert.Equal(t, state, DedupClaimed)
+	assert.Equal(t, d.Len(), 2)
+}
+
+func TestDeduplicate(t *testing.T) {
+	handled := 0
+	fail := true
+	handler := func(msg *Message) error {
+		handled++
+		if fail {
+			fail = false
+			return errors.New("fail")
+		}
+		return nil
+	}
+
+	cp := NewChannelParm()
+	assert.Equal(t, cp.Deduplicate("t", "c", handler, &ChannelRecorder{}, nil) != nil, true)
+
+	cp = NewChannelParm(WithDedup(NewMemDedup(), 0))
+	assert.Equal(t, cp.DedupTTL, DefaultDedupTTL)
+	assert.Equal(t, cp.DedupLease, DefaultDedupLease)
+
+	rec := &ChannelRecorder{}
+	h := cp.Deduplicate("t", "c", handler, rec, nil)
+
+	msg := &Message{ID: "1"}
+	assert.Equal(t, h(msg) != nil, true) // 失败后释放
+	assert.Equal(t, h(msg), nil)
+	assert.Equal(t, h(msg), nil) // 跳过
+	assert.Equal(t, handled, 2)
+
+	// 没有 ID 的消息不去重
+	assert.Equal(t, h(&Message{}), nil)
+	assert.Equal(t, h(&Message{}), nil)
+	assert.Equal(t, handled, 4)
+
+	var s ChannelStats
+	rec.Fill(&s)
+	assert.Equal(t, s.Skipped, uint64(1))
+
+	// 去重存储出错时仍然处理消息
+	var reported error
+	cp = NewChannelParm(WithDedup(brokenStore{}, time.Minute))
+	h = cp.Deduplicate("t", "c", handler, rec, func(err error) { reported = err })
+	assert.Equal(t, h(msg), nil)
+	assert.Equal(t, handled, 5)
+	assert.Equal(t, reported != nil, true)
+}
+
+func TestDeduplicateCrash(t *testing.T) {
+	store := NewMemDedup()
+	cp := NewChannelParm(WithDedup(store, time.Minute), WithDedupLease(time.Millisecond*20))
+
+	handled := 0
+	h := cp.Deduplicate("t", "c", func(msg *Message) error {
+		handled++
+		return nil
+	}, &ChannelRecorder{}, nil)
+
+	// 进程在 Claim 与 Commit 之间崩溃，只留下处理中的租约
+	msg := &Message{ID: "crash"}
+	state, _, _ := store.Claim(DedupKey("t", "c", msg), cp.DedupLease)
+	assert.Equal(t, state, DedupClaimed)
+
+	// 租约期间重新投递的消息不会被确认
+	assert.Equal(t, h(msg), ErrDuplicateInFlight)
+	assert.Equal(t, handled, 0)
+
+	// 租约过期后重新投递的消息被处理，之后的重复消息被跳过
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, h(msg), nil)
+	assert.Equal(t, handled, 1)
+	assert.Equal(t, h(msg), nil)
+	assert.Equal(t, handled, 1)
+}
@@ -0,0 +1,148 @@
+package pubsub
+
+import (
+	"context"
+	"path"
+)
+
+// PubInvoker 发布一条消息（拦截器链的下一环
+type PubInvoker func(ctx context.Context, topic string, msg *Message) error
+
+// PubInterceptor 发布拦截器，包装 ITopic 的发布（Pub PubContext PubAsync PubDelay PubBatch
+//
+// 拦截器在消息填充元数据之后调用，可以修改消息，或者在调用 invoker 前后执行逻辑（例如日志、追踪、校验、加密）；
+// 不调用 invoker 时消息不会被发布，返回的 error 会交给发布者
+type PubInterceptor func(ctx context.Context, topic string, msg *Message, invoker PubInvoker) error
+
+// ConsumeInterceptor 消费拦截器，包装 channel 句柄的调用
+//
+// 返回值的语义与 AckHandler 相同，不调用 handler 时消息不会交给句柄
+type ConsumeInterceptor func(topic, channel string, msg *Message, handler AckHandler) error
+
+// ChainPub 将多个发布拦截器串联为一个，第一个拦截器在最外层
+func ChainPub(interceptors ...PubInterceptor) PubInterceptor {
+	return func(ctx context.Context, topic string, msg *Message, invoker PubInvoker) error {
+		chained := invoker
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, topic string, msg *Message) error {
+				return interceptor(ctx, topic, msg, next)
+			}
+		}
+
+		return chained(ctx, topic, msg)
+	}
+}
+
+// ChainConsume 将多个消费拦截器串联为一个，第一个拦截器在最外层
+func ChainConsume(interceptors ...ConsumeInterceptor) ConsumeInterceptor {
+	return func(topic, channel string, msg *Message, handler AckHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(msg *Message) error {
+				return interceptor(topic, channel, msg, next)
+			}
+		}
+
+		return chained(msg)
+	}
+}
+
+type pubEntry struct {
+	patterns    []string
+	interceptor PubInterceptor
+}
+
+type consumeEntry struct {
+	patterns    []string
+	interceptor ConsumeInterceptor
+}
+
+// Interceptors 按照 topic 匹配的拦截器集合，供 pubsub 的实现使用
+//
+// 模式的语法参考 path.Match（例如 "order.*"），没有模式的拦截器作用于所有的 topic；
+// 拦截器按照添加的顺序串联
+type Interceptors struct {
+	pubs     []pubEntry
+	consumes []consumeEntry
+}
+
+// AppendPub 添加发布拦截器
+func (is *Interceptors) AppendPub(interceptor PubInterceptor, topicPatterns ...string) {
+	is.pubs = append(is.pubs, pubEntry{patterns: topicPatterns, interceptor: interceptor})
+}
+
+// AppendConsume 添加消费拦截器
+func (is *Interceptors) AppendConsume(interceptor ConsumeInterceptor, topicPatterns ...string) {
+	is.consumes = append(is.consumes, consumeEntry{patterns: topicPatterns, interceptor: interceptor})
+}
+
+// Validate 检查拦截器的模式
+func (is *Interceptors) Validate() error {
+	check := func(patterns []string) error {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, e := range is.pubs {
+		if err := check(e.patterns); err != nil {
+			return err
+		}
+	}
+	for _, e := range is.consumes {
+		if err := check(e.patterns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func matchTopic(patterns []string, topic string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, topic); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pub topic 的发布拦截器链，没有匹配的拦截器时返回 nil
+func (is *Interceptors) Pub(topic string) PubInterceptor {
+	var matched []PubInterceptor
+	for _, e := range is.pubs {
+		if matchTopic(e.patterns, topic) {
+			matched = append(matched, e.interceptor)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+	return ChainPub(matched...)
+}
+
+// Consume topic 的消费拦截器链，没有匹配的拦截器时返回 nil
+func (is *Interceptors) Consume(topic string) ConsumeInterceptor {
+	var matched []ConsumeInterceptor
+	for _, e := range is.consumes {
+		if matchTopic(e.patterns, topic) {
+			matched = append(matched, e.interceptor)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+	return ChainConsume(matched...)
+}
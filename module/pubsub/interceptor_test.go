@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptors(t *testing.T) {
+	var order []string
+	trace := func(name string) PubInterceptor {
+		return func(ctx context.Context, topic string, msg *Message, invoker PubInvoker) error {
+			order = append(order, name)
+			return invoker(ctx, topic, msg)
+		}
+	}
+
+	is := Interceptors{}
+	is.AppendPub(trace("all"))
+	is.AppendPub(trace("order"), "order.*")
+	is.AppendPub(trace("user"), "user.*", "account")
+	is.AppendConsume(func(topic, channel string, msg *Message, handler AckHandler) error {
+		if msg.Header("skip") != "" {
+			return errors.New("skip")
+		}
+		return handler(msg)
+	}, "order.*")
+	assert.Equal(t, is.Validate(), nil)
+
+	invoked := 0
+	invoker := func(ctx context.Context, topic string, msg *Message) error {
+		invoked++
+		return nil
+	}
+
+	assert.Equal(t, is.Pub("order.created")(context.Background(), "order.created", NewMessage(nil), invoker), nil)
+	assert.Equal(t, order, []string{"all", "order"})
+	assert.Equal(t, invoked, 1)
+
+	order = nil
+	assert.Equal(t, is.Pub("account")(context.Background(), "account", NewMessage(nil), invoker), nil)
+	assert.Equal(t, order, []string{"all", "user"})
+
+	assert.Equal(t, is.Consume("user.login") == nil, true)
+
+	handled := 0
+	handler := func(msg *Message) error {
+		handled++
+		return nil
+	}
+	consume := is.Consume("order.paid")
+	assert.Equal(t, consume("order.paid", "ch", NewMessage(nil), handler), nil)
+
+	msg := NewMessage(nil)
+	msg.SetHeader("skip", "1")
+	assert.Equal(t, consume("order.paid", "ch", msg, handler) != nil, true)
+	assert.Equal(t, handled, 1)
+
+	bad := Interceptors{}
+	bad.AppendPub(trace("bad"), "[")
+	assert.Equal(t, bad.Validate() != nil, true)
+}
@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// envelopeMagic 带有元数据的消息在传输时的前缀，没有这个前缀的消息视为只有 Body 的旧格式消息
+var envelopeMagic = []byte{0, 'B', 'R', 'D'}
+
+// ErrMalformedMessage 消息的格式不正确
+var ErrMalformedMessage = errors.New("malformed pubsub message")
+
+// HeaderPartitionKey 消息的分区键
+const HeaderPartitionKey = "x-partition-key"
+
+// ErrPartitionUnsupported 无法保证分区键顺序的实现在发布带有分区键的消息时返回
+var ErrPartitionUnsupported = errors.New("pubsub partition key is not supported")
+
+// NewMessage 创建一条消息
+func NewMessage(body []byte) *Message {
+	return &Message{Body: body}
+}
+
+// SetHeader 设置消息头
+func (m *Message) SetHeader(key, value string) *Message {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+	return m
+}
+
+// Header 获取消息头，不存在时返回空字符串
+func (m *Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+// SetPartitionKey 设置消息的分区键
+//
+// 同一个 channel 中分区键相同的消息按照到达的顺序串行处理（失败的消息在原地重试，不会被后续的消息越过），
+// 不同分区键以及没有分区键的消息并行处理；无法保证顺序的实现（例如 redis streams 的集群作用域）发布时返回 ErrPartitionUnsupported
+//
+// pubsubnsq 的集群作用域将分区键相同的消息固定发布到同一个 nsqd，顺序只在同一个节点的 channel 中成立，
+// 多个节点消费同一个 channel 时它们之间会竞争消息，不保证顺序
+func (m *Message) SetPartitionKey(key string) *Message {
+	return m.SetHeader(HeaderPartitionKey, key)
+}
+
+// PartitionKey 消息的分区键，没有设置时返回空字符串
+func (m *Message) PartitionKey() string {
+	return m.Headers[HeaderPartitionKey]
+}
+
+// Time 消息的发布时间
+func (m *Message) Time() time.Time {
+	return time.Unix(0, m.Timestamp)
+}
+
+// NewMessageID 生成一个随机的消息 ID
+func NewMessageID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Stamp 在发布时填充消息的元数据（已经设置的字段保持不变），由 pubsub 的实现调用
+func Stamp(m *Message, source, node string) {
+	if m.ID == "" {
+		m.ID = NewMessageID()
+	}
+	if m.Timestamp == 0 {
+		m.Timestamp = time.Now().UnixNano()
+	}
+	if m.Source == "" {
+		m.Source = source
+	}
+	if m.Node == "" {
+		m.Node = node
+	}
+	if m.Attempts == 0 {
+		m.Attempts = 1
+	}
+}
+
+type envelope struct {
+	ID        string            `json:"id,omitempty"`
+	Timestamp int64             `json:"ts,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// Encode 将消息（包括元数据）编码为用于集群传输的字节
+//
+// 格式为 magic(4) + 元数据长度(4, big endian) + 元数据(json) + Body
+func Encode(m *Message) []byte {
+	meta, _ := json.Marshal(&envelope{
+		ID:        m.ID,
+		Timestamp: m.Timestamp,
+		Source:    m.Source,
+		Node:      m.Node,
+		Headers:   m.Headers,
+	})
+
+	buf := make([]byte, 0, len(envelopeMagic)+4+len(meta)+len(m.Body))
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(envelopeMagic):], uint32(len(meta)))
+	buf = append(buf, meta...)
+	buf = append(buf, m.Body...)
+
+	return buf
+}
+
+// Decode 解码 Encode 生成的字节，不带元数据的旧格式消息会被完整地视为 Body
+func Decode(data []byte) (*Message, error) {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		return &Message{Body: data, Attempts: 1}, nil
+	}
+
+	data = data[len(envelopeMagic):]
+	if len(data) < 4 {
+		return nil, ErrMalformedMessage
+	}
+
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(n) > uint64(len(data)) {
+		return nil, ErrMalformedMessage
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data[:n], &env); err != nil {
+		return nil, ErrMalformedMessage
+	}
+
+	return &Message{
+		ID:        env.ID,
+		Timestamp: env.Timestamp,
+		Source:    env.Source,
+		Node:      env.Node,
+		Headers:   env.Headers,
+		Attempts:  1,
+		Body:      data[n:],
+	}, nil
+}
@@ -0,0 +1,35 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	msg := NewMessage([]byte("body")).SetHeader("trace", "abc")
+	Stamp(msg, "service", "node")
+
+	id := msg.ID
+	assert.Equal(t, len(id), 32)
+	assert.Equal(t, msg.Timestamp != 0, true)
+
+	// 已经设置的元数据不会被覆盖
+	Stamp(msg, "other", "other")
+	assert.Equal(t, msg.ID, id)
+	assert.Equal(t, msg.Source, "service")
+
+	dmsg, err := Decode(Encode(msg))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, dmsg, msg)
+	assert.Equal(t, dmsg.Header("trace"), "abc")
+
+	// 旧格式的消息
+	dmsg, err = Decode([]byte(`{"Event":"add"}`))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(dmsg.Body), `{"Event":"add"}`)
+	assert.Equal(t, dmsg.ID, "")
+
+	_, err = Decode(Encode(msg)[:6])
+	assert.Equal(t, err, ErrMalformedMessage)
+}
@@ -1,14 +1,47 @@
 // 接口文件 mailbox 邮箱，主要用于包装 Pub-sub 消息模型
 package pubsub
 
+import (
+	"context"
+	"time"
+)
+
 // Message 消息体
+//
+// 除了 Body 之外的字段为消息的元数据，在发布时由 pubsub 填充（已经设置的字段不会被覆盖），
+// 并随消息一起投递（ScopeCluster 的消息通过 Encode/Decode 在集群中传递
 type Message struct {
+	// ID 消息的唯一标识
+	ID string
+
+	// Timestamp 发布时间（unix 纳秒
+	Timestamp int64
+
+	// Source 发布者的服务名
+	Source string
+
+	// Node 发布者所在的节点
+	Node string
+
+	// Headers 自定义的消息头
+	Headers map[string]string
+
+	// Attempts 消息的投递次数（从 1 开始，由 pubsub 在投递时设置
+	Attempts int
+
 	Body []byte
 }
 
 // Handler 消息到达的函数句柄
 type Handler func(*Message)
 
+// AckHandler 带有确认语义的消息句柄
+//
+// 返回 nil 表示消息处理成功（ack），返回 error 表示处理失败（nack），
+// 消息会在退避之后重新投递到同一个 channel，直到超过最大的尝试次数。
+// 可以通过返回 Requeue(delay) 显式地指定重新投递的延迟
+type AckHandler func(*Message) error
+
 // ScopeTy 作用域类型
 type ScopeTy int32
 
@@ -28,26 +61,59 @@ const (
 )
 
 // IChannel 信道，topic的子集
+//
+// 同一个 channel 上的多个句柄（包括其他节点上的）竞争消费：每条消息只会交给其中一个句柄处理。
+// 句柄中的 panic 会被恢复并视为处理失败（参考 PanicError），不会导致进程退出
 type IChannel interface {
-	// Arrived 绑定消息到达的函数句柄
-	Arrived(Handler)
+	// Arrived 绑定消息到达的函数句柄，消息在句柄返回后即视为处理成功
+	//
+	// 返回的订阅可以单独停止这个句柄，opts 设置句柄的并发数以及处理失败的回调
+	Arrived(handler Handler, opts ...SubOption) ISubscription
+
+	// ArrivedAck 绑定带有确认语义的函数句柄（参考 AckHandler
+	ArrivedAck(handler AckHandler, opts ...SubOption) ISubscription
+
+	// DeadLetter 设置 channel 的死信 topic（覆盖 topic 上的设置
+	//
+	// 超过最大投递次数或者无法解码的消息会被发布到死信 topic 中（参考 DeadLetterChannel
+	DeadLetter(topicName string) error
 }
 
 // ITopic 话题，某类消息的聚合
 type ITopic interface {
-	// Pub 向 topic 中发送一条消息
+	// Pub 向 topic 中发送一条消息，等价于 PubContext(context.Background(), msg)
 	Pub(*Message) error
 
+	// PubContext 向 topic 中发送一条消息，并等待消息被确认（集群作用域为消息中间件确认写入）或者 ctx 结束
+	PubContext(ctx context.Context, msg *Message) error
+
+	// PubAsync 异步发送一条消息，完成后（成功或者失败）调用 done（可以为 nil
+	PubAsync(ctx context.Context, msg *Message, done func(error))
+
+	// PubDelay 发送一条延迟消息，消息在 delay 之后才会投递给 channel（delay <= 0 时立即投递
+	PubDelay(ctx context.Context, msg *Message, delay time.Duration) error
+
+	// PubBatch 批量发送消息（集群作用域在一次请求中发送
+	PubBatch(ctx context.Context, msgs []*Message) error
+
 	// Sub 获取topic中的channel
 	//
 	// 如果在 topic 中没有该 channel 则创建一个新的 channel 到 topic
 	//
 	// 如果在 topic 中已有同名的 channel 则获取到该 channel
 	// 这个时候如果同时有多个 sub 指向同一个 channel 则代表有多个 consumer 对该 channel 进行消费（随机获得
-	Sub(channelName string) IChannel
+	//
+	// opts 设置 channel 的容量、溢出策略以及积压警告，只在创建 channel 时生效
+	Sub(channelName string, opts ...ChannelOption) IChannel
 
 	// RemoveChannel 删除 topic 中存在的 channel
 	RemoveChannel(channelName string) error
+
+	// DeadLetter 设置 topic 中所有 channel 的死信 topic
+	DeadLetter(topicName string) error
+
+	// Stats topic 以及其中 channel 的统计数据（集群作用域会合并消息中间件中的统计数据
+	Stats() TopicStats
 }
 
 // IPubsub 发布-订阅，管理集群中的所有 Topic
@@ -62,4 +128,18 @@ type IPubsub interface {
 
 	// RemoveTopic 删除 mailbox 中存在的 topic
 	RemoveTopic(topicName string) error
+
+	// Publish 向 topic 发布消息，不会在本地注册 topic（例如应答请求方的临时 topic
+	//
+	// 本地已经注册的 topic 等同于 PubContext；集群作用域的消息直接发送到消息中间件，进程作用域的 topic 不存在时返回错误
+	Publish(ctx context.Context, topicName string, scope ScopeTy, msg *Message) error
+
+	// Stats 所有 topic 的统计数据（按 topic 名排序
+	Stats() []TopicStats
+}
+
+// ITopicDeleter 可以删除消息中间件中的 topic 的 pubsub（pubsubnsq、pubsubredis
+type ITopicDeleter interface {
+	// DeleteTopic 删除本地的 topic（同 RemoveTopic），集群作用域的 topic 同时从消息中间件中删除（包括其中的 channel 以及未消费的消息
+	DeleteTopic(topicName string) error
 }
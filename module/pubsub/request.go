@@ -0,0 +1,212 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+const (
+	// HeaderCorrelationID 请求的标识，应答消息中带有相同的值
+	HeaderCorrelationID = "x-correlation-id"
+	// HeaderReplyTo 接收应答的 topic
+	HeaderReplyTo = "x-reply-to"
+	// HeaderReplyError 应答方处理请求失败时的错误
+	HeaderReplyError = "x-reply-error"
+
+	// ReplyTopicPrefix 请求方接收应答的 topic 前缀
+	ReplyTopicPrefix = "braid-reply-"
+	// replyChannel 请求方接收应答的 channel
+	replyChannel = "reply"
+)
+
+// ErrRequesterClosed 请求方已经关闭
+var ErrRequesterClosed = errors.New("pubsub requester closed")
+
+// ReplyError 应答方返回的错误
+type ReplyError struct {
+	Msg string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("reply err %v", e.Msg)
+}
+
+// RespondHandler 处理请求并返回应答，返回 error 时应答方会收到 *ReplyError
+type RespondHandler func(req *Message) (*Message, error)
+
+// call 调用句柄，句柄中的 panic 作为错误应答给请求方（不会重新投递请求
+func (h RespondHandler) call(req *Message) (res *Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return h(req)
+}
+
+// Requester 基于 pubsub 的请求-应答
+//
+// 每个 Requester 拥有一个独立的应答 topic（与请求相同的作用域），请求中带有关联 ID 以及应答 topic，
+// 应答方处理完成后将应答发布到应答 topic，Requester 根据关联 ID 交给等待中的请求；
+// 集群作用域的应答 topic 在 Close 时从消息中间件中删除（需要 pubsub 实现 ITopicDeleter），
+// 因此 Requester 应该长期复用，而不是为每个请求创建
+type Requester struct {
+	ps    IPubsub
+	scope ScopeTy
+
+	replyTopic string
+	sub        ISubscription
+
+	sync.Mutex
+	closed  bool
+	pending map[string]chan *Message
+}
+
+// NewRequester 创建请求方，scope 为请求 topic 以及应答 topic 的作用域
+func NewRequester(ps IPubsub, scope ScopeTy) (*Requester, error) {
+	r := &Requester{
+		ps:         ps,
+		scope:      scope,
+		replyTopic: ReplyTopicPrefix + NewMessageID(),
+		pending:    make(map[string]chan *Message),
+	}
+
+	t, err := ps.RegistTopic(r.replyTopic, scope)
+	if err != nil {
+		return nil, err
+	}
+	r.sub = t.Sub(replyChannel).Arrived(r.reply)
+
+	return r, nil
+}
+
+// ReplyTopic 接收应答的 topic
+func (r *Requester) ReplyTopic() string {
+	return r.replyTopic
+}
+
+func (r *Requester) reply(msg *Message) {
+	id := msg.Header(HeaderCorrelationID)
+
+	r.Lock()
+	ch, ok := r.pending[id]
+	delete(r.pending, id)
+	r.Unlock()
+
+	// 已经超时的请求的应答直接丢弃
+	if ok {
+		ch <- msg
+	}
+}
+
+// Request 向 topic 发送请求，并等待第一个应答或者 ctx 结束
+//
+// 应答方返回错误时返回 *ReplyError
+func (r *Requester) Request(ctx context.Context, topicName string, req *Message) (*Message, error) {
+	id := NewMessageID()
+	req.SetHeader(HeaderCorrelationID, id)
+	req.SetHeader(HeaderReplyTo, r.replyTopic)
+
+	ch := make(chan *Message, 1)
+
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return nil, ErrRequesterClosed
+	}
+	r.pending[id] = ch
+	r.Unlock()
+
+	defer func() {
+		r.Lock()
+		delete(r.pending, id)
+		r.Unlock()
+	}()
+
+	t, err := r.ps.RegistTopic(topicName, r.scope)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.PubContext(ctx, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		if e, ok := res.Headers[HeaderReplyError]; ok {
+			return res, &ReplyError{Msg: e}
+		}
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close 停止接收应答并删除应答 topic，等待中的请求在 ctx 结束时返回
+//
+// 集群作用域的应答 topic 同时从消息中间件中删除（关闭之后才发送的应答会在消息中间件中重新创建这个 topic
+func (r *Requester) Close() error {
+	r.Lock()
+	r.closed = true
+	r.Unlock()
+
+	r.sub.Unsubscribe()
+	if d, ok := r.ps.(ITopicDeleter); ok && r.scope == ScopeCluster {
+		return d.DeleteTopic(r.replyTopic)
+	}
+	return r.ps.RemoveTopic(r.replyTopic)
+}
+
+// Reply 将 res 作为 req 的应答发布到 req 的应答 topic（res 为 nil 时发送空的应答，err 不为 nil 时应答中带有错误
+//
+// 应答通过 IPubsub.Publish 发送，不会在应答方注册请求方的应答 topic
+func Reply(ps IPubsub, scope ScopeTy, req *Message, res *Message, err error) error {
+	replyTo := req.Header(HeaderReplyTo)
+	if replyTo == "" {
+		return fmt.Errorf("message %v is not a request", req.ID)
+	}
+
+	if res == nil {
+		res = NewMessage(nil)
+	}
+	res.SetHeader(HeaderCorrelationID, req.Header(HeaderCorrelationID))
+	if err != nil {
+		res.SetHeader(HeaderReplyError, err.Error())
+	}
+
+	return ps.Publish(context.Background(), replyTo, scope, res)
+}
+
+// Respond 在 topic 的 channel 上处理请求，handler 的返回值作为应答发布给请求方
+//
+// 同一个 channel 上的应答方（包括其他节点上的）竞争消费，每个请求只会被其中一个处理；
+// 发送应答失败时不会重新处理请求（handler 不一定是幂等的），错误交给 WithErrorHandler 设置的回调（没有设置时忽略
+func Respond(ps IPubsub, topicName string, scope ScopeTy, channelName string, handler RespondHandler, opts ...SubOption) (ISubscription, error) {
+	t, err := ps.RegistTopic(topicName, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := NewSubParm(opts...)
+
+	return t.Sub(channelName).ArrivedAck(func(req *Message) error {
+		if req.Header(HeaderReplyTo) == "" {
+			// 不是请求的消息，忽略
+			return nil
+		}
+
+		res, herr := handler.call(req)
+		if rerr := Reply(ps, scope, req, res, herr); rerr != nil {
+			rerr = fmt.Errorf("topic %v reply to %v err %w", topicName, req.Header(HeaderReplyTo), rerr)
+			if sp.OnError != nil {
+				sp.OnError(req, rerr)
+			}
+		}
+
+		return nil
+	}, opts...), nil
+}
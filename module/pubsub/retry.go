@@ -0,0 +1,92 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RequeueError 显式指定重新投递延迟的 nack
+type RequeueError struct {
+	Delay time.Duration
+}
+
+func (e *RequeueError) Error() string {
+	return fmt.Sprintf("requeue after %v", e.Delay)
+}
+
+// Requeue 在 AckHandler 中返回，表示消息需要在 delay 之后重新投递
+func Requeue(delay time.Duration) error {
+	return &RequeueError{Delay: delay}
+}
+
+// Retry 消息处理失败后的重试策略
+type Retry struct {
+	// MaxAttempts 最大的投递次数，0 表示不限制
+	MaxAttempts int
+
+	// Backoff 第一次重试的延迟，之后每次翻倍
+	Backoff time.Duration
+
+	// MaxBackoff 重试延迟的上限，0 表示不限制（持续翻倍，直到 time.Duration 的最大值
+	MaxBackoff time.Duration
+}
+
+// DefaultRetry 默认的重试策略
+var DefaultRetry = Retry{
+	MaxAttempts: 5,
+	Backoff:     time.Second,
+	MaxBackoff:  time.Minute,
+}
+
+// Delay 第 attempts 次投递失败之后的重试延迟
+func (r Retry) Delay(attempts int) time.Duration {
+	delay := r.Backoff
+	for i := 1; i < attempts && delay > 0; i++ {
+		if r.MaxBackoff > 0 && delay >= r.MaxBackoff {
+			break
+		}
+		if delay > math.MaxInt64/2 {
+			delay = math.MaxInt64
+			break
+		}
+		delay *= 2
+	}
+
+	if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	return delay
+}
+
+// Next 根据处理失败的错误计算重新投递的延迟，超过最大投递次数（或者消息无法解码）时返回 false
+func (r Retry) Next(msg *Message, err error) (time.Duration, bool) {
+	if r.MaxAttempts > 0 && msg.Attempts >= r.MaxAttempts {
+		return 0, false
+	}
+
+	var derr *DecodeError
+	if errors.As(err, &derr) {
+		return 0, false
+	}
+
+	var rerr *RequeueError
+	if errors.As(err, &rerr) {
+		return rerr.Delay, true
+	}
+
+	return r.Delay(msg.Attempts), true
+}
+
+// Validate 检查重试策略
+func (r Retry) Validate() error {
+	if r.MaxAttempts < 0 {
+		return errors.New("max attempts must not be negative")
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 {
+		return errors.New("backoff must not be negative")
+	}
+
+	return nil
+}
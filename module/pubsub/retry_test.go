@@ -0,0 +1,51 @@
+package pubsub
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	r := Retry{MaxAttempts: 4, Backoff: time.Second, MaxBackoff: time.Second * 3}
+
+	assert.Equal(t, r.Delay(1), time.Second)
+	assert.Equal(t, r.Delay(2), time.Second*2)
+	assert.Equal(t, r.Delay(3), time.Second*3)
+	assert.Equal(t, r.Delay(10), time.Second*3)
+
+	delay, ok := r.Next(&Message{Attempts: 2}, errors.New("err"))
+	assert.Equal(t, ok, true)
+	assert.Equal(t, delay, time.Second*2)
+
+	delay, ok = r.Next(&Message{Attempts: 1}, Requeue(time.Millisecond))
+	assert.Equal(t, ok, true)
+	assert.Equal(t, delay, time.Millisecond)
+
+	_, ok = r.Next(&Message{Attempts: 4}, errors.New("err"))
+	assert.Equal(t, ok, false)
+
+	r.MaxAttempts = 0
+	_, ok = r.Next(&Message{Attempts: 100}, errors.New("err"))
+	assert.Equal(t, ok, true)
+
+	assert.Equal(t, Retry{MaxAttempts: -1}.Validate() != nil, true)
+}
+
+func TestRetryUncapped(t *testing.T) {
+	r := Retry{Backoff: time.Second}
+
+	// MaxBackoff 为 0 时不限制上限，延迟持续翻倍
+	assert.Equal(t, r.Delay(1), time.Second)
+	assert.Equal(t, r.Delay(2), time.Second*2)
+	assert.Equal(t, r.Delay(5), time.Second*16)
+
+	// 翻倍溢出时停在 time.Duration 的最大值
+	assert.Equal(t, r.Delay(100), time.Duration(math.MaxInt64))
+	assert.Equal(t, r.Delay(1000), time.Duration(math.MaxInt64))
+
+	assert.Equal(t, Retry{}.Delay(10), time.Duration(0))
+}
@@ -0,0 +1,228 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// RateWindow 统计速率的时间窗口
+const RateWindow = time.Second * 10
+
+// TopicStats topic 的统计数据
+type TopicStats struct {
+	Name  string  `json:"name"`
+	Scope ScopeTy `json:"scope"`
+
+	// Published 发布成功的消息数，PublishRate 最近 RateWindow 内平均每秒发布的消息数
+	Published   uint64  `json:"published"`
+	PublishRate float64 `json:"publish_rate"`
+
+	// Dropped topic 的队列已满时丢弃的消息数
+	Dropped uint64 `json:"dropped"`
+
+	Channels []ChannelStats `json:"channels"`
+
+	// Remote 消息中间件中的统计数据（例如 nsqd 的 /stats），每个节点一条
+	Remote []RemoteTopicStats `json:"remote,omitempty"`
+}
+
+// ChannelStats channel 的统计数据，只包含本进程中的消费者（其他节点参考 Remote
+type ChannelStats struct {
+	Name string `json:"name"`
+
+	// Handlers 消费者的数量
+	Handlers int `json:"handlers"`
+
+	// Backlog 进程内积压的消息数，InFlight 正在被处理的消息数
+	Backlog  int `json:"backlog"`
+	InFlight int `json:"in_flight"`
+
+	// Handled 句柄处理的消息数（包括处理失败的），HandleRate 最近 RateWindow 内平均每秒处理的消息数
+	Handled    uint64  `json:"handled"`
+	HandleRate float64 `json:"handle_rate"`
+
+	// Failed 处理失败的次数，Dropped channel 溢出时丢弃的消息数
+	Failed  uint64 `json:"failed"`
+	Dropped uint64 `json:"dropped"`
+
+	// Skipped 去重时跳过的重复消息数（同时计入 Handled
+	Skipped uint64 `json:"skipped"`
+
+	Latency LatencyStats `json:"latency"`
+
+	Remote []RemoteChannelStats `json:"remote,omitempty"`
+}
+
+// LatencyStats 句柄的处理耗时
+type LatencyStats struct {
+	Mean time.Duration `json:"mean"`
+	Max  time.Duration `json:"max"`
+}
+
+// RemoteTopicStats 消息中间件节点中 topic 的统计数据
+type RemoteTopicStats struct {
+	Addr string `json:"addr"`
+
+	// Depth 节点中积压的消息数，Messages 节点收到的消息数
+	Depth    int64  `json:"depth"`
+	Messages uint64 `json:"messages"`
+
+	// Err 获取统计数据失败时的错误
+	Err string `json:"err,omitempty"`
+}
+
+// RemoteChannelStats 消息中间件节点中 channel 的统计数据
+type RemoteChannelStats struct {
+	Addr string `json:"addr"`
+
+	Depth    int64  `json:"depth"`
+	InFlight int64  `json:"in_flight"`
+	Deferred int64  `json:"deferred"`
+	Messages uint64 `json:"messages"`
+	Requeued uint64 `json:"requeued"`
+	TimedOut uint64 `json:"timed_out"`
+
+	// Clients 连接到这个 channel 的消费者数量（包括其他节点
+	Clients int `json:"clients"`
+}
+
+// Meter 计数器，同时统计最近 RateWindow 内的速率
+type Meter struct {
+	lock sync.Mutex
+
+	count uint64
+
+	// buckets 每秒一个的计数桶，secs 桶对应的时间（unix 秒
+	buckets [10]uint64
+	secs    [10]int64
+}
+
+// Mark 计数 n 次
+func (m *Meter) Mark(n uint64) {
+	now := time.Now().Unix()
+	idx := now % int64(len(m.buckets))
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.count += n
+	if m.secs[idx] != now {
+		m.secs[idx] = now
+		m.buckets[idx] = 0
+	}
+	m.buckets[idx] += n
+}
+
+// Count 总的计数
+func (m *Meter) Count() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.count
+}
+
+// Rate 最近 RateWindow 内平均每秒的计数
+func (m *Meter) Rate() float64 {
+	now := time.Now().Unix()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var sum uint64
+	for i, sec := range m.secs {
+		if now-sec < int64(len(m.secs)) {
+			sum += m.buckets[i]
+		}
+	}
+
+	return float64(sum) / RateWindow.Seconds()
+}
+
+// TopicRecorder 记录 topic 的统计数据，供 pubsub 的实现使用
+type TopicRecorder struct {
+	published Meter
+
+	lock    sync.Mutex
+	dropped uint64
+}
+
+// Published 记录发布成功的消息数
+func (r *TopicRecorder) Published(n int) {
+	r.published.Mark(uint64(n))
+}
+
+// Dropped 记录丢弃的消息数
+func (r *TopicRecorder) Dropped(n int) {
+	r.lock.Lock()
+	r.dropped += uint64(n)
+	r.lock.Unlock()
+}
+
+// Fill 填充 topic 的统计数据
+func (r *TopicRecorder) Fill(s *TopicStats) {
+	s.Published = r.published.Count()
+	s.PublishRate = r.published.Rate()
+
+	r.lock.Lock()
+	s.Dropped = r.dropped
+	r.lock.Unlock()
+}
+
+// ChannelRecorder 记录 channel 的统计数据，供 pubsub 的实现使用
+type ChannelRecorder struct {
+	handled Meter
+
+	lock    sync.Mutex
+	failed  uint64
+	dropped uint64
+	skipped uint64
+	total   time.Duration
+	max     time.Duration
+}
+
+// Handled 记录一次句柄的处理
+func (r *ChannelRecorder) Handled(latency time.Duration, err error) {
+	r.handled.Mark(1)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err != nil {
+		r.failed++
+	}
+	r.total += latency
+	if latency > r.max {
+		r.max = latency
+	}
+}
+
+// Dropped 记录丢弃的消息数
+func (r *ChannelRecorder) Dropped(n int) {
+	r.lock.Lock()
+	r.dropped += uint64(n)
+	r.lock.Unlock()
+}
+
+// Skipped 记录去重时跳过的消息数
+func (r *ChannelRecorder) Skipped(n int) {
+	r.lock.Lock()
+	r.skipped += uint64(n)
+	r.lock.Unlock()
+}
+
+// Fill 填充 channel 的统计数据（Backlog InFlight Handlers 由实现填充
+func (r *ChannelRecorder) Fill(s *ChannelStats) {
+	s.Handled = r.handled.Count()
+	s.HandleRate = r.handled.Rate()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s.Failed = r.failed
+	s.Dropped = r.dropped
+	s.Skipped = r.skipped
+	if s.Handled > 0 {
+		s.Latency.Mean = r.total / time.Duration(s.Handled)
+	}
+	s.Latency.Max = r.max
+}
@@ -0,0 +1,33 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	var tr TopicRecorder
+	tr.Published(3)
+	tr.Dropped(1)
+
+	ts := TopicStats{}
+	tr.Fill(&ts)
+	assert.Equal(t, ts.Published, uint64(3))
+	assert.Equal(t, ts.PublishRate, 3/RateWindow.Seconds())
+	assert.Equal(t, ts.Dropped, uint64(1))
+
+	var cr ChannelRecorder
+	cr.Handled(time.Millisecond, nil)
+	cr.Handled(time.Millisecond*3, errors.New("failed"))
+	cr.Dropped(2)
+
+	cs := ChannelStats{}
+	cr.Fill(&cs)
+	assert.Equal(t, cs.Handled, uint64(2))
+	assert.Equal(t, cs.Failed, uint64(1))
+	assert.Equal(t, cs.Dropped, uint64(2))
+	assert.Equal(t, cs.Latency, LatencyStats{Mean: time.Millisecond * 2, Max: time.Millisecond * 3})
+}
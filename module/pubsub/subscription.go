@@ -0,0 +1,156 @@
+package pubsub
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/pojol/braid-go/internal/braidsync"
+)
+
+// ISubscription 消费句柄的订阅，由 IChannel.Arrived 和 IChannel.ArrivedAck 返回
+type ISubscription interface {
+	// Unsubscribe 停止这个句柄（不影响 channel 中的其他句柄），等待正在处理的消息完成后返回
+	//
+	// 不要在句柄内部调用 Unsubscribe（会一直等待自己完成
+	Unsubscribe() error
+}
+
+// PanicError 句柄中发生的 panic，panic 被恢复并视为处理失败（nack
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic %v", e.Value)
+}
+
+// ErrorHandler 句柄处理失败（返回 error 或者 panic，此时 err 为 *PanicError）时的回调
+type ErrorHandler func(msg *Message, err error)
+
+// SubParm 消费句柄的配置项
+type SubParm struct {
+	// Concurrency 句柄同时处理的消息数，默认为 1
+	Concurrency int
+
+	// OnError 句柄处理失败时的回调
+	OnError ErrorHandler
+}
+
+// SubOption 消费句柄的配置项
+type SubOption func(*SubParm)
+
+// WithConcurrency 设置句柄同时处理的消息数
+func WithConcurrency(num int) SubOption {
+	return func(p *SubParm) {
+		p.Concurrency = num
+	}
+}
+
+// WithErrorHandler 设置句柄处理失败时的回调
+func WithErrorHandler(handler ErrorHandler) SubOption {
+	return func(p *SubParm) {
+		p.OnError = handler
+	}
+}
+
+// NewSubParm 默认配置项加上用户设置的配置项
+func NewSubParm(opts ...SubOption) SubParm {
+	p := SubParm{
+		Concurrency: 1,
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	if p.Concurrency < 1 {
+		p.Concurrency = 1
+	}
+
+	return p
+}
+
+// Protect 包装句柄，恢复句柄中的 panic（转换为 *PanicError），并在处理失败时调用 OnError
+func (p SubParm) Protect(handler AckHandler) AckHandler {
+	return func(msg *Message) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+			if err != nil && p.OnError != nil {
+				p.OnError(msg, err)
+			}
+		}()
+
+		return handler(msg)
+	}
+}
+
+// Subscription ISubscription 的通用实现，供 pubsub 的实现使用
+//
+// 通过 Go 启动的 goroutine 以及 Acquire/Release 之间的调用在 Unsubscribe 时会被等待
+type Subscription struct {
+	sync.Mutex
+	done *braidsync.Switch
+	wg   sync.WaitGroup
+
+	// onStop 在 Unsubscribe 等待完成之后调用
+	onStop func()
+}
+
+// NewSubscription 创建订阅，onStop 在 Unsubscribe 完成后调用（可以为 nil
+func NewSubscription(onStop func()) *Subscription {
+	return &Subscription{
+		done:   braidsync.NewSwitch(),
+		onStop: onStop,
+	}
+}
+
+// Done 在 Unsubscribe 时关闭
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done.Done()
+}
+
+// Go 启动一个属于这个订阅的 goroutine
+func (s *Subscription) Go(fn func()) {
+	if !s.Acquire() {
+		return
+	}
+
+	go func() {
+		defer s.Release()
+		fn()
+	}()
+}
+
+// Acquire 开始一次属于这个订阅的调用，已经取消订阅时返回 false
+func (s *Subscription) Acquire() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done.HasOpend() {
+		return false
+	}
+
+	s.wg.Add(1)
+	return true
+}
+
+// Release 结束 Acquire 开始的调用
+func (s *Subscription) Release() {
+	s.wg.Done()
+}
+
+func (s *Subscription) Unsubscribe() error {
+	s.Lock()
+	opened := s.done.Open()
+	s.Unlock()
+
+	s.wg.Wait()
+	if opened && s.onStop != nil {
+		s.onStop()
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package pubsub
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtect(t *testing.T) {
+	var reported error
+	sp := NewSubParm(WithConcurrency(0), WithErrorHandler(func(msg *Message, err error) {
+		reported = err
+	}))
+	assert.Equal(t, sp.Concurrency, 1)
+
+	err := sp.Protect(func(msg *Message) error { panic("boom") })(NewMessage(nil))
+	var perr *PanicError
+	assert.Equal(t, errors.As(err, &perr), true)
+	assert.Equal(t, perr.Value, "boom")
+	assert.Equal(t, len(perr.Stack) > 0, true)
+	assert.Equal(t, reported, err)
+
+	reported = nil
+	assert.Equal(t, sp.Protect(func(msg *Message) error { return nil })(NewMessage(nil)), nil)
+	assert.Equal(t, reported, nil)
+}
+
+func TestSubscription(t *testing.T) {
+	var stopped, running int32
+	sub := NewSubscription(func() { atomic.AddInt32(&stopped, 1) })
+
+	sub.Go(func() {
+		<-sub.Done()
+		time.Sleep(time.Millisecond * 20)
+		atomic.StoreInt32(&running, 1)
+	})
+
+	// Unsubscribe 等待 goroutine 退出之后返回
+	assert.Equal(t, sub.Unsubscribe(), nil)
+	assert.Equal(t, atomic.LoadInt32(&running), int32(1))
+	assert.Equal(t, sub.Acquire(), false)
+
+	assert.Equal(t, sub.Unsubscribe(), nil)
+	assert.Equal(t, atomic.LoadInt32(&stopped), int32(1))
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// TypedHandler 类型化的消息句柄，v 为解码后的值（类型与 Sub 时传入的 prototype 相同，且总是指针
+//
+// 返回值的语义与 AckHandler 相同
+type TypedHandler func(msg *Message, v interface{}) error
+
+// DecodeErrorHandler 消息解码失败的回调，err 为 *DecodeError
+//
+// 回调之后消息不会交给 TypedHandler，而是按照无法解码的消息处理（发布到死信 topic 或者丢弃
+type DecodeErrorHandler func(msg *Message, err error)
+
+// TypedParm 类型化 topic 的配置项
+type TypedParm struct {
+	// ContentType 发布消息时使用的编码格式，默认为 json
+	ContentType string
+
+	// OnDecodeError 消息解码失败的回调
+	OnDecodeError DecodeErrorHandler
+}
+
+// TypedOption 类型化 topic 的配置项
+type TypedOption func(*TypedParm)
+
+// WithContentType 设置发布消息时使用的编码格式（ContentTypeJSON ContentTypeProtobuf ContentTypeMsgpack 或者通过 RegisterCodec 注册的格式
+func WithContentType(contentType string) TypedOption {
+	return func(p *TypedParm) {
+		p.ContentType = contentType
+	}
+}
+
+// WithDecodeError 设置消息解码失败的回调
+func WithDecodeError(handler DecodeErrorHandler) TypedOption {
+	return func(p *TypedParm) {
+		p.OnDecodeError = handler
+	}
+}
+
+// TypedTopic 包装 ITopic，直接发布和订阅 go 结构
+//
+// 发布时使用配置的编码格式，并将编码格式写入消息头；订阅时按照消息头中的编码格式解码，
+// 因此同一个 topic 中可以混合不同编码格式的消息
+type TypedTopic struct {
+	topic ITopic
+	parm  TypedParm
+}
+
+// NewTypedTopic 创建类型化的 topic
+func NewTypedTopic(topic ITopic, opts ...TypedOption) *TypedTopic {
+	p := TypedParm{
+		ContentType: ContentTypeJSON,
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	return &TypedTopic{
+		topic: topic,
+		parm:  p,
+	}
+}
+
+// Topic 被包装的 topic
+func (tt *TypedTopic) Topic() ITopic {
+	return tt.topic
+}
+
+// Pub 编码 v 并发送，等价于 PubContext(context.Background(), v)
+func (tt *TypedTopic) Pub(v interface{}) error {
+	return tt.PubContext(context.Background(), v)
+}
+
+// PubContext 编码 v 并发送（参考 ITopic.PubContext
+func (tt *TypedTopic) PubContext(ctx context.Context, v interface{}) error {
+	msg, err := Marshal(tt.parm.ContentType, v)
+	if err != nil {
+		return err
+	}
+
+	return tt.topic.PubContext(ctx, msg)
+}
+
+// PubDelay 编码 v 并延迟发送（参考 ITopic.PubDelay
+func (tt *TypedTopic) PubDelay(ctx context.Context, v interface{}, delay time.Duration) error {
+	msg, err := Marshal(tt.parm.ContentType, v)
+	if err != nil {
+		return err
+	}
+
+	return tt.topic.PubDelay(ctx, msg, delay)
+}
+
+// Sub 订阅 channel，消息被解码为 prototype 的类型（prototype 可以是值或者指针，例如 Foo{} 或者 &Foo{}）后交给 handler
+//
+// 解码失败的消息调用 WithDecodeError 设置的回调，不会产生零值的结构。
+// 需要设置 channel 的配置项时，先通过 Topic().Sub(channelName, opts...) 创建 channel
+func (tt *TypedTopic) Sub(channelName string, prototype interface{}, handler TypedHandler, opts ...SubOption) ISubscription {
+	return tt.topic.Sub(channelName).ArrivedAck(tt.Handler(prototype, handler), opts...)
+}
+
+// Handler 将类型化的句柄转换为 AckHandler（参考 Sub
+func (tt *TypedTopic) Handler(prototype interface{}, handler TypedHandler) AckHandler {
+	typ := reflect.TypeOf(prototype)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	return func(msg *Message) error {
+		v := reflect.New(typ).Interface()
+		if err := Unmarshal(msg, v); err != nil {
+			if tt.parm.OnDecodeError != nil {
+				tt.parm.OnDecodeError(msg, err)
+			}
+			return err
+		}
+
+		return handler(msg, v)
+	}
+}
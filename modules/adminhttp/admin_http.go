@@ -0,0 +1,245 @@
+// 实现文件 adminhttp 基于 http 实现的 admin 服务，用于查看节点中模块的配置和运行时状态
+package adminhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/pojol/braid-go/modules/moduleparm"
+)
+
+var (
+	// Name admin plugin name
+	Name = "AdminHTTP"
+)
+
+type adminBuilder struct {
+	opts []Option
+}
+
+func newAdminHTTP() module.IBuilder {
+	return &adminBuilder{}
+}
+
+func (b *adminBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	b.opts = append(b.opts, o)
+	return nil
+}
+
+func (b *adminBuilder) Name() string {
+	return Name
+}
+
+func (b *adminBuilder) Type() module.ModuleType {
+	return module.Admin
+}
+
+func (b *adminBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+	}
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (b *adminBuilder) parm(name string) Parm {
+	p := Parm{
+		ListenAddr: ":14223",
+	}
+	for _, opt := range b.opts {
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (b *adminBuilder) Validate() error {
+	p := b.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (b *adminBuilder) Build(serviceName string, buildOpts ...interface{}) interface{} {
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := b.parm(serviceName)
+
+	if bp.Node == nil {
+		panic(fmt.Errorf("%v missing node", Name))
+	}
+
+	a := &adminHTTP{
+		serviceName: serviceName,
+		parm:        p,
+		logger:      bp.Logger,
+		node:        bp.Node,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.handleNode)
+	mux.HandleFunc("/modules/", a.handleModule)
+	mux.HandleFunc("/pubsub/stats", a.handlePubsubStats)
+	a.srv = &http.Server{Handler: mux}
+
+	return a
+}
+
+// moduleView 模块的 json 视图
+type moduleView struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Instance string      `json:"instance,omitempty"`
+	State    string      `json:"state"`
+	Detail   interface{} `json:"detail,omitempty"`
+}
+
+type adminHTTP struct {
+	serviceName string
+	parm        Parm
+
+	logger logger.ILogger
+	node   module.INode
+
+	listen net.Listener
+	srv    *http.Server
+}
+
+func (a *adminHTTP) Init() error {
+	lis, err := net.Listen("tcp", a.parm.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("%v Dependency check error %v [%v]", a.serviceName, "tcp", a.parm.ListenAddr)
+	}
+
+	a.listen = lis
+	return nil
+}
+
+func (a *adminHTTP) Run() {
+	go func() {
+		if err := a.srv.Serve(a.listen); err != nil && err != http.ErrServerClosed {
+			a.logger.Errorf("run admin server err %s", err.Error())
+		}
+	}()
+}
+
+func view(info module.ModuleInfo) moduleView {
+	v := moduleView{
+		Name:     info.Name,
+		Type:     info.Type.String(),
+		Instance: info.Instance,
+		State:    info.State,
+	}
+
+	if ii, ok := info.Module.(module.IIntrospect); ok {
+		v.Detail = ii.Introspect()
+	}
+
+	return v
+}
+
+// handleNode 节点中所有模块的视图
+func (a *adminHTTP) handleNode(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	views := []moduleView{}
+	for _, info := range a.node.Modules() {
+		views = append(views, view(info))
+	}
+
+	a.write(w, map[string]interface{}{
+		"service": a.node.Name(),
+		"modules": views,
+	})
+}
+
+// handleModule 单个模块的视图 /modules/<name>[/<instance>]
+func (a *adminHTTP) handleModule(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/modules/"), "/")
+
+	name, instance := path, ""
+	if idx := strings.Index(path, "/"); idx >= 0 {
+		name, instance = path[:idx], path[idx+1:]
+	}
+
+	for _, info := range a.node.Modules() {
+		if strings.EqualFold(info.Name, name) && info.Instance == instance {
+			a.write(w, view(info))
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// statsView pubsub 模块统计数据的 json 视图
+type statsView struct {
+	Name     string              `json:"name"`
+	Instance string              `json:"instance,omitempty"`
+	Topics   []pubsub.TopicStats `json:"topics"`
+}
+
+// handlePubsubStats 节点中所有 pubsub 模块的统计数据
+func (a *adminHTTP) handlePubsubStats(w http.ResponseWriter, r *http.Request) {
+	views := []statsView{}
+	for _, info := range a.node.Modules() {
+		ps, ok := info.Module.(pubsub.IPubsub)
+		if !ok {
+			continue
+		}
+
+		views = append(views, statsView{
+			Name:     info.Name,
+			Instance: info.Instance,
+			Topics:   ps.Stats(),
+		})
+	}
+
+	a.write(w, views)
+}
+
+func (a *adminHTTP) write(w http.ResponseWriter, v interface{}) {
+	byt, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(byt)
+}
+
+// Shutdown 停止接收新的请求，并等待正在处理的请求完成
+func (a *adminHTTP) Shutdown(ctx context.Context) error {
+	return a.srv.Shutdown(ctx)
+}
+
+func (a *adminHTTP) Close() {
+	a.srv.Close()
+	if a.listen != nil {
+		a.listen.Close()
+	}
+}
+
+func init() {
+	module.Register(newAdminHTTP)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"listen": WithListen,
+	})
+}
@@ -0,0 +1,28 @@
+package adminhttp
+
+import "github.com/pojol/braid-go/module"
+
+// Parm admin 配置
+type Parm struct {
+	// ListenAddr admin 服务的侦听地址
+	ListenAddr string
+}
+
+// Option config wraps
+type Option func(*Parm)
+
+// WithListen admin 服务的侦听地址配置（默认 :14223
+func WithListen(address string) Option {
+	return func(c *Parm) {
+		c.ListenAddr = address
+	}
+}
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.ListenAddr == "" {
+		return module.InvalidOption("empty listen address")
+	}
+
+	return nil
+}
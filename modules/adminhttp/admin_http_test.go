@@ -0,0 +1,120 @@
+package adminhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/pojol/braid-go/modules/moduleparm"
+	"github.com/pojol/braid-go/modules/pubsubmem"
+	"github.com/pojol/braid-go/modules/zaplogger"
+	"github.com/stretchr/testify/assert"
+)
+
+type introspectModule struct{}
+
+func (im *introspectModule) Introspect() interface{} {
+	return map[string]interface{}{"backlog": 3}
+}
+
+type node struct {
+	modules []module.ModuleInfo
+}
+
+func (n *node) Name() string                 { return "TestAdmin" }
+func (n *node) Modules() []module.ModuleInfo { return n.modules }
+
+func TestAdmin(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestAdmin").(logger.ILogger)
+
+	n := &node{
+		modules: []module.ModuleInfo{
+			{Name: zaplogger.Name, Type: module.Logger, State: "running", Module: log},
+			{Name: "Mock", Type: module.Pubsub, Instance: "local", State: "running", Module: &introspectModule{}},
+		},
+	}
+
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithListen(":14224"))
+	a := b.Build("TestAdmin", moduleparm.WithLogger(log), moduleparm.WithNode(n)).(module.IModule)
+	assert.Equal(t, a.Init(), nil)
+	a.Run()
+	defer a.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	res, err := http.Get("http://127.0.0.1:14224/")
+	assert.Equal(t, err, nil)
+
+	var nv struct {
+		Service string       `json:"service"`
+		Modules []moduleView `json:"modules"`
+	}
+	json.NewDecoder(res.Body).Decode(&nv)
+	res.Body.Close()
+
+	assert.Equal(t, nv.Service, "TestAdmin")
+	assert.Equal(t, len(nv.Modules), 2)
+	assert.Equal(t, nv.Modules[0].Type, "logger")
+	assert.Equal(t, nv.Modules[0].Detail, nil)
+
+	res, err = http.Get("http://127.0.0.1:14224/modules/mock/local")
+	assert.Equal(t, err, nil)
+
+	var mv moduleView
+	json.NewDecoder(res.Body).Decode(&mv)
+	res.Body.Close()
+
+	assert.Equal(t, mv.Instance, "local")
+	assert.Equal(t, mv.Detail, map[string]interface{}{"backlog": float64(3)})
+
+	res, err = http.Get("http://127.0.0.1:14224/modules/mock")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+	res.Body.Close()
+}
+
+func TestPubsubStats(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestPubsubStats").(logger.ILogger)
+	ps := module.GetBuilder(pubsubmem.Name).Build("TestPubsubStats", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestPubsubStats", pubsub.ScopeProc)
+	done := make(chan struct{})
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) { close(done) })
+	topic.Pub(pubsub.NewMessage([]byte("msg")))
+	<-done
+
+	n := &node{
+		modules: []module.ModuleInfo{
+			{Name: zaplogger.Name, Type: module.Logger, State: "running", Module: log},
+			{Name: pubsubmem.Name, Type: module.Pubsub, State: "running", Module: ps},
+		},
+	}
+
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithListen(":14225"))
+	a := b.Build("TestPubsubStats", moduleparm.WithLogger(log), moduleparm.WithNode(n)).(module.IModule)
+	assert.Equal(t, a.Init(), nil)
+	a.Run()
+	defer a.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	res, err := http.Get("http://127.0.0.1:14225/pubsub/stats")
+	assert.Equal(t, err, nil)
+
+	var views []statsView
+	json.NewDecoder(res.Body).Decode(&views)
+	res.Body.Close()
+
+	assert.Equal(t, len(views), 1)
+	assert.Equal(t, views[0].Name, pubsubmem.Name)
+	assert.Equal(t, len(views[0].Topics), 1)
+	assert.Equal(t, views[0].Topics[0].Published, uint64(1))
+	assert.Equal(t, views[0].Topics[0].Channels[0].Handled, uint64(1))
+}
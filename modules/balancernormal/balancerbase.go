@@ -12,6 +12,7 @@ import (
 	"github.com/pojol/braid-go/module/balancer"
 	"github.com/pojol/braid-go/module/discover"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/moduleparm"
 )
@@ -25,7 +26,7 @@ const (
 )
 
 type baseBalanceBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func (b *baseBalanceBuilder) Name() string {
@@ -36,24 +37,57 @@ func (b *baseBalanceBuilder) Type() module.ModuleType {
 	return module.Balancer
 }
 
+func (b *baseBalanceBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Pubsub},
+		{Type: module.Discover, Optional: true},
+		{Type: module.Metrics, Optional: true},
+	}
+}
+
 func newBaseBalancerGroup() module.IBuilder {
 	return &baseBalanceBuilder{}
 }
 
-func (b *baseBalanceBuilder) AddModuleOption(opt interface{}) {
-	b.opts = append(b.opts, opt)
+func (b *baseBalanceBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	b.opts = append(b.opts, o)
+	return nil
 }
 
-func (b *baseBalanceBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+// parm 默认配置项加上用户设置的配置项
+func (b *baseBalanceBuilder) parm(name string) Parm {
+	p := Parm{}
+	for _, opt := range b.opts {
+		opt(&p)
+	}
+	return p
+}
 
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+// Validate 在构建模块之前检查配置项
+func (b *baseBalanceBuilder) Validate() error {
+	p := b.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
 	}
 
-	p := Parm{}
-	for _, opt := range b.opts {
-		opt.(Option)(&p)
+	return nil
+}
+
+func (b *baseBalanceBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := b.parm(name)
+
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
 	}
 
 	rand.Seed(time.Now().UnixNano())
@@ -63,6 +97,8 @@ func (b *baseBalanceBuilder) Build(name string, buildOpts ...interface{}) interf
 		ps:          bp.PS,
 		logger:      bp.Logger,
 		picker:      make(map[string]*balancerStrategy),
+		picks: m.Counter("braid_balancer_pick_total",
+			"Number of nodes picked by the balancer.", "service", "node"),
 	}
 
 	return bbg
@@ -107,6 +143,7 @@ type baseBalancerGroup struct {
 	logger logger.ILogger
 
 	picker map[string]*balancerStrategy
+	picks  metrics.ICounterVec
 
 	lock sync.RWMutex
 }
@@ -121,7 +158,11 @@ func (bbg *baseBalancerGroup) Init() error {
 func (bbg *baseBalancerGroup) Run() {
 
 	bbg.serviceUpdate.Arrived(func(msg *pubsub.Message) {
-		dmsg := discover.DecodeUpdateMsg(msg)
+		dmsg, err := discover.DecodeUpdateMsg(msg)
+		if err != nil {
+			bbg.logger.Warnf("%v decode service update message err %v", Name, err)
+			return
+		}
 		if dmsg.Event == discover.EventAddService {
 			bbg.lock.Lock()
 
@@ -163,22 +204,51 @@ func (bbg *baseBalancerGroup) Pick(strategy string, target string) (discover.Nod
 	defer bbg.lock.RUnlock()
 
 	var nod discover.Node
+	var err error
 
 	if _, ok := bbg.picker[target]; ok {
 		if strategy == StrategyRandom {
-			return bbg.picker[target].randomPicker.Get()
+			nod, err = bbg.picker[target].randomPicker.Get()
 		} else if strategy == StrategySwrr {
-			return bbg.picker[target].swrrPicker.Get()
+			nod, err = bbg.picker[target].swrrPicker.Get()
+		} else {
+			return nod, errors.New("can't find balancer, with strategy")
 		}
+
+		if err == nil {
+			bbg.picks.With(target, nod.ID).Inc()
+		}
+		return nod, err
 	}
 
 	return nod, errors.New("can't find balancer, with strategy")
 }
 
+// Introspect 每个服务的节点列表以及节点的权重
+func (bbg *baseBalancerGroup) Introspect() interface{} {
+	bbg.lock.RLock()
+	defer bbg.lock.RUnlock()
+
+	services := make(map[string]interface{})
+	for name, s := range bbg.picker {
+		if swrr, ok := s.swrrPicker.(*swrrBalancer); ok {
+			services[name] = swrr.weights()
+		}
+	}
+
+	return map[string]interface{}{
+		"strategies": bbg.parm.strategies,
+		"services":   services,
+	}
+}
+
 func (bbg *baseBalancerGroup) Close() {
 
 }
 
 func init() {
-	module.Register(newBaseBalancerGroup())
+	module.Register(newBaseBalancerGroup)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"strategy": WithStrategy,
+	})
 }
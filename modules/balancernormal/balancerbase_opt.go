@@ -1,5 +1,7 @@
 package balancernormal
 
+import "github.com/pojol/braid-go/module"
+
 // Parm balancer group parm
 type Parm struct {
 	strategies []string
@@ -14,3 +16,14 @@ func WithStrategy(strategies []string) Option {
 		c.strategies = strategies
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	for _, s := range p.strategies {
+		if s != StrategyRandom && s != StrategySwrr {
+			return module.InvalidOption("unknown strategy %q", s)
+		}
+	}
+
+	return nil
+}
@@ -23,6 +23,24 @@ type swrrBalancer struct {
 	sync.Mutex
 }
 
+// weights 节点列表以及节点当前的权重
+func (wr *swrrBalancer) weights() []map[string]interface{} {
+	wr.Lock()
+	defer wr.Unlock()
+
+	nods := make([]map[string]interface{}, 0, len(wr.nods))
+	for _, v := range wr.nods {
+		nods = append(nods, map[string]interface{}{
+			"id":         v.orgNod.ID,
+			"address":    v.orgNod.Address,
+			"weight":     v.orgNod.Weight,
+			"cur_weight": v.curWeight,
+		})
+	}
+
+	return nods
+}
+
 func (wr *swrrBalancer) calcTotalWeight() {
 	wr.totalWeight = 0
 
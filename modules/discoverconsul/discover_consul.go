@@ -2,6 +2,7 @@
 package discoverconsul
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -9,11 +10,13 @@ import (
 	"time"
 
 	"github.com/pojol/braid-go/3rd/consul"
+	"github.com/pojol/braid-go/internal/braidsync"
 	"github.com/pojol/braid-go/internal/utils"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/discover"
 	"github.com/pojol/braid-go/module/linkcache"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/moduleparm"
 )
@@ -37,7 +40,7 @@ var (
 )
 
 type consulDiscoverBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newConsulDiscover() module.IBuilder {
@@ -52,17 +55,26 @@ func (b *consulDiscoverBuilder) Type() module.ModuleType {
 	return module.Discover
 }
 
-func (b *consulDiscoverBuilder) AddModuleOption(opt interface{}) {
-	b.opts = append(b.opts, opt)
+func (b *consulDiscoverBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Pubsub},
+		{Type: module.Metrics, Optional: true},
+	}
 }
 
-func (b *consulDiscoverBuilder) Build(name string, buildOpts ...interface{}) interface{} {
-
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+func (b *consulDiscoverBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
 	}
 
+	b.opts = append(b.opts, o)
+	return nil
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (b *consulDiscoverBuilder) parm(name string) Parm {
 	p := Parm{
 		Tag:                       "braid",
 		Name:                      name,
@@ -70,9 +82,31 @@ func (b *consulDiscoverBuilder) Build(name string, buildOpts ...interface{}) int
 		SyncServiceWeightInterval: time.Second * 10,
 		Address:                   "http://127.0.0.1:8500",
 	}
-
 	for _, opt := range b.opts {
-		opt.(Option)(&p)
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (b *consulDiscoverBuilder) Validate() error {
+	p := b.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (b *consulDiscoverBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := b.parm(name)
+
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
 	}
 
 	e := &consulDiscover{
@@ -80,6 +114,9 @@ func (b *consulDiscoverBuilder) Build(name string, buildOpts ...interface{}) int
 		ps:         bp.PS,
 		logger:     bp.Logger,
 		passingMap: make(map[string]*syncNode),
+		done:       braidsync.NewSwitch(),
+		events: m.Counter("braid_discover_events_total",
+			"Number of service nodes added to or removed from discovery.", "event"),
 	}
 
 	e.ps.RegistTopic(discover.ServiceUpdate, pubsub.ScopeProc)
@@ -94,6 +131,7 @@ func (dc *consulDiscover) Init() error {
 	if err != nil {
 		return fmt.Errorf("%v Dependency check error %v [%v]", dc.parm.Name, "consul", dc.parm.Address)
 	}
+	dc.health.Record(nil)
 
 	ip, err := utils.GetLocalIP()
 	if err != nil {
@@ -119,6 +157,12 @@ type consulDiscover struct {
 	discoverTicker   *time.Ticker
 	syncWeightTicker *time.Ticker
 
+	done      *braidsync.Switch
+	waitGroup braidsync.WaitGroupWrapper
+
+	health module.HealthRecorder
+	events metrics.ICounterVec
+
 	// parm
 	parm   Parm
 	ps     pubsub.IPubsub
@@ -158,6 +202,7 @@ func (dc *consulDiscover) discoverImpl() {
 	defer dc.lock.Unlock()
 
 	services, err := consul.GetCatalogServices(dc.parm.Address, dc.parm.Tag)
+	dc.health.Record(err)
 	if err != nil {
 		return
 	}
@@ -185,6 +230,7 @@ func (dc *consulDiscover) discoverImpl() {
 			}
 			dc.logger.Infof("new service %s addr %s", service.ServiceName, sn.address)
 			dc.passingMap[service.ServiceID] = &sn
+			dc.events.With("add").Inc()
 
 			dc.ps.GetTopic(discover.ServiceUpdate).Pub(discover.EncodeUpdateMsg(
 				discover.EventAddService,
@@ -212,6 +258,7 @@ func (dc *consulDiscover) discoverImpl() {
 			))
 
 			delete(dc.passingMap, k)
+			dc.events.With("remove").Inc()
 		}
 	}
 }
@@ -259,14 +306,14 @@ func (dc *consulDiscover) discover() {
 		dc.discoverImpl()
 	}
 
-	dc.discoverTicker = time.NewTicker(dc.parm.SyncServicesInterval)
-
-	dc.discoverImpl()
+	syncService()
 
 	for {
 		select {
 		case <-dc.discoverTicker.C:
 			syncService()
+		case <-dc.done.Done():
+			return
 		}
 	}
 }
@@ -282,32 +329,79 @@ func (dc *consulDiscover) weight() {
 		dc.syncWeight()
 	}
 
-	dc.syncWeightTicker = time.NewTicker(dc.parm.SyncServiceWeightInterval)
-
 	for {
 		select {
 		case <-dc.syncWeightTicker.C:
 			syncWeight()
+		case <-dc.done.Done():
+			return
 		}
 	}
 }
 
 // Discover 运行管理器
 func (dc *consulDiscover) Run() {
-	go func() {
-		dc.discover()
-	}()
+	dc.discoverTicker = time.NewTicker(dc.parm.SyncServicesInterval)
+	dc.syncWeightTicker = time.NewTicker(dc.parm.SyncServiceWeightInterval)
+
+	dc.waitGroup.Wrap(dc.discover)
+	dc.waitGroup.Wrap(dc.weight)
+}
+
+func (dc *consulDiscover) stop() {
+	if dc.done.Open() && dc.discoverTicker != nil {
+		dc.discoverTicker.Stop()
+		dc.syncWeightTicker.Stop()
+	}
+}
+
+// Shutdown 停止服务发现的后台循环，并等待循环退出
+func (dc *consulDiscover) Shutdown(ctx context.Context) error {
+	dc.stop()
 
-	go func() {
-		dc.weight()
-	}()
+	return dc.waitGroup.WaitContext(ctx)
+}
+
+// Introspect 配置项以及当前发现的服务节点
+func (dc *consulDiscover) Introspect() interface{} {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+
+	nodes := []map[string]interface{}{}
+	for _, n := range dc.passingMap {
+		nodes = append(nodes, map[string]interface{}{
+			"service":     n.service,
+			"id":          n.id,
+			"address":     n.address,
+			"linknum":     n.linknum,
+			"dync_weight": n.dyncWeight,
+			"phys_weight": n.physWeight,
+		})
+	}
+
+	return map[string]interface{}{
+		"parm":  dc.parm,
+		"nodes": nodes,
+	}
 }
 
 // Close close
 func (dc *consulDiscover) Close() {
+	dc.stop()
+}
 
+// Health 最近一次从 consul 同步服务列表的结果
+func (dc *consulDiscover) Health() module.Health {
+	return dc.health.Health()
 }
 
 func init() {
-	module.Register(newConsulDiscover())
+	module.Register(newConsulDiscover)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"consul_addr":                  WithConsulAddr,
+		"tag":                          WithTag,
+		"blacklist":                    WithBlacklist,
+		"sync_service_interval":        WithSyncServiceInterval,
+		"sync_service_weight_interval": WithSyncServiceWeightInterval,
+	})
 }
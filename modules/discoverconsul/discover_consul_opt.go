@@ -2,6 +2,8 @@ package discoverconsul
 
 import (
 	"time"
+
+	"github.com/pojol/braid-go/module"
 )
 
 // Parm discover config
@@ -59,3 +61,15 @@ func WithConsulAddr(address string) Option {
 		c.Address = address
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.Address == "" {
+		return module.InvalidOption("empty consul address")
+	}
+	if p.SyncServicesInterval <= 0 || p.SyncServiceWeightInterval <= 0 {
+		return module.InvalidOption("sync interval must be positive")
+	}
+
+	return nil
+}
@@ -2,14 +2,19 @@
 package electorconsul
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pojol/braid-go/3rd/consul"
+	"github.com/pojol/braid-go/internal/braidsync"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/elector"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/moduleparm"
 )
@@ -25,28 +30,37 @@ var (
 )
 
 type consulElectionBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newConsulElection() module.IBuilder {
 	return &consulElectionBuilder{}
 }
 
-func (eb *consulElectionBuilder) AddModuleOption(opt interface{}) {
-	eb.opts = append(eb.opts, opt)
+func (eb *consulElectionBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	eb.opts = append(eb.opts, o)
+	return nil
 }
 
 func (eb *consulElectionBuilder) Type() module.ModuleType {
 	return module.Elector
 }
 
-func (eb *consulElectionBuilder) Build(name string, buildOpts ...interface{}) interface{} {
-
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+func (eb *consulElectionBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Pubsub},
+		{Type: module.Metrics, Optional: true},
 	}
+}
 
+// parm 默认配置项加上用户设置的配置项
+func (eb *consulElectionBuilder) parm(name string) Parm {
 	p := Parm{
 		ConsulAddr:        "http://127.0.0.1:8500",
 		ServiceName:       name,
@@ -54,13 +68,40 @@ func (eb *consulElectionBuilder) Build(name string, buildOpts ...interface{}) in
 		RefushSessionTick: time.Second * 5,
 	}
 	for _, opt := range eb.opts {
-		opt.(Option)(&p)
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (eb *consulElectionBuilder) Validate() error {
+	p := eb.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (eb *consulElectionBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := eb.parm(name)
+
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
 	}
 
 	e := &consulElection{
 		parm:   p,
 		ps:     bp.PS,
 		logger: bp.Logger,
+		done:   braidsync.NewSwitch(),
+		state:  elector.EWait,
+		transitions: m.Counter("braid_elector_transitions_total",
+			"Number of elector state changes.", "state"),
 	}
 
 	e.ps.RegistTopic(elector.ChangeState, pubsub.ScopeProc)
@@ -75,6 +116,7 @@ func (e *consulElection) Init() error {
 	}
 
 	e.sessionID = sid
+	e.health.Record(nil)
 
 	return nil
 }
@@ -87,8 +129,18 @@ type consulElection struct {
 	lockTicker   *time.Ticker
 	refushTicker *time.Ticker
 
+	done      *braidsync.Switch
+	waitGroup braidsync.WaitGroupWrapper
+	release   sync.Once
+
 	sessionID string
-	locked    bool
+	locked    int32
+
+	health module.HealthRecorder
+
+	// state 最近一次广播的选举状态，只在 watch 中访问
+	state       string
+	transitions metrics.ICounterVec
 
 	logger logger.ILogger
 
@@ -96,6 +148,13 @@ type consulElection struct {
 	parm Parm
 }
 
+func (e *consulElection) transition(state string) {
+	if e.state != state {
+		e.state = state
+		e.transitions.With(state).Inc()
+	}
+}
+
 func (e *consulElection) watch() {
 	watchLock := func() {
 		defer func() {
@@ -104,13 +163,16 @@ func (e *consulElection) watch() {
 			}
 		}()
 
-		if !e.locked {
-			succ, _ := consul.AcquireLock(e.parm.ConsulAddr, e.parm.ServiceName, e.sessionID)
+		if atomic.LoadInt32(&e.locked) == 0 {
+			succ, err := consul.AcquireLock(e.parm.ConsulAddr, e.parm.ServiceName, e.sessionID)
+			e.health.Record(err)
 			if succ {
-				e.locked = true
+				atomic.StoreInt32(&e.locked, 1)
+				e.transition(elector.EMaster)
 				e.ps.GetTopic(elector.ChangeState).Pub(elector.EncodeStateChangeMsg(elector.EMaster))
 				e.logger.Debugf("acquire lock service %s, id %s", e.parm.ServiceName, e.sessionID)
 			} else {
+				e.transition(elector.ESlave)
 				e.ps.GetTopic(elector.ChangeState).Pub(elector.EncodeStateChangeMsg(elector.ESlave))
 			}
 		}
@@ -118,13 +180,12 @@ func (e *consulElection) watch() {
 
 	watchLock()
 
-	// time.Millisecond * 2000
-	e.lockTicker = time.NewTicker(e.parm.LockTick)
-
 	for {
 		select {
 		case <-e.lockTicker.C:
 			watchLock()
+		case <-e.done.Done():
+			return
 		}
 	}
 }
@@ -138,37 +199,88 @@ func (e *consulElection) refush() {
 			}
 		}()
 
-		consul.RefushSession(e.parm.ConsulAddr, e.sessionID)
+		e.health.Record(consul.RefushSession(e.parm.ConsulAddr, e.sessionID))
 	}
 
-	// time.Millisecond * 1000 * 5
-	e.refushTicker = time.NewTicker(e.parm.RefushSessionTick)
-
 	for {
 		select {
 		case <-e.refushTicker.C:
 			refushSession()
+		case <-e.done.Done():
+			return
 		}
 	}
 }
 
 // Run session 状态检查
 func (e *consulElection) Run() {
-	go func() {
-		e.refush()
-	}()
+	// time.Millisecond * 1000 * 5
+	e.refushTicker = time.NewTicker(e.parm.RefushSessionTick)
+	// time.Millisecond * 2000
+	e.lockTicker = time.NewTicker(e.parm.LockTick)
+
+	e.waitGroup.Wrap(e.refush)
+	e.waitGroup.Wrap(e.watch)
+}
+
+func (e *consulElection) stop() {
+	if e.done.Open() && e.lockTicker != nil {
+		e.lockTicker.Stop()
+		e.refushTicker.Stop()
+	}
+}
+
+// releaseSession 释放锁，删除session
+func (e *consulElection) releaseSession() {
+	e.release.Do(func() {
+		consul.ReleaseLock(e.parm.ConsulAddr, e.parm.ServiceName, e.sessionID)
+		consul.DeleteSession(e.parm.ConsulAddr, e.sessionID)
+	})
+}
+
+// Shutdown 停止选举的后台循环，并释放锁（让其他节点可以尽快成为主节点
+func (e *consulElection) Shutdown(ctx context.Context) error {
+	e.stop()
 
-	go func() {
-		e.watch()
-	}()
+	err := e.waitGroup.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.releaseSession()
+	return nil
+}
+
+// Introspect 配置项以及当前的选举状态
+func (e *consulElection) Introspect() interface{} {
+	state := elector.ESlave
+	if atomic.LoadInt32(&e.locked) == 1 {
+		state = elector.EMaster
+	}
+
+	return map[string]interface{}{
+		"parm":    e.parm,
+		"state":   state,
+		"session": e.sessionID,
+	}
 }
 
 // Close 释放锁，删除session
 func (e *consulElection) Close() {
-	consul.ReleaseLock(e.parm.ConsulAddr, e.parm.ServiceName, e.sessionID)
-	consul.DeleteSession(e.parm.ConsulAddr, e.sessionID)
+	e.stop()
+	e.releaseSession()
+}
+
+// Health 最近一次刷新 session（或者获取锁）的结果
+func (e *consulElection) Health() module.Health {
+	return e.health.Health()
 }
 
 func init() {
-	module.Register(newConsulElection())
+	module.Register(newConsulElection)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"consul_addr":  WithConsulAddr,
+		"lock_tick":    WithLockTick,
+		"session_tick": WithSessionTick,
+	})
 }
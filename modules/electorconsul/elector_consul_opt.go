@@ -2,6 +2,8 @@ package electorconsul
 
 import (
 	"time"
+
+	"github.com/pojol/braid-go/module"
 )
 
 // Parm 选举器配置项
@@ -35,3 +37,15 @@ func WithSessionTick(t time.Duration) Option {
 		c.RefushSessionTick = t
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.ConsulAddr == "" {
+		return module.InvalidOption("empty consul address")
+	}
+	if p.LockTick <= 0 || p.RefushSessionTick <= 0 {
+		return module.InvalidOption("lock tick and session tick must be positive")
+	}
+
+	return nil
+}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/pojol/braid-go/internal/braidsync"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/elector"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/moduleparm"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -31,7 +33,7 @@ var (
 )
 
 type k8sElectorBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newK8sElector() module.IBuilder {
@@ -61,30 +63,64 @@ func (*k8sElectorBuilder) Type() module.ModuleType {
 	return module.Elector
 }
 
-func (eb *k8sElectorBuilder) AddModuleOption(opt interface{}) {
-	eb.opts = append(eb.opts, opt)
+func (*k8sElectorBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Pubsub},
+		{Type: module.Metrics, Optional: true},
+	}
 }
 
-func (eb *k8sElectorBuilder) Build(serviceName string, buildOpts ...interface{}) interface{} {
-
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+func (eb *k8sElectorBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
 	}
 
+	eb.opts = append(eb.opts, o)
+	return nil
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (eb *k8sElectorBuilder) parm(name string) Parm {
 	p := Parm{
-		ServiceName: serviceName,
+		ServiceName: name,
 		Namespace:   "default",
 		RetryPeriod: time.Second * 2,
 	}
 	for _, opt := range eb.opts {
-		opt.(Option)(&p)
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (eb *k8sElectorBuilder) Validate() error {
+	p := eb.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (eb *k8sElectorBuilder) Build(serviceName string, buildOpts ...interface{}) interface{} {
+
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := eb.parm(serviceName)
+
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
 	}
 
 	el := &k8sElector{
 		parm:   p,
 		ps:     bp.PS,
 		logger: bp.Logger,
+		transitions: m.Counter("braid_elector_transitions_total",
+			"Number of elector state changes.", "state"),
 	}
 
 	el.ps.RegistTopic(elector.ChangeState, pubsub.ScopeProc)
@@ -120,10 +156,12 @@ func (e *k8sElector) Init() error {
 			OnStoppedLeading: func() {},
 			OnNewLeader: func(identity string) {
 				if identity == e.parm.NodID {
+					e.transitions.With(elector.EMaster).Inc()
 					e.ps.GetTopic(elector.ChangeState).Pub(elector.EncodeStateChangeMsg(elector.EMaster))
 					e.logger.Debugf("new leader %s %s", e.parm.NodID, identity)
 
 				} else {
+					e.transitions.With(elector.ESlave).Inc()
 					e.ps.GetTopic(elector.ChangeState).Pub(elector.EncodeStateChangeMsg(elector.ESlave))
 				}
 			},
@@ -143,6 +181,11 @@ type k8sElector struct {
 	ps      pubsub.IPubsub
 	lock    *resourcelock.LeaseLock
 	elector *leaderelection.LeaderElector
+
+	cancel    context.CancelFunc
+	waitGroup braidsync.WaitGroupWrapper
+
+	transitions metrics.ICounterVec
 }
 
 func (e *k8sElector) IsMaster() bool {
@@ -151,14 +194,50 @@ func (e *k8sElector) IsMaster() bool {
 
 func (e *k8sElector) Run() {
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	e.elector.Run(ctx)
+	e.cancel = cancel
+
+	e.waitGroup.Wrap(func() {
+		e.elector.Run(ctx)
+	})
 }
 
-func (e *k8sElector) Close() {
+// Shutdown 停止选举，并释放租约（ReleaseOnCancel
+func (e *k8sElector) Shutdown(ctx context.Context) error {
+	if e.cancel != nil {
+		e.cancel()
+	}
 
+	return e.waitGroup.WaitContext(ctx)
+}
+
+// Introspect 配置项以及当前的选举状态
+func (e *k8sElector) Introspect() interface{} {
+	state := elector.EWait
+	if e.elector != nil {
+		state = elector.ESlave
+		if e.elector.IsLeader() {
+			state = elector.EMaster
+		}
+	}
+
+	return map[string]interface{}{
+		"parm":  e.parm,
+		"state": state,
+	}
+}
+
+func (e *k8sElector) Close() {
+	if e.cancel != nil {
+		e.cancel()
+	}
 }
 
 func init() {
-	module.Register(newK8sElector())
+	module.Register(newK8sElector)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"kube_config": WithKubeConfig,
+		"nod_id":      WithNodID,
+		"namespace":   WithNamespace,
+		"retry_tick":  WithRetryTick,
+	})
 }
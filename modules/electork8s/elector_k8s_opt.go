@@ -2,6 +2,8 @@ package electork8s
 
 import (
 	"time"
+
+	"github.com/pojol/braid-go/module"
 )
 
 // Parm k8s elector config
@@ -45,3 +47,15 @@ func WithRetryTick(t time.Duration) Option {
 		c.RetryPeriod = t
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.Namespace == "" {
+		return module.InvalidOption("empty namespace")
+	}
+	if p.RetryPeriod <= 0 {
+		return module.InvalidOption("retry tick must be positive")
+	}
+
+	return nil
+}
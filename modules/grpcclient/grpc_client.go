@@ -15,6 +15,7 @@ import (
 	"github.com/pojol/braid-go/module/discover"
 	"github.com/pojol/braid-go/module/linkcache"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/balancernormal"
 	"github.com/pojol/braid-go/modules/jaegertracing"
@@ -39,7 +40,7 @@ var (
 )
 
 type grpcClientBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newGRPCClient() module.IBuilder {
@@ -54,30 +55,65 @@ func (b *grpcClientBuilder) Type() module.ModuleType {
 	return module.Client
 }
 
-func (b *grpcClientBuilder) AddModuleOption(opt interface{}) {
-	b.opts = append(b.opts, opt)
+func (b *grpcClientBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Pubsub},
+		{Type: module.Balancer},
+		{Type: module.Tracer, Optional: true},
+		{Type: module.Linkcache, Optional: true},
+		{Type: module.Metrics, Optional: true},
+	}
 }
 
-func (b *grpcClientBuilder) Build(name string, buildOpts ...interface{}) interface{} {
-
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+func (b *grpcClientBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
 	}
 
+	b.opts = append(b.opts, o)
+	return nil
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (b *grpcClientBuilder) parm(name string) Parm {
 	p := Parm{
 		PoolInitNum:  8,
 		PoolCapacity: 64,
 		PoolIdle:     time.Second * 100,
 	}
 	for _, opt := range b.opts {
-		opt.(Option)(&p)
+		opt(&p)
 	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (b *grpcClientBuilder) Validate() error {
+	p := b.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (b *grpcClientBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := b.parm(name)
 
 	if bp.Balancer == nil {
 		panic("")
 	}
 
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
+	}
+
 	c := &grpcClient{
 		serviceName: name,
 		parm:        p,
@@ -85,6 +121,10 @@ func (b *grpcClientBuilder) Build(name string, buildOpts ...interface{}) interfa
 		ps:          bp.PS,
 		b:           bp.Balancer,
 		linkcache:   bp.Linkcache,
+		duration: m.Histogram("braid_rpc_client_duration_seconds",
+			"Duration of rpc calls issued by the client.", nil, "service", "method"),
+		errors: m.Counter("braid_rpc_client_errors_total",
+			"Number of rpc calls issued by the client that failed.", "service", "method"),
 	}
 
 	if bp.Tracer != nil {
@@ -107,6 +147,9 @@ type grpcClient struct {
 
 	ps pubsub.IPubsub
 
+	duration metrics.IHistogramVec
+	errors   metrics.ICounterVec
+
 	connmap sync.Map
 }
 
@@ -159,11 +202,13 @@ func (c *grpcClient) closeconn(conn *grpc.ClientConn) error {
 }
 
 func (c *grpcClient) Init() error {
-	var err error
-
 	serviceUpdate := c.ps.GetTopic(discover.ServiceUpdate).Sub(Name)
 	serviceUpdate.Arrived(func(msg *pubsub.Message) {
-		dmsg := discover.DecodeUpdateMsg(msg)
+		dmsg, err := discover.DecodeUpdateMsg(msg)
+		if err != nil {
+			c.logger.Warnf("%v decode service update message err %v", Name, err)
+			return
+		}
 		if dmsg.Event == discover.EventAddService {
 			_, ok := c.connmap.Load(dmsg.Nod.Address)
 			if !ok {
@@ -270,13 +315,16 @@ func (c *grpcClient) Invoke(ctx context.Context, nodName, methon, token string,
 	var address string
 	var grpcopts []grpc.CallOption
 
+	begin := time.Now()
 	address = c.findTarget(ctx, token, nodName)
 	if address == "" {
+		c.errors.With(nodName, methon).Inc()
 		return fmt.Errorf("find target warning %s %s", token, nodName)
 	}
 
 	conn, err := c.getConn(address)
 	if err != nil {
+		c.errors.With(nodName, methon).Inc()
 		c.logger.Debugf("client get conn warning %s", err.Error())
 		return err
 	}
@@ -292,7 +340,9 @@ func (c *grpcClient) Invoke(ctx context.Context, nodName, methon, token string,
 	}
 
 	err = conn.Invoke(ctx, methon, args, reply, grpcopts...)
+	c.duration.With(nodName, methon).Observe(time.Since(begin).Seconds())
 	if err != nil {
+		c.errors.With(nodName, methon).Inc()
 		c.logger.Warnf("client invoke warning %s, target = %s, methon = %s, addr = %s, token = %s", err.Error(), nodName, methon, address, token)
 		if c.linkcache != nil {
 			c.linkcache.Unlink(token)
@@ -302,9 +352,39 @@ func (c *grpcClient) Invoke(ctx context.Context, nodName, methon, token string,
 	return err
 }
 
+// Introspect 配置项以及每个目标地址的连接状态
+func (c *grpcClient) Introspect() interface{} {
+	conns := make(map[string]string)
+	c.connmap.Range(func(key, value interface{}) bool {
+		if conn, ok := value.(*grpc.ClientConn); ok {
+			conns[key.(string)] = conn.GetState().String()
+		}
+		return true
+	})
+
+	return map[string]interface{}{
+		"parm":  c.parm,
+		"conns": conns,
+	}
+}
+
+// Close 关闭所有的连接
 func (c *grpcClient) Close() {
+	c.connmap.Range(func(key, value interface{}) bool {
+		err := c.closeconn(value.(*grpc.ClientConn))
+		if err != nil {
+			c.logger.Warnf("close grpc conn %v err %s", key, err.Error())
+		}
+		c.connmap.Delete(key)
+		return true
+	})
 }
 
 func init() {
-	module.Register(newGRPCClient())
+	module.Register(newGRPCClient)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"pool_init_num": WithPoolInitNum,
+		"pool_capacity": WithPoolCapacity,
+		"pool_idle":     WithPoolIdle,
+	})
 }
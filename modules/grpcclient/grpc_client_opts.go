@@ -3,6 +3,7 @@ package grpcclient
 import (
 	"time"
 
+	"github.com/pojol/braid-go/module"
 	"google.golang.org/grpc"
 )
 
@@ -46,3 +47,15 @@ func AppendInterceptors(interceptor grpc.UnaryClientInterceptor) Option {
 		c.interceptors = append(c.interceptors, interceptor)
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.PoolCapacity <= 0 {
+		return module.InvalidOption("pool capacity must be positive")
+	}
+	if p.PoolInitNum < 0 || p.PoolInitNum > p.PoolCapacity {
+		return module.InvalidOption("pool init num %d out of range [0, %d]", p.PoolInitNum, p.PoolCapacity)
+	}
+
+	return nil
+}
@@ -2,14 +2,17 @@
 package grpcserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/modules/jaegertracing"
 	"github.com/pojol/braid-go/modules/moduleparm"
 	"google.golang.org/grpc"
@@ -26,15 +29,21 @@ var (
 )
 
 type grpcServerBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newGRPCServer() module.IBuilder {
 	return &grpcServerBuilder{}
 }
 
-func (b *grpcServerBuilder) AddModuleOption(opt interface{}) {
-	b.opts = append(b.opts, opt)
+func (b *grpcServerBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	b.opts = append(b.opts, o)
+	return nil
 }
 
 func (b *grpcServerBuilder) Name() string {
@@ -45,19 +54,40 @@ func (b *grpcServerBuilder) Type() module.ModuleType {
 	return module.Server
 }
 
-func (b *grpcServerBuilder) Build(serviceName string, buildOpts ...interface{}) interface{} {
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+func (b *grpcServerBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Tracer, Optional: true},
+		{Type: module.Metrics, Optional: true},
 	}
+}
 
+// parm 默认配置项加上用户设置的配置项
+func (b *grpcServerBuilder) parm(name string) Parm {
 	p := Parm{
 		ListenAddr: ":14222",
 	}
 	for _, opt := range b.opts {
-		opt.(Option)(&p)
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (b *grpcServerBuilder) Validate() error {
+	p := b.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
 	}
 
+	return nil
+}
+
+func (b *grpcServerBuilder) Build(serviceName string, buildOpts ...interface{}) interface{} {
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := b.parm(serviceName)
+
 	s := &grpcServer{
 		parm:        p,
 		logger:      bp.Logger,
@@ -69,6 +99,10 @@ func (b *grpcServerBuilder) Build(serviceName string, buildOpts ...interface{})
 		p.interceptors = append(p.interceptors, jaegertracing.ServerInterceptor(s.tracer))
 	}
 
+	if bp.Metrics != nil {
+		p.interceptors = append(p.interceptors, metricsInterceptor(bp.Metrics))
+	}
+
 	if len(p.interceptors) != 0 {
 		s.rpc = grpc.NewServer(grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(p.interceptors...)))
 	} else {
@@ -78,6 +112,26 @@ func (b *grpcServerBuilder) Build(serviceName string, buildOpts ...interface{})
 	return s
 }
 
+// metricsInterceptor 统计每个方法的处理耗时以及失败次数
+func metricsInterceptor(m metrics.IMetrics) grpc.UnaryServerInterceptor {
+	duration := m.Histogram("braid_rpc_server_duration_seconds",
+		"Duration of rpc calls handled by the server.", nil, "method")
+	errs := m.Counter("braid_rpc_server_errors_total",
+		"Number of rpc calls handled by the server that failed.", "method")
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		begin := time.Now()
+		res, err := handler(ctx, req)
+
+		duration.With(info.FullMethod).Observe(time.Since(begin).Seconds())
+		if err != nil {
+			errs.With(info.FullMethod).Inc()
+		}
+
+		return res, err
+	}
+}
+
 // Server RPC 服务端
 type grpcServer struct {
 	rpc         *grpc.Server
@@ -117,6 +171,32 @@ func (s *grpcServer) Run() {
 	}()
 }
 
+// Shutdown 停止接收新的请求，并等待正在处理的请求完成
+//
+// 如果在 ctx 结束前没能完成，则直接关闭所有的连接
+func (s *grpcServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.rpc.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.rpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// Introspect 配置项
+func (s *grpcServer) Introspect() interface{} {
+	return map[string]interface{}{
+		"parm": s.parm,
+	}
+}
+
 // Close 退出处理
 func (s *grpcServer) Close() {
 	s.logger.Debugf("grpc-server closed")
@@ -124,5 +204,8 @@ func (s *grpcServer) Close() {
 }
 
 func init() {
-	module.Register(newGRPCServer())
+	module.Register(newGRPCServer)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"listen": WithListen,
+	})
 }
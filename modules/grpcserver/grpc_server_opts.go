@@ -1,6 +1,7 @@
 package grpcserver
 
 import (
+	"github.com/pojol/braid-go/module"
 	"google.golang.org/grpc"
 )
 
@@ -26,3 +27,12 @@ func AppendInterceptors(interceptor grpc.UnaryServerInterceptor) Option {
 		c.interceptors = append(c.interceptors, interceptor)
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.ListenAddr == "" {
+		return module.InvalidOption("empty listen address")
+	}
+
+	return nil
+}
@@ -10,7 +10,6 @@ import (
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/tracer"
-	"github.com/pojol/braid-go/modules/moduleparm"
 	"github.com/uber/jaeger-client-go"
 	jaegerCfg "github.com/uber/jaeger-client-go/config"
 	"github.com/uber/jaeger-client-go/transport"
@@ -28,7 +27,7 @@ var (
 )
 
 type jaegerTracingBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newJaegerTracingBuilder() module.IBuilder {
@@ -44,8 +43,20 @@ func (jtb *jaegerTracingBuilder) Type() module.ModuleType {
 	return module.Tracer
 }
 
-func (jtb *jaegerTracingBuilder) AddModuleOption(opt interface{}) {
-	jtb.opts = append(jtb.opts, opt)
+func (jtb *jaegerTracingBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+	}
+}
+
+func (jtb *jaegerTracingBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	jtb.opts = append(jtb.opts, o)
+	return nil
 }
 
 func newTransport(rc *jaegerCfg.ReporterConfig) (jaeger.Transport, error) {
@@ -61,22 +72,32 @@ func newTransport(rc *jaegerCfg.ReporterConfig) (jaeger.Transport, error) {
 	}
 }
 
-func (jtb *jaegerTracingBuilder) Build(name string, buildOpts ...interface{}) interface{} {
-
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
-	}
-
+// parm 默认配置项加上用户设置的配置项
+func (jtb *jaegerTracingBuilder) parm(name string) Parm {
 	p := Parm{
 		Probabilistic: 1,
 		SlowRequest:   time.Millisecond * 200,
 		SlowSpan:      time.Millisecond * 50,
 	}
-
 	for _, opt := range jtb.opts {
-		opt.(Option)(&p)
+		opt(&p)
 	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (jtb *jaegerTracingBuilder) Validate() error {
+	p := jtb.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (jtb *jaegerTracingBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+
+	p := jtb.parm(name)
 
 	jcfg := jaegerCfg.Configuration{
 		Sampler: &jaegerCfg.SamplerConfig{
@@ -162,10 +183,28 @@ func (jt *jaegerTracing) GetTracing() interface{} {
 	return jt.tracing
 }
 
+// Introspect 配置项
+func (jt *jaegerTracing) Introspect() interface{} {
+	return map[string]interface{}{
+		"collector_endpoint":    jt.parm.CollectorEndpoint,
+		"local_agent_host_port": jt.parm.LocalAgentHostPort,
+		"probabilistic":         jt.parm.Probabilistic,
+		"slow_request":          jt.parm.SlowRequest.String(),
+		"slow_span":             jt.parm.SlowSpan.String(),
+	}
+}
+
 func (jt *jaegerTracing) Close() {
 	jt.closer.Close()
 }
 
 func init() {
-	module.Register(newJaegerTracingBuilder())
+	module.Register(newJaegerTracingBuilder)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"probabilistic": WithProbabilistic,
+		"slow_request":  WithSlowRequest,
+		"slow_span":     WithSlowSpan,
+		"http":          WithHTTP,
+		"udp":           WithUDP,
+	})
 }
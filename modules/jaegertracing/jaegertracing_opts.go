@@ -3,6 +3,7 @@ package jaegertracing
 import (
 	"time"
 
+	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/tracer"
 )
 
@@ -66,3 +67,15 @@ func WithSpanFactory(factory ...SpanFactory) Option {
 		}
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.Probabilistic < 0 || p.Probabilistic > 1 {
+		return module.InvalidOption("probabilistic %v out of range [0, 1]", p.Probabilistic)
+	}
+	if p.SlowRequest < 0 || p.SlowSpan < 0 {
+		return module.InvalidOption("slow request and slow span must not be negative")
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package linkerredis
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -8,12 +9,14 @@ import (
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	"github.com/pojol/braid-go/internal/braidsync"
 	"github.com/pojol/braid-go/internal/utils"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/discover"
 	"github.com/pojol/braid-go/module/elector"
 	"github.com/pojol/braid-go/module/linkcache"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/moduleparm"
 )
@@ -82,7 +85,7 @@ func (rc *RedisClient) Ping() (string, error) {
 }
 
 type redisLinkerBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newRedisLinker() module.IBuilder {
@@ -97,18 +100,28 @@ func (*redisLinkerBuilder) Type() module.ModuleType {
 	return module.Linkcache
 }
 
-func (rb *redisLinkerBuilder) AddModuleOption(opt interface{}) {
-	rb.opts = append(rb.opts, opt)
+func (*redisLinkerBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Pubsub},
+		{Type: module.Discover, Optional: true},
+		{Type: module.Elector, Optional: true},
+		{Type: module.Metrics, Optional: true},
+	}
 }
 
-// Build build link-cache
-func (rb *redisLinkerBuilder) Build(name string, buildOpts ...interface{}) interface{} {
-
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+func (rb *redisLinkerBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
 	}
 
+	rb.opts = append(rb.opts, o)
+	return nil
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (rb *redisLinkerBuilder) parm(name string) Parm {
 	p := Parm{
 		Mode:             LinkerRedisModeRedis,
 		SyncTick:         1000 * 10, // 10 second
@@ -119,8 +132,27 @@ func (rb *redisLinkerBuilder) Build(name string, buildOpts ...interface{}) inter
 		syncRelationTick: 5,
 	}
 	for _, opt := range rb.opts {
-		opt.(Option)(&p)
+		opt(&p)
 	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (rb *redisLinkerBuilder) Validate() error {
+	p := rb.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+// Build build link-cache
+func (rb *redisLinkerBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := rb.parm(name)
 
 	client := &RedisClient{
 		pool: &redis.Pool{
@@ -138,6 +170,11 @@ func (rb *redisLinkerBuilder) Build(name string, buildOpts ...interface{}) inter
 		},
 	}
 
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
+	}
+
 	lc := &redisLinker{
 		serviceName:   name,
 		ps:            bp.PS,
@@ -146,11 +183,14 @@ func (rb *redisLinkerBuilder) Build(name string, buildOpts ...interface{}) inter
 		client:        client,
 		parm:          p,
 		activeNodeMap: make(map[string]discover.Node),
+		done:          braidsync.NewSwitch(),
 		local: &localLinker{
 			serviceName: name,
 			tokenMap:    make(map[string]linkInfo),
 			relationSet: make(map[string]int),
 		},
+		targets: m.Counter("braid_linkcache_target_total",
+			"Number of link cache lookups, by result.", "result"),
 	}
 
 	lc.ps.RegistTopic(linkcache.TokenUnlink, pubsub.ScopeCluster)
@@ -183,6 +223,12 @@ type redisLinker struct {
 
 	activeNodeMap map[string]discover.Node
 
+	done      *braidsync.Switch
+	waitGroup braidsync.WaitGroupWrapper
+
+	health  module.HealthRecorder
+	targets metrics.ICounterVec
+
 	sync.RWMutex
 }
 
@@ -206,7 +252,11 @@ func (rl *redisLinker) Init() error {
 	})
 
 	serviceUpdate.Arrived(func(msg *pubsub.Message) {
-		dmsg := discover.DecodeUpdateMsg(msg)
+		dmsg, err := discover.DecodeUpdateMsg(msg)
+		if err != nil {
+			rl.logger.Warnf("%v decode service update message err %v", Name, err)
+			return
+		}
 		if dmsg.Event == discover.EventRemoveService {
 			rl.rmvOfflineService(dmsg.Nod)
 			rl.Down(dmsg.Nod)
@@ -227,6 +277,7 @@ func (rl *redisLinker) Init() error {
 	if err != nil {
 		return fmt.Errorf("%v Dependency check error %v [%v]", rl.serviceName, "redis", rl.parm.RedisAddr)
 	}
+	rl.health.Record(nil)
 
 	return nil
 }
@@ -268,6 +319,7 @@ func (rl *redisLinker) syncRelation() {
 	defer conn.Close()
 
 	members, err := redis.Strings(conn.Do("SMEMBERS", RelationPrefix))
+	rl.health.Record(err)
 	if err != nil {
 		return
 	}
@@ -387,25 +439,39 @@ func (rl *redisLinker) Run() {
 	*/
 
 	rl.syncRelation()
-	go func() {
+	rl.waitGroup.Wrap(func() {
 		tick := time.NewTicker(time.Second * time.Duration(rl.parm.syncRelationTick))
+		defer tick.Stop()
+
 		for {
 			select {
 			case <-tick.C:
 				rl.syncRelation()
+			case <-rl.done.Done():
+				return
 			}
 		}
-	}()
+	})
 
-	go func() {
+	rl.waitGroup.Wrap(func() {
 		tick := time.NewTicker(time.Second * time.Duration(rl.parm.syncOfflineTick))
+		defer tick.Stop()
+
 		for {
 			select {
 			case <-tick.C:
 				rl.syncOffline()
+			case <-rl.done.Done():
+				return
 			}
 		}
-	}()
+	})
+}
+
+// Shutdown 停止链路缓存的后台同步循环，并等待循环退出
+func (rl *redisLinker) Shutdown(ctx context.Context) error {
+	rl.done.Open()
+	return rl.waitGroup.WaitContext(ctx)
 }
 
 // braid_linker-linknum-gate-base-ukjna1g33rq9
@@ -427,6 +493,12 @@ func (rl *redisLinker) Target(token string, serviceName string) (string, error)
 		target, err = rl.localTarget(token, serviceName)
 	}
 
+	if target != "" {
+		rl.targets.With("hit").Inc()
+	} else {
+		rl.targets.With("miss").Inc()
+	}
+
 	return target, err
 }
 
@@ -482,11 +554,42 @@ func (rl *redisLinker) Down(target discover.Node) error {
 	return err
 }
 
-func (rl *redisLinker) Close() {
+// Introspect 配置项、从属节点以及当前活跃的节点
+func (rl *redisLinker) Introspect() interface{} {
+	rl.RLock()
+	defer rl.RUnlock()
+
+	child := append([]string{}, rl.child...)
+	active := []discover.Node{}
+	for _, nod := range rl.activeNodeMap {
+		active = append(active, nod)
+	}
 
+	return map[string]interface{}{
+		"parm":          rl.parm,
+		"elector_state": rl.electorState,
+		"child":         child,
+		"active_nodes":  active,
+	}
+}
+
+// Health 最近一次从 redis 同步链路关系的结果
+func (rl *redisLinker) Health() module.Health {
+	return rl.health.Health()
+}
+
+func (rl *redisLinker) Close() {
+	rl.done.Open()
 	rl.client.pool.Close()
 }
 
 func init() {
-	module.Register(newRedisLinker())
+	module.Register(newRedisLinker)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"redis_addr":       WithRedisAddr,
+		"redis_max_idle":   WithRedisMaxIdle,
+		"redis_max_active": WithRedisMaxActive,
+		"sync_tick":        WithSyncTick,
+		"mode":             WithMode,
+	})
 }
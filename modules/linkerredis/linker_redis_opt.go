@@ -1,5 +1,7 @@
 package linkerredis
 
+import "github.com/pojol/braid-go/module"
+
 // mode
 const (
 	LinkerRedisModeLocal = "mode_local"
@@ -59,3 +61,21 @@ func WithMode(mode string) Option {
 		c.Mode = mode
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.Mode != LinkerRedisModeRedis && p.Mode != LinkerRedisModeLocal {
+		return module.InvalidOption("unknown mode %q", p.Mode)
+	}
+	if p.RedisAddr == "" {
+		return module.InvalidOption("empty redis address")
+	}
+	if p.SyncTick <= 0 {
+		return module.InvalidOption("sync tick must be positive")
+	}
+	if p.RedisMaxIdle < 0 || p.RedisMaxActive < 0 {
+		return module.InvalidOption("redis pool size must not be negative")
+	}
+
+	return nil
+}
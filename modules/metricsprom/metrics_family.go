@@ -0,0 +1,158 @@
+package metricsprom
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pojol/braid-go/module/metrics"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// family 同一个名字的所有指标
+type family struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64
+
+	sync.RWMutex
+	children map[string]*child
+}
+
+func newFamily(name, help, typ string, buckets []float64, labelNames []string) *family {
+	return &family{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		buckets:    buckets,
+		children:   make(map[string]*child),
+	}
+}
+
+// with 获取某一组标签值的指标，不存在时创建
+func (f *family) with(labelValues []string) *child {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Errorf("metrics %v expected %d label values but got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	f.RLock()
+	c, ok := f.children[key]
+	f.RUnlock()
+	if ok {
+		return c
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if c, ok = f.children[key]; !ok {
+		c = &child{
+			labelValues: append([]string{}, labelValues...),
+			counts:      make([]uint64, len(f.buckets)),
+		}
+		f.children[key] = c
+	}
+
+	return c
+}
+
+func (f *family) sortedChildren() []*child {
+	f.RLock()
+	children := make([]*child, 0, len(f.children))
+	for _, c := range f.children {
+		children = append(children, c)
+	}
+	f.RUnlock()
+
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].labelValues, "\xff") < strings.Join(children[j].labelValues, "\xff")
+	})
+
+	return children
+}
+
+// child 一组标签值对应的指标
+type child struct {
+	labelValues []string
+
+	// counter & gauge 的值，histogram 的 sum（float64 bits
+	val uint64
+
+	// histogram 每个分桶中的数量（不累加）以及总数
+	counts []uint64
+	count  uint64
+}
+
+func addFloat(addr *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		nv := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(addr, old, nv) {
+			return
+		}
+	}
+}
+
+func (c *child) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.val))
+}
+
+type counter struct{ c *child }
+
+func (ct counter) Inc() { ct.Add(1) }
+func (ct counter) Add(v float64) {
+	if v < 0 {
+		panic("counter cannot decrease in value")
+	}
+	addFloat(&ct.c.val, v)
+}
+
+type gauge struct{ c *child }
+
+func (g gauge) Set(v float64) { atomic.StoreUint64(&g.c.val, math.Float64bits(v)) }
+func (g gauge) Add(v float64) { addFloat(&g.c.val, v) }
+
+type histogram struct {
+	c       *child
+	buckets []float64
+}
+
+func (h histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.buckets, v)
+	if idx < len(h.buckets) {
+		atomic.AddUint64(&h.c.counts[idx], 1)
+	}
+	atomic.AddUint64(&h.c.count, 1)
+	addFloat(&h.c.val, v)
+}
+
+type counterVec struct{ f *family }
+
+func (cv counterVec) With(labelValues ...string) metrics.ICounter {
+	return counter{c: cv.f.with(labelValues)}
+}
+
+type gaugeVec struct{ f *family }
+
+func (gv gaugeVec) With(labelValues ...string) metrics.IGauge {
+	return gauge{c: gv.f.with(labelValues)}
+}
+
+type histogramVec struct{ f *family }
+
+func (hv histogramVec) With(labelValues ...string) metrics.IHistogram {
+	return histogram{c: hv.f.with(labelValues), buckets: hv.f.buckets}
+}
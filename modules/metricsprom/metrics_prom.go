@@ -0,0 +1,268 @@
+// 实现文件 metricsprom 基于 prometheus 文本格式实现的 metrics
+package metricsprom
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/modules/moduleparm"
+)
+
+var (
+	// Name metrics plugin name
+	Name = "MetricsProm"
+)
+
+type promBuilder struct {
+	opts []Option
+}
+
+func newMetricsProm() module.IBuilder {
+	return &promBuilder{}
+}
+
+func (b *promBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	b.opts = append(b.opts, o)
+	return nil
+}
+
+func (b *promBuilder) Name() string {
+	return Name
+}
+
+func (b *promBuilder) Type() module.ModuleType {
+	return module.Metrics
+}
+
+func (b *promBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+	}
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (b *promBuilder) parm(name string) Parm {
+	p := Parm{
+		ListenAddr: ":14225",
+		Path:       "/metrics",
+	}
+	for _, opt := range b.opts {
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (b *promBuilder) Validate() error {
+	p := b.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (b *promBuilder) Build(serviceName string, buildOpts ...interface{}) interface{} {
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := b.parm(serviceName)
+
+	pm := &promMetrics{
+		serviceName: serviceName,
+		parm:        p,
+		logger:      bp.Logger,
+		families:    make(map[string]*family),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(p.Path, pm.Handler())
+	pm.srv = &http.Server{Handler: mux}
+
+	return pm
+}
+
+type promMetrics struct {
+	serviceName string
+	parm        Parm
+	logger      logger.ILogger
+
+	listen net.Listener
+	srv    *http.Server
+
+	sync.RWMutex
+	families map[string]*family
+}
+
+func (pm *promMetrics) Init() error {
+	lis, err := net.Listen("tcp", pm.parm.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("%v Dependency check error %v [%v]", pm.serviceName, "tcp", pm.parm.ListenAddr)
+	}
+
+	pm.listen = lis
+	return nil
+}
+
+func (pm *promMetrics) Run() {
+	go func() {
+		if err := pm.srv.Serve(pm.listen); err != nil && err != http.ErrServerClosed {
+			pm.logger.Errorf("run metrics server err %s", err.Error())
+		}
+	}()
+}
+
+// family 注册（或获取已经注册的）指标，同名但是类型不同的指标会 panic
+func (pm *promMetrics) family(name, help, typ string, buckets []float64, labelNames []string) *family {
+	pm.Lock()
+	defer pm.Unlock()
+
+	if f, ok := pm.families[name]; ok {
+		if f.typ != typ {
+			panic(fmt.Errorf("metrics %v already registered as %v", name, f.typ))
+		}
+		return f
+	}
+
+	f := newFamily(name, help, typ, buckets, labelNames)
+	pm.families[name] = f
+
+	return f
+}
+
+func (pm *promMetrics) Counter(name, help string, labelNames ...string) metrics.ICounterVec {
+	return counterVec{f: pm.family(name, help, typeCounter, nil, labelNames)}
+}
+
+func (pm *promMetrics) Gauge(name, help string, labelNames ...string) metrics.IGaugeVec {
+	return gaugeVec{f: pm.family(name, help, typeGauge, nil, labelNames)}
+}
+
+func (pm *promMetrics) Histogram(name, help string, buckets []float64, labelNames ...string) metrics.IHistogramVec {
+	if len(buckets) == 0 {
+		buckets = metrics.DefBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+
+	return histogramVec{f: pm.family(name, help, typeHistogram, buckets, labelNames)}
+}
+
+// Handler 以 prometheus 文本格式输出所有的指标
+func (pm *promMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		pm.Write(w)
+	})
+}
+
+// Write 以 prometheus 文本格式输出所有的指标，按名字排序
+func (pm *promMetrics) Write(w io.Writer) error {
+	pm.RLock()
+	families := make([]*family, 0, len(pm.families))
+	for _, f := range pm.families {
+		families = append(families, f)
+	}
+	pm.RUnlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+
+		for _, c := range f.sortedChildren() {
+			if f.typ != typeHistogram {
+				writeSample(bw, f.name, f.labelNames, c.labelValues, "", "", c.value())
+				continue
+			}
+
+			var cumulative uint64
+			for k, upper := range f.buckets {
+				cumulative += atomic.LoadUint64(&c.counts[k])
+				writeSample(bw, f.name+"_bucket", f.labelNames, c.labelValues, "le", formatFloat(upper), float64(cumulative))
+			}
+
+			count := atomic.LoadUint64(&c.count)
+			writeSample(bw, f.name+"_bucket", f.labelNames, c.labelValues, "le", "+Inf", float64(count))
+			writeSample(bw, f.name+"_sum", f.labelNames, c.labelValues, "", "", c.value())
+			writeSample(bw, f.name+"_count", f.labelNames, c.labelValues, "", "", float64(count))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeSample(w io.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, v float64) {
+	pairs := make([]string, 0, len(labelNames)+1)
+	for k, ln := range labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, ln, escapeLabel(labelValues[k])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+
+	if len(pairs) > 0 {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(v))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpReplacer.Replace(s) }
+func escapeLabel(s string) string { return labelReplacer.Replace(s) }
+
+// Shutdown 停止接收新的请求，并等待正在处理的请求完成
+func (pm *promMetrics) Shutdown(ctx context.Context) error {
+	return pm.srv.Shutdown(ctx)
+}
+
+func (pm *promMetrics) Close() {
+	pm.srv.Close()
+	if pm.listen != nil {
+		pm.listen.Close()
+	}
+}
+
+func init() {
+	module.Register(newMetricsProm)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"listen": WithListen,
+		"path":   WithPath,
+	})
+}
@@ -0,0 +1,45 @@
+package metricsprom
+
+import (
+	"strings"
+
+	"github.com/pojol/braid-go/module"
+)
+
+// Parm metrics 配置
+type Parm struct {
+	// ListenAddr metrics 服务的侦听地址
+	ListenAddr string
+
+	// Path 指标的 http 路径
+	Path string
+}
+
+// Option config wraps
+type Option func(*Parm)
+
+// WithListen metrics 服务的侦听地址配置（默认 :14225
+func WithListen(address string) Option {
+	return func(c *Parm) {
+		c.ListenAddr = address
+	}
+}
+
+// WithPath 指标的 http 路径（默认 /metrics
+func WithPath(path string) Option {
+	return func(c *Parm) {
+		c.Path = path
+	}
+}
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.ListenAddr == "" {
+		return module.InvalidOption("empty listen address")
+	}
+	if !strings.HasPrefix(p.Path, "/") {
+		return module.InvalidOption("path %q must start with /", p.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package metricsprom
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/modules/moduleparm"
+	"github.com/pojol/braid-go/modules/zaplogger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExposition(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestExposition").(logger.ILogger)
+	m := module.GetBuilder(Name).Build("TestExposition", moduleparm.WithLogger(log)).(metrics.IMetrics)
+
+	published := m.Counter("test_published_total", "Number of published messages.", "topic")
+	published.With("a").Inc()
+	published.With("a").Add(2)
+	published.With(`quote"d`).Inc()
+
+	m.Gauge("test_backlog", "Backlog of the channel.").With().Set(7)
+
+	latency := m.Histogram("test_duration_seconds", "Duration.", []float64{1, 0.1})
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(5)
+
+	// 同名的指标返回同一个实例
+	m.Counter("test_published_total", "Number of published messages.", "topic").With("a").Inc()
+
+	var sb strings.Builder
+	assert.Equal(t, m.(*promMetrics).Write(&sb), nil)
+	out := sb.String()
+
+	assert.Equal(t, strings.Contains(out, "# TYPE test_published_total counter\n"), true)
+	assert.Equal(t, strings.Contains(out, `test_published_total{topic="a"} 4`+"\n"), true)
+	assert.Equal(t, strings.Contains(out, `test_published_total{topic="quote\"d"} 1`+"\n"), true)
+	assert.Equal(t, strings.Contains(out, "test_backlog 7\n"), true)
+
+	assert.Equal(t, strings.Contains(out, `test_duration_seconds_bucket{le="0.1"} 1`+"\n"), true)
+	assert.Equal(t, strings.Contains(out, `test_duration_seconds_bucket{le="1"} 2`+"\n"), true)
+	assert.Equal(t, strings.Contains(out, `test_duration_seconds_bucket{le="+Inf"} 3`+"\n"), true)
+	assert.Equal(t, strings.Contains(out, "test_duration_seconds_sum 5.55\n"), true)
+	assert.Equal(t, strings.Contains(out, "test_duration_seconds_count 3\n"), true)
+
+	assert.Equal(t, strings.Index(out, "test_backlog") < strings.Index(out, "test_duration_seconds"), true)
+}
+
+func TestHandler(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestHandler").(logger.ILogger)
+
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithListen(":14226"))
+	m := b.Build("TestHandler", moduleparm.WithLogger(log)).(metrics.IMetrics)
+	assert.Equal(t, m.Init(), nil)
+	m.Run()
+	defer m.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	m.Counter("test_handled_total", "Number of handled requests.").With().Inc()
+
+	res, err := http.Get("http://127.0.0.1:14226/metrics")
+	assert.Equal(t, err, nil)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain"), true)
+	assert.Equal(t, strings.Contains(string(body), "test_handled_total 1\n"), true)
+}
@@ -1,9 +1,11 @@
 package moduleparm
 
 import (
+	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/balancer"
 	"github.com/pojol/braid-go/module/linkcache"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/module/tracer"
 )
@@ -16,6 +18,13 @@ type BuildParm struct {
 	Tracer    tracer.ITracer
 	Balancer  balancer.IBalancer
 	Linkcache linkcache.ILinkCache
+	Metrics   metrics.IMetrics
+
+	// Modules 依赖的所有模块（包括第三方模块
+	Modules map[module.ModuleType]interface{}
+
+	// Node 模块所在的节点
+	Node module.INode
 }
 
 type Option func(*BuildParm)
@@ -49,3 +58,59 @@ func WithLinkcache(lc linkcache.ILinkCache) Option {
 		bp.Linkcache = lc
 	}
 }
+
+func WithMetrics(m metrics.IMetrics) Option {
+	return func(bp *BuildParm) {
+		bp.Metrics = m
+	}
+}
+
+// WithNode 注入模块所在的节点
+func WithNode(node module.INode) Option {
+	return func(bp *BuildParm) {
+		bp.Node = node
+	}
+}
+
+// WithModule 注入一个依赖的模块
+//
+// 内置类型的模块会同时设置到对应的字段上，第三方模块则通过 Modules 获取
+func WithModule(ty module.ModuleType, mod interface{}) Option {
+	return func(bp *BuildParm) {
+		if bp.Modules == nil {
+			bp.Modules = make(map[module.ModuleType]interface{})
+		}
+		bp.Modules[ty] = mod
+
+		switch ty {
+		case module.Pubsub:
+			bp.PS, _ = mod.(pubsub.IPubsub)
+		case module.Logger:
+			bp.Logger, _ = mod.(logger.ILogger)
+		case module.Tracer:
+			bp.Tracer, _ = mod.(tracer.ITracer)
+		case module.Balancer:
+			bp.Balancer, _ = mod.(balancer.IBalancer)
+		case module.Linkcache:
+			bp.Linkcache, _ = mod.(linkcache.ILinkCache)
+		case module.Metrics:
+			bp.Metrics, _ = mod.(metrics.IMetrics)
+		}
+	}
+}
+
+// Parse 应用 braid 传入的构建参数
+//
+// 参数只由 braid 传入，类型不匹配时 panic，braid 会在构建阶段捕获并以 OptionError 的形式返回
+func Parse(name string, opts []interface{}) BuildParm {
+	bp := BuildParm{}
+	for _, opt := range opts {
+		o, ok := opt.(Option)
+		if !ok {
+			panic(module.MismatchOption(name, opt))
+		}
+		o(&bp)
+	}
+
+	return bp
+}
@@ -0,0 +1,415 @@
+// 实现文件 pubsubmem 基于内存实现的 pubsub，不依赖 nsq
+//
+// ScopeProc 的 topic 只在当前 pubsub 实例中可见；ScopeCluster 的 topic 位于进程内的共享总线上，
+// 同一进程中的多个 braid 实例（使用相同的总线）可以通过它互相发布和消费消息。
+// 与 nsq 一致，消息会广播给 topic 中的每个 channel，同一个 channel 中的多个消费者竞争消费。
+package pubsubmem
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pojol/braid-go/internal/timewheel"
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/pojol/braid-go/modules/moduleparm"
+)
+
+const (
+	// Name pub-sub plug-in name
+	Name = "PubsubMemory"
+
+	// WheelTick WheelSlots 延迟消息的时间轮精度以及槽数
+	WheelTick  = time.Millisecond * 10
+	WheelSlots = 512
+
+	// DelayTimeout 延迟消息到期后发布的超时时间
+	DelayTimeout = time.Second * 5
+
+	// DefaultPendingCapacity 还没有 channel 的 topic 默认最多保存的消息数
+	DefaultPendingCapacity = 4096
+)
+
+// memBus 进程内的共享总线，保存集群作用域的 topic
+type memBus struct {
+	sync.Mutex
+	topics map[string]*memTopic
+}
+
+var (
+	busLock sync.Mutex
+	buses   = make(map[string]*memBus)
+)
+
+func getBus(name string) *memBus {
+	busLock.Lock()
+	defer busLock.Unlock()
+
+	b, ok := buses[name]
+	if !ok {
+		b = &memBus{topics: make(map[string]*memTopic)}
+		buses[name] = b
+	}
+
+	return b
+}
+
+// lookup 获取总线上已经存在的 topic
+func (b *memBus) lookup(name string) (*memTopic, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	t, ok := b.topics[name]
+	return t, ok
+}
+
+func (b *memBus) topic(name string, pendingParm pubsub.ChannelParm) *memTopic {
+	b.Lock()
+	defer b.Unlock()
+
+	t, ok := b.topics[name]
+	if !ok {
+		t = newTopic(name, pubsub.ScopeCluster, pendingParm)
+		b.topics[name] = t
+	}
+
+	return t
+}
+
+type memPubsubBuilder struct {
+	opts []Option
+}
+
+func newMemPubsub() module.IBuilder {
+	return &memPubsubBuilder{}
+}
+
+func (mb *memPubsubBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	mb.opts = append(mb.opts, o)
+	return nil
+}
+
+func (mb *memPubsubBuilder) Name() string {
+	return Name
+}
+
+func (mb *memPubsubBuilder) Type() module.ModuleType {
+	return module.Pubsub
+}
+
+func (mb *memPubsubBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Metrics, Optional: true},
+	}
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (mb *memPubsubBuilder) parm() Parm {
+	p := Parm{
+		Bus:             "default",
+		Retry:           pubsub.DefaultRetry,
+		PendingCapacity: DefaultPendingCapacity,
+		PendingOverflow: pubsub.OverflowDropNewest,
+	}
+	for _, opt := range mb.opts {
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (mb *memPubsubBuilder) Validate() error {
+	p := mb.parm()
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (mb *memPubsubBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := mb.parm()
+	if err := p.Validate(); err != nil {
+		panic(&module.OptionError{Module: Name, Err: err})
+	}
+
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
+	}
+
+	node, _ := os.Hostname()
+
+	return &memPubsub{
+		serviceName: name,
+		node:        node,
+		parm:        p,
+		log:         bp.Logger,
+		bus:         getBus(p.Bus),
+		topicMap:    make(map[string]*topicView),
+		delayer:     timewheel.NewDelayer(WheelTick, WheelSlots, DelayTimeout),
+
+		published: m.Counter("braid_pubsub_published_total", "Number of messages published to the topic.", "topic"),
+		consumed:  m.Counter("braid_pubsub_consumed_total", "Number of messages consumed by the channel.", "topic", "channel"),
+		backlog:   m.Gauge("braid_pubsub_backlog", "Number of messages waiting in the channel.", "topic", "channel"),
+		dropped:   m.Counter("braid_pubsub_dropped_total", "Number of messages dropped by the channel because of overflow.", "topic", "channel"),
+	}
+}
+
+type memPubsub struct {
+	// serviceName node 发布消息时填充的服务名和节点名
+	serviceName string
+	node        string
+
+	parm Parm
+	log  logger.ILogger
+	bus  *memBus
+
+	published metrics.ICounterVec
+	consumed  metrics.ICounterVec
+	backlog   metrics.IGaugeVec
+	dropped   metrics.ICounterVec
+
+	// handlers 本实例添加的所有消费者
+	handlers sync.WaitGroup
+
+	sync.RWMutex
+	topicMap map[string]*topicView
+
+	// delayer 延迟消息的调度，在第一次发送延迟消息时创建时间轮
+	delayer *timewheel.Delayer
+}
+
+func (mp *memPubsub) Init() error {
+	return nil
+}
+
+func (mp *memPubsub) Run() {
+
+}
+
+func (mp *memPubsub) RegistTopic(name string, scope pubsub.ScopeTy) (pubsub.ITopic, error) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	if tv, ok := mp.topicMap[name]; ok {
+		return tv, nil
+	}
+
+	var t *memTopic
+	if scope == pubsub.ScopeCluster {
+		t = mp.bus.topic(name, mp.parm.pendingParm())
+	} else {
+		t = newTopic(name, scope, mp.parm.pendingParm())
+	}
+
+	tv := &topicView{
+		t:           t,
+		ps:          mp,
+		published:   mp.published.With(name),
+		stop:        make(chan struct{}),
+		channelDead: make(map[string]string),
+	}
+	mp.topicMap[name] = tv
+	mp.log.Infof("Topic %v created", name)
+
+	return tv, nil
+}
+
+func (mp *memPubsub) GetTopic(name string) pubsub.ITopic {
+	mp.RLock()
+	tv, ok := mp.topicMap[name]
+	mp.RUnlock()
+	if ok {
+		return tv
+	}
+
+	nt, err := mp.RegistTopic(name, pubsub.ScopeProc)
+	if err != nil {
+		panic(err)
+	}
+	mp.log.Warnf("Get topic warning %v undefined! register proc topic", name)
+
+	return nt
+}
+
+// RemoveTopic 删除 topic
+//
+// 集群作用域的 topic 依旧保留在总线上（其他实例可能还在使用），这里只停止本实例在 topic 上的消费者
+func (mp *memPubsub) RemoveTopic(name string) error {
+	mp.Lock()
+	tv, ok := mp.topicMap[name]
+	delete(mp.topicMap, name)
+	mp.Unlock()
+
+	if !ok {
+		return fmt.Errorf("topic %v dose not exist", name)
+	}
+
+	mp.log.Infof("deleting topic %v", name)
+	tv.close()
+	if tv.t.scope != pubsub.ScopeCluster {
+		tv.t.exit()
+	}
+
+	return nil
+}
+
+// Publish 向 topic 发布消息，集群作用域的 topic 只需要存在于总线上（不会在本实例中注册
+func (mp *memPubsub) Publish(ctx context.Context, name string, scope pubsub.ScopeTy, msg *pubsub.Message) error {
+	mp.RLock()
+	tv, ok := mp.topicMap[name]
+	mp.RUnlock()
+	if ok {
+		return tv.PubContext(ctx, msg)
+	}
+
+	var t *memTopic
+	if scope == pubsub.ScopeCluster {
+		t, ok = mp.bus.lookup(name)
+	}
+	if !ok {
+		return fmt.Errorf("topic %v does not exist", name)
+	}
+
+	pubsub.Stamp(msg, mp.serviceName, mp.node)
+	if err := t.pub(ctx, msg); err != nil {
+		return err
+	}
+
+	t.rec.Published(1)
+	return nil
+}
+
+func (mp *memPubsub) views() []*topicView {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	views := make([]*topicView, 0, len(mp.topicMap))
+	for _, tv := range mp.topicMap {
+		views = append(views, tv)
+	}
+
+	return views
+}
+
+func (mp *memPubsub) Stats() []pubsub.TopicStats {
+	views := mp.views()
+	sort.Slice(views, func(i, j int) bool { return views[i].t.Name < views[j].t.Name })
+
+	stats := make([]pubsub.TopicStats, 0, len(views))
+	for _, tv := range views {
+		stats = append(stats, tv.Stats())
+	}
+
+	return stats
+}
+
+// Shutdown 等待 topic 中的消息投递完毕，随后停止本实例的所有消费者
+func (mp *memPubsub) Shutdown(ctx context.Context) error {
+	tick := time.NewTicker(time.Millisecond * 10)
+	defer tick.Stop()
+
+	var err error
+	for _, tv := range mp.views() {
+		for err == nil && !tv.t.drained() {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				err = fmt.Errorf("topic %v flush %w", tv.t.Name, ctx.Err())
+			}
+		}
+	}
+
+	mp.Close()
+
+	done := make(chan struct{})
+	go func() {
+		mp.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("wait handlers %w", ctx.Err())
+		}
+	}
+
+	return err
+}
+
+// Introspect 配置项，以及所有 topic 和 channel 中积压的消息数
+func (mp *memPubsub) Introspect() interface{} {
+	views := mp.views()
+	sort.Slice(views, func(i, j int) bool { return views[i].t.Name < views[j].t.Name })
+
+	tinfos := []map[string]interface{}{}
+	for _, tv := range views {
+		tv.t.RLock()
+		cinfos := []map[string]interface{}{}
+		for _, c := range tv.t.channelMap {
+			cinfos = append(cinfos, map[string]interface{}{
+				"name":     c.Name,
+				"backlog":  c.backlog(),
+				"capacity": c.queue.Capacity(),
+				"handlers": atomic.LoadInt32(&c.handlers),
+			})
+		}
+		pending := 0
+		if tv.t.pending != nil {
+			pending = tv.t.pending.Len()
+		}
+		tv.t.RUnlock()
+
+		sort.Slice(cinfos, func(i, j int) bool { return cinfos[i]["name"].(string) < cinfos[j]["name"].(string) })
+		tinfos = append(tinfos, map[string]interface{}{
+			"name":     tv.t.Name,
+			"scope":    tv.t.scope,
+			"backlog":  pending,
+			"channels": cinfos,
+		})
+	}
+
+	return map[string]interface{}{
+		"parm":   mp.parm,
+		"topics": tinfos,
+	}
+}
+
+// Close 停止本实例的所有消费者，进程作用域的 topic 随之删除
+func (mp *memPubsub) Close() {
+	mp.delayer.Stop()
+
+	for _, tv := range mp.views() {
+		tv.close()
+		if tv.t.scope != pubsub.ScopeCluster {
+			tv.t.exit()
+		}
+	}
+}
+
+func init() {
+	module.Register(newMemPubsub)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"bus":     WithBus,
+		"retry":   WithRetry,
+		"pending": WithPending,
+	})
+}
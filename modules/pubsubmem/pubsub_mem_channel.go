@@ -0,0 +1,222 @@
+package pubsubmem
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pojol/braid-go/internal/buffer"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// memChannel 信道，同一个 channel 中的多个消费者竞争消费其中的消息
+type memChannel struct {
+	Name      string
+	TopicName string
+
+	queue   *buffer.MsgQueue
+	dropped metrics.ICounter
+	rec     pubsub.ChannelRecorder
+
+	// parm Sub 时传入的 channel 配置
+	parm pubsub.ChannelParm
+
+	// partitions 带有分区键的消息，popLock 保证消息按照出队的顺序进入分区
+	partitions *buffer.Partitions
+	popLock    sync.Mutex
+
+	// exitLock 保证 channel 退出之后不会再添加消费者，waitGroup 等待所有消费者退出
+	exitLock  sync.Mutex
+	exitChan  chan struct{}
+	exitFlag  int32
+	waitGroup sync.WaitGroup
+
+	// handlers 消费者的数量，inflight 正在被处理的消息数，retrying 等待重新投递的消息数
+	handlers int32
+	inflight int32
+	retrying int32
+}
+
+func newChannel(topicName, channelName string, cp pubsub.ChannelParm, queue *buffer.MsgQueue, dropped metrics.ICounter) *memChannel {
+	return &memChannel{
+		Name:      channelName,
+		TopicName: topicName,
+		parm:      cp,
+		queue:     queue,
+		dropped:   dropped,
+		exitChan:  make(chan struct{}),
+
+		partitions: buffer.NewPartitions(),
+	}
+}
+
+// put 将消息放入 channel 的队列，只有 ctx 结束时返回错误（其他失败计入丢弃的消息
+func (c *memChannel) put(ctx context.Context, msg *pubsub.Message) error {
+	if atomic.LoadInt32(&c.exitFlag) == 1 {
+		return nil
+	}
+
+	dropped, err := c.queue.PutContext(ctx, msg)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	if err != nil || dropped != nil {
+		c.dropped.Inc()
+		c.rec.Dropped(1)
+	}
+
+	return nil
+}
+
+// pop 取出一条消息，带有分区键的消息在分区已经有处理者时排在分区中（返回 owner 为 false
+func (c *memChannel) pop() (msg *pubsub.Message, owner bool, ok bool) {
+	c.popLock.Lock()
+	defer c.popLock.Unlock()
+
+	msg, ok = c.queue.Pop()
+	if !ok {
+		return nil, false, false
+	}
+
+	if key := msg.PartitionKey(); key != "" && !c.partitions.Enter(key, msg) {
+		return msg, false, true
+	}
+
+	atomic.AddInt32(&c.inflight, 1)
+	return msg, true, true
+}
+
+// retry 在 delay 之后将消息重新放回 channel
+func (c *memChannel) retry(msg *pubsub.Message, delay time.Duration) {
+	atomic.AddInt32(&c.retrying, 1)
+	time.AfterFunc(delay, func() {
+		c.put(context.Background(), msg)
+		atomic.AddInt32(&c.retrying, -1)
+	})
+}
+
+// wait 等待 delay（带有分区键的消息在原地重试），channel 退出或者 stop 关闭时返回 false
+func (c *memChannel) wait(delay time.Duration, stop <-chan struct{}) bool {
+	atomic.AddInt32(&c.retrying, 1)
+	defer atomic.AddInt32(&c.retrying, -1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.exitChan:
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// backlog 积压的消息数（包括在分区中排队的消息
+func (c *memChannel) backlog() int {
+	return c.queue.Len() + c.partitions.Len()
+}
+
+// drained channel 中积压的消息是否都已经被消费（没有消费者的 channel 视为已消费
+func (c *memChannel) drained() bool {
+	if atomic.LoadInt32(&c.handlers) == 0 {
+		return true
+	}
+
+	return c.backlog() == 0 && atomic.LoadInt32(&c.inflight) == 0 && atomic.LoadInt32(&c.retrying) == 0
+}
+
+// consume 添加一个消费者，直到 channel 被删除、stop 被关闭或者取消订阅
+//
+// 没有分区键的消息交给 handler；获得分区的消费者通过 ordered 依次处理分区中所有排队的消息（即使已经取消订阅），
+// channel 退出时丢弃分区中的消息
+func (c *memChannel) consume(handler pubsub.Handler, ordered pubsub.Handler, sub *pubsub.Subscription, stop <-chan struct{}, done func()) {
+	c.exitLock.Lock()
+	if atomic.LoadInt32(&c.exitFlag) == 1 {
+		c.exitLock.Unlock()
+		done()
+		return
+	}
+	atomic.AddInt32(&c.handlers, 1)
+	c.waitGroup.Add(1)
+	c.exitLock.Unlock()
+
+	sub.Go(func() {
+		defer func() {
+			atomic.AddInt32(&c.handlers, -1)
+			c.waitGroup.Done()
+			done()
+		}()
+
+		for {
+			msg, owner, ok := c.pop()
+			if !ok {
+				select {
+				case <-c.queue.Notify():
+					continue
+				case <-c.exitChan:
+					return
+				case <-stop:
+					return
+				case <-sub.Done():
+					return
+				}
+			}
+
+			if !owner {
+				continue
+			}
+
+			key := msg.PartitionKey()
+			if key == "" {
+				handler(msg)
+				atomic.AddInt32(&c.inflight, -1)
+				continue
+			}
+
+			for {
+				ordered(msg)
+				atomic.AddInt32(&c.inflight, -1)
+
+				if atomic.LoadInt32(&c.exitFlag) == 1 {
+					return
+				}
+
+				next, has := c.partitions.Next(key)
+				if !has {
+					break
+				}
+				msg = next.(*pubsub.Message)
+				atomic.AddInt32(&c.inflight, 1)
+			}
+		}
+	})
+}
+
+// stats channel 的统计数据
+func (c *memChannel) stats() pubsub.ChannelStats {
+	s := pubsub.ChannelStats{
+		Name:     c.Name,
+		Handlers: int(atomic.LoadInt32(&c.handlers)),
+		Backlog:  c.backlog(),
+		InFlight: int(atomic.LoadInt32(&c.inflight)),
+	}
+	c.rec.Fill(&s)
+
+	return s
+}
+
+// exit 停止 channel，并等待所有的消费者退出（不要在 channel 的句柄中删除自己所在的 channel
+func (c *memChannel) exit() {
+	c.exitLock.Lock()
+	if atomic.CompareAndSwapInt32(&c.exitFlag, 0, 1) {
+		close(c.exitChan)
+		c.queue.Close()
+	}
+	c.exitLock.Unlock()
+
+	c.waitGroup.Wait()
+}
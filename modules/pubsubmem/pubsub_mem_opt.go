@@ -0,0 +1,80 @@
+package pubsubmem
+
+import (
+	"time"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// Parm 进程内 pubsub 配置
+type Parm struct {
+	// Bus ScopeCluster 的 topic 所在的总线名
+	//
+	// 同一进程中使用相同总线的 pubsub 共享集群作用域的 topic 和 channel
+	Bus string
+
+	// Retry AckHandler 处理失败后的重试策略
+	Retry pubsub.Retry
+
+	// PendingCapacity PendingOverflow 还没有 channel 的 topic 最多保存的消息数以及超出时的策略，
+	// 消息会投递给第一个创建的 channel
+	PendingCapacity int
+	PendingOverflow pubsub.OverflowPolicy
+}
+
+// Option config wraps
+type Option func(*Parm)
+
+// WithBus 集群作用域所使用的总线名（默认 default，可以用于在同一进程中模拟多个互相隔离的集群
+func WithBus(bus string) Option {
+	return func(c *Parm) {
+		c.Bus = bus
+	}
+}
+
+// WithRetry 消息处理失败后的重试策略（默认最多投递 5 次，退避 1s 起翻倍，最长 1m，maxBackoff 为 0 时不限制
+func WithRetry(maxAttempts int, backoff time.Duration, maxBackoff time.Duration) Option {
+	return func(c *Parm) {
+		c.Retry = pubsub.Retry{
+			MaxAttempts: maxAttempts,
+			Backoff:     backoff,
+			MaxBackoff:  maxBackoff,
+		}
+	}
+}
+
+// WithPending 还没有 channel 的 topic 最多保存的消息数以及超出时的策略（默认 4096，丢弃新的消息并返回错误；
+// OverflowBlock 会阻塞发布者直到第一个 channel 被创建或者 ctx 结束
+func WithPending(capacity int, overflow pubsub.OverflowPolicy) Option {
+	return func(c *Parm) {
+		c.PendingCapacity = capacity
+		c.PendingOverflow = overflow
+	}
+}
+
+// pendingParm 保存还没有 channel 时发布的消息的队列配置
+func (p *Parm) pendingParm() pubsub.ChannelParm {
+	return pubsub.ChannelParm{
+		Capacity: p.PendingCapacity,
+		Overflow: p.PendingOverflow,
+	}
+}
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.Bus == "" {
+		return module.InvalidOption("empty bus name")
+	}
+	if p.PendingCapacity <= 0 {
+		return module.InvalidOption("pending capacity must be positive")
+	}
+	if p.PendingOverflow == pubsub.OverflowSpill {
+		return module.InvalidOption("pending overflow does not support spill")
+	}
+	if err := p.Retry.Validate(); err != nil {
+		return module.InvalidOption("retry %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,845 @@
+package pubsubmem
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pojol/braid-go/internal/buffer"
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/pojol/braid-go/modules/moduleparm"
+	"github.com/pojol/braid-go/modules/zaplogger"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPubsub(name string, opts ...interface{}) pubsub.IPubsub {
+	log := module.GetBuilder(zaplogger.Name).Build(name).(logger.ILogger)
+
+	b := module.GetBuilder(Name)
+	for _, opt := range opts {
+		b.AddModuleOption(opt)
+	}
+
+	return b.Build(name, moduleparm.WithLogger(log)).(pubsub.IPubsub)
+}
+
+func wait(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+func TestBroadcast(t *testing.T) {
+	ps := newPubsub("TestBroadcast")
+	defer ps.(module.IModule).Close()
+
+	var c1, c2 uint64
+
+	topic, _ := ps.RegistTopic("TestBroadcast", pubsub.ScopeProc)
+	topic.Sub("channel_1").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&c1, 1) })
+	topic.Sub("channel_2").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&c2, 1) })
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, topic.Pub(&pubsub.Message{Body: []byte("msg")}), nil)
+	}
+
+	assert.Equal(t, wait(func() bool {
+		return atomic.LoadUint64(&c1) == 10 && atomic.LoadUint64(&c2) == 10
+	}), true)
+}
+
+func TestMetadata(t *testing.T) {
+	ps := newPubsub("TestMetadata")
+	defer ps.(module.IModule).Close()
+
+	arrived := make(chan *pubsub.Message, 1)
+
+	topic, _ := ps.RegistTopic("TestMetadata", pubsub.ScopeCluster)
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) { arrived <- msg })
+
+	topic.Pub(pubsub.NewMessage([]byte("msg")).SetHeader("trace", "abc"))
+
+	select {
+	case msg := <-arrived:
+		assert.Equal(t, msg.ID != "", true)
+		assert.Equal(t, msg.Timestamp != 0, true)
+		assert.Equal(t, msg.Source, "TestMetadata")
+		assert.Equal(t, msg.Header("trace"), "abc")
+	case <-time.After(time.Second):
+		t.Fatal("message not arrived")
+	}
+}
+
+func TestCompeting(t *testing.T) {
+	ps := newPubsub("TestCompeting")
+	defer ps.(module.IModule).Close()
+
+	var c1, c2 uint64
+
+	topic, _ := ps.RegistTopic("TestCompeting", pubsub.ScopeProc)
+
+	// 在 channel 创建之前发布的消息会投递给第一个 channel
+	topic.Pub(&pubsub.Message{Body: []byte("early")})
+
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) {
+		atomic.AddUint64(&c1, 1)
+		time.Sleep(time.Millisecond)
+	})
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) {
+		atomic.AddUint64(&c2, 1)
+		time.Sleep(time.Millisecond)
+	})
+
+	for i := 0; i < 99; i++ {
+		topic.Pub(&pubsub.Message{Body: []byte("msg")})
+	}
+
+	assert.Equal(t, wait(func() bool {
+		return atomic.LoadUint64(&c1)+atomic.LoadUint64(&c2) == 100
+	}), true)
+	assert.Equal(t, atomic.LoadUint64(&c1) > 0, true)
+	assert.Equal(t, atomic.LoadUint64(&c2) > 0, true)
+}
+
+func TestClusterBus(t *testing.T) {
+	ps1 := newPubsub("TestClusterBus_1", WithBus("TestClusterBus"))
+	ps2 := newPubsub("TestClusterBus_2", WithBus("TestClusterBus"))
+	isolated := newPubsub("TestClusterBus_3", WithBus("TestClusterBus_isolated"))
+	defer ps2.(module.IModule).Close()
+	defer isolated.(module.IModule).Close()
+
+	var shared, proc, other uint64
+
+	t1, _ := ps1.RegistTopic("TestClusterBus", pubsub.ScopeCluster)
+	t2, _ := ps2.RegistTopic("TestClusterBus", pubsub.ScopeCluster)
+	t3, _ := isolated.RegistTopic("TestClusterBus", pubsub.ScopeCluster)
+
+	t1.Sub("shared").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&shared, 1) })
+	t2.Sub("shared").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&shared, 1) })
+	t2.Sub("node_2").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&proc, 1) })
+	t3.Sub("shared").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&other, 1) })
+
+	for i := 0; i < 10; i++ {
+		t1.Pub(&pubsub.Message{Body: []byte("msg")})
+	}
+
+	assert.Equal(t, wait(func() bool {
+		return atomic.LoadUint64(&shared) == 10 && atomic.LoadUint64(&proc) == 10
+	}), true)
+	assert.Equal(t, atomic.LoadUint64(&other), uint64(0))
+
+	// 关闭 ps1 后，ps2 中的消费者依旧可以收到 ps1 之前的 topic 上的消息
+	ps1.(module.IModule).Close()
+	assert.Equal(t, t1.Pub(&pubsub.Message{Body: []byte("msg")}) != nil, true)
+
+	t2.Pub(&pubsub.Message{Body: []byte("msg")})
+	assert.Equal(t, wait(func() bool { return atomic.LoadUint64(&shared) == 11 }), true)
+}
+
+func TestRemove(t *testing.T) {
+	ps := newPubsub("TestRemove")
+
+	var tick uint64
+
+	topic, _ := ps.RegistTopic("TestRemove", pubsub.ScopeProc)
+	topic.Sub("channel_1").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&tick, 1) })
+	topic.Sub("channel_2").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&tick, 1) })
+
+	assert.Equal(t, topic.RemoveChannel("channel_1"), nil)
+	assert.Equal(t, topic.RemoveChannel("channel_1") != nil, true)
+
+	topic.Pub(&pubsub.Message{Body: []byte("msg")})
+	assert.Equal(t, wait(func() bool { return atomic.LoadUint64(&tick) == 1 }), true)
+
+	assert.Equal(t, ps.RemoveTopic("TestRemove"), nil)
+	assert.Equal(t, topic.Pub(&pubsub.Message{Body: []byte("msg")}) != nil, true)
+}
+
+func TestShutdownFlush(t *testing.T) {
+	ps := newPubsub("TestShutdownFlush")
+
+	var tick uint64
+
+	topic, _ := ps.RegistTopic("TestShutdownFlush", pubsub.ScopeProc)
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) {
+		time.Sleep(time.Millisecond)
+		atomic.AddUint64(&tick, 1)
+	})
+
+	for i := 0; i < 50; i++ {
+		topic.Pub(&pubsub.Message{Body: []byte("msg")})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := ps.(module.IShutdown).Shutdown(ctx)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, atomic.LoadUint64(&tick), uint64(50))
+}
+
+func TestRetry(t *testing.T) {
+	ps := newPubsub("TestRetry", WithRetry(3, time.Millisecond*10, time.Millisecond*50))
+	defer ps.(module.IModule).Close()
+
+	var ok, failed, requeued int32
+	topic, _ := ps.RegistTopic("TestRetry", pubsub.ScopeProc)
+
+	// 前两次失败，第三次成功
+	topic.Sub("success").ArrivedAck(func(msg *pubsub.Message) error {
+		if msg.Attempts < 3 {
+			return errors.New("not yet")
+		}
+		atomic.AddInt32(&ok, 1)
+		return nil
+	})
+	// 超过最大投递次数后丢弃
+	topic.Sub("failed").ArrivedAck(func(msg *pubsub.Message) error {
+		atomic.AddInt32(&failed, 1)
+		return errors.New("always")
+	})
+	// 显式指定重新投递的延迟
+	topic.Sub("requeue").ArrivedAck(func(msg *pubsub.Message) error {
+		if atomic.AddInt32(&requeued, 1) == 1 {
+			return pubsub.Requeue(time.Millisecond)
+		}
+		return nil
+	})
+
+	topic.Pub(pubsub.NewMessage([]byte("msg")))
+
+	assert.Equal(t, wait(func() bool {
+		return atomic.LoadInt32(&ok) == 1 && atomic.LoadInt32(&failed) == 3 && atomic.LoadInt32(&requeued) == 2
+	}), true)
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, atomic.LoadInt32(&failed), int32(3))
+}
+
+func TestDeadLetter(t *testing.T) {
+	ps := newPubsub("TestDeadLetter", WithRetry(2, time.Millisecond, time.Millisecond))
+	defer ps.(module.IModule).Close()
+
+	var fixed int32
+	var handled int32
+
+	topic, _ := ps.RegistTopic("TestDeadLetter", pubsub.ScopeCluster)
+	assert.Equal(t, topic.DeadLetter("TestDeadLetter-dlq"), nil)
+
+	topic.Sub("channel").ArrivedAck(func(msg *pubsub.Message) error {
+		if atomic.LoadInt32(&fixed) == 0 {
+			return errors.New("bug")
+		}
+		assert.Equal(t, msg.Header(pubsub.HeaderDeadTopic), "")
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+
+	topic.Pub(pubsub.NewMessage([]byte("msg")))
+
+	dlq := ps.GetTopic("TestDeadLetter-dlq")
+	var dead *pubsub.Message
+	assert.Equal(t, wait(func() bool {
+		dt := dlq.(*topicView).t
+		dt.RLock()
+		defer dt.RUnlock()
+
+		c := dt.channelMap[pubsub.DeadLetterChannel]
+		if c == nil {
+			return false
+		}
+
+		msg, ok := c.queue.Pop()
+		if !ok {
+			return false
+		}
+		dead = msg
+		c.queue.Put(msg)
+		return true
+	}), true)
+	assert.Equal(t, dead.Header(pubsub.HeaderDeadTopic), "TestDeadLetter")
+	assert.Equal(t, dead.Header(pubsub.HeaderDeadChannel), "channel")
+	assert.Equal(t, dead.Header(pubsub.HeaderDeadAttempts), "2")
+	assert.Equal(t, dead.Header(pubsub.HeaderDeadError), "bug")
+
+	// 修复之后回放
+	atomic.StoreInt32(&fixed, 1)
+	replayed, err := pubsub.Replay(context.Background(), ps, "TestDeadLetter-dlq", pubsub.ScopeCluster)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, replayed, 1)
+
+	assert.Equal(t, wait(func() bool {
+		return atomic.LoadInt32(&handled) == 1
+	}), true)
+
+	// 回放结束之后的死信消息保留在死信 topic 中
+	atomic.StoreInt32(&fixed, 0)
+	topic.Pub(pubsub.NewMessage([]byte("msg")))
+
+	backlog := func() int { return dlq.Stats().Channels[0].Backlog }
+	assert.Equal(t, wait(func() bool { return backlog() == 1 }), true)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, backlog(), 1)
+	assert.Equal(t, atomic.LoadInt32(&handled), int32(1))
+}
+
+func TestOverflow(t *testing.T) {
+	ps := newPubsub("TestOverflow")
+	defer ps.(module.IModule).Close()
+
+	var warned int32
+	topic, _ := ps.RegistTopic("TestOverflow", pubsub.ScopeProc)
+	channel := topic.Sub("channel",
+		pubsub.WithCapacity(2, pubsub.OverflowDropOldest),
+		pubsub.WithBacklogWarning(2, func(topic string, channel string, backlog int) {
+			atomic.AddInt32(&warned, 1)
+		}),
+	)
+
+	for i := 0; i < 5; i++ {
+		topic.Pub(pubsub.NewMessage([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, atomic.LoadInt32(&warned), int32(1))
+
+	recv := make(chan string, 5)
+	channel.Arrived(func(msg *pubsub.Message) { recv <- string(msg.Body) })
+
+	assert.Equal(t, <-recv, "3")
+	assert.Equal(t, <-recv, "4")
+}
+
+func TestPubContext(t *testing.T) {
+	ps := newPubsub("TestPubContext")
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestPubContext", pubsub.ScopeProc)
+	recv := make(chan string, 1)
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) { recv <- string(msg.Body) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, topic.PubContext(ctx, pubsub.NewMessage([]byte("canceled"))), context.Canceled)
+
+	done := make(chan error, 1)
+	topic.PubAsync(context.Background(), pubsub.NewMessage([]byte("async")), func(err error) {
+		done <- err
+	})
+
+	assert.Equal(t, <-done, nil)
+	assert.Equal(t, <-recv, "async")
+}
+
+func TestPubDelay(t *testing.T) {
+	ps := newPubsub("TestPubDelay")
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestPubDelay", pubsub.ScopeProc)
+	recv := make(chan string, 2)
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) { recv <- string(msg.Body) })
+
+	begin := time.Now()
+	assert.Equal(t, topic.PubDelay(context.Background(), pubsub.NewMessage([]byte("delay")), time.Millisecond*50), nil)
+	assert.Equal(t, topic.PubDelay(context.Background(), pubsub.NewMessage([]byte("now")), 0), nil)
+
+	assert.Equal(t, <-recv, "now")
+	assert.Equal(t, <-recv, "delay")
+	assert.Equal(t, time.Since(begin) >= time.Millisecond*40, true)
+}
+
+func TestPubBatch(t *testing.T) {
+	ps := newPubsub("TestPubBatch")
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestPubBatch", pubsub.ScopeProc)
+	recv := make(chan string, 3)
+	topic.Sub("channel").Arrived(func(msg *pubsub.Message) { recv <- string(msg.Body) })
+
+	msgs := []*pubsub.Message{
+		pubsub.NewMessage([]byte("1")),
+		pubsub.NewMessage([]byte("2")),
+		pubsub.NewMessage([]byte("3")),
+	}
+	assert.Equal(t, topic.PubBatch(context.Background(), msgs), nil)
+
+	for _, expect := range []string{"1", "2", "3"} {
+		assert.Equal(t, <-recv, expect)
+	}
+}
+
+type typedMsg struct {
+	Name string
+}
+
+func TestTypedTopic(t *testing.T) {
+	ps := newPubsub("TestTypedTopic")
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestTypedTopic", pubsub.ScopeProc)
+
+	decodeErr := make(chan error, 1)
+	tt := pubsub.NewTypedTopic(topic,
+		pubsub.WithContentType(pubsub.ContentTypeMsgpack),
+		pubsub.WithDecodeError(func(msg *pubsub.Message, err error) { decodeErr <- err }),
+	)
+
+	recv := make(chan *typedMsg, 1)
+	tt.Sub("channel", typedMsg{}, func(msg *pubsub.Message, v interface{}) error {
+		assert.Equal(t, msg.ContentType(), pubsub.ContentTypeMsgpack)
+		recv <- v.(*typedMsg)
+		return nil
+	})
+
+	assert.Equal(t, tt.Pub(&typedMsg{Name: "braid"}), nil)
+	assert.Equal(t, (<-recv).Name, "braid")
+
+	// 无法解码的消息交给回调，不会产生零值的结构
+	bad := pubsub.NewMessage([]byte{0xc1}).SetHeader(pubsub.HeaderContentType, pubsub.ContentTypeMsgpack)
+	assert.Equal(t, topic.Pub(bad), nil)
+
+	var derr *pubsub.DecodeError
+	assert.Equal(t, errors.As(<-decodeErr, &derr), true)
+
+	select {
+	case <-recv:
+		t.Fatal("received undecodable message")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	ps := newPubsub("TestUnsubscribe")
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestUnsubscribe", pubsub.ScopeProc)
+	channel := topic.Sub("channel")
+
+	var first, second uint64
+	sub := channel.Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&first, 1) })
+	channel.Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&second, 1) })
+
+	assert.Equal(t, sub.Unsubscribe(), nil)
+	before := atomic.LoadUint64(&first)
+
+	for i := 0; i < 10; i++ {
+		topic.Pub(pubsub.NewMessage([]byte(strconv.Itoa(i))))
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	assert.Equal(t, atomic.LoadUint64(&first), before)
+	assert.Equal(t, atomic.LoadUint64(&second), uint64(10))
+}
+
+func TestHandlerPanic(t *testing.T) {
+	ps := newPubsub("TestHandlerPanic", WithRetry(2, time.Millisecond*10, time.Millisecond*10))
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestHandlerPanic", pubsub.ScopeProc)
+
+	reported := make(chan error, 2)
+	topic.Sub("channel").ArrivedAck(func(msg *pubsub.Message) error {
+		if msg.Attempts == 1 {
+			panic("boom")
+		}
+		return nil
+	}, pubsub.WithErrorHandler(func(msg *pubsub.Message, err error) { reported <- err }))
+
+	topic.Pub(pubsub.NewMessage([]byte("panic")))
+
+	var perr *pubsub.PanicError
+	assert.Equal(t, errors.As(<-reported, &perr), true)
+	assert.Equal(t, perr.Value, "boom")
+
+	// panic 的消息被重新投递，第二次处理成功
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, len(reported), 0)
+}
+
+func TestConcurrency(t *testing.T) {
+	ps := newPubsub("TestConcurrency")
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestConcurrency", pubsub.ScopeProc)
+
+	var running, peak int32
+	release := make(chan struct{})
+	sub := topic.Sub("channel").Arrived(func(msg *pubsub.Message) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+	}, pubsub.WithConcurrency(4))
+
+	for i := 0; i < 8; i++ {
+		topic.Pub(pubsub.NewMessage([]byte(strconv.Itoa(i))))
+	}
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, atomic.LoadInt32(&peak), int32(4))
+
+	close(release)
+	assert.Equal(t, sub.Unsubscribe(), nil)
+	assert.Equal(t, atomic.LoadInt32(&running), int32(0))
+}
+
+func TestStats(t *testing.T) {
+	ps := newPubsub("TestStats", WithRetry(1, time.Millisecond, time.Millisecond))
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestStats", pubsub.ScopeProc)
+	topic.Sub("full", pubsub.WithCapacity(1, pubsub.OverflowDropNewest))
+
+	done := make(chan struct{}, 3)
+	topic.Sub("handled").ArrivedAck(func(msg *pubsub.Message) error {
+		defer func() { done <- struct{}{} }()
+		if string(msg.Body) == "fail" {
+			return errors.New("fail")
+		}
+		return nil
+	})
+
+	topic.Pub(pubsub.NewMessage([]byte("1")))
+	topic.Pub(pubsub.NewMessage([]byte("2")))
+	topic.Pub(pubsub.NewMessage([]byte("fail")))
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	stats := ps.Stats()
+	assert.Equal(t, len(stats), 1)
+	assert.Equal(t, stats[0].Name, "TestStats")
+	assert.Equal(t, stats[0].Published, uint64(3))
+	assert.Equal(t, stats[0].PublishRate > 0, true)
+
+	full, handled := stats[0].Channels[0], stats[0].Channels[1]
+	assert.Equal(t, full.Name, "full")
+	assert.Equal(t, full.Backlog, 1)
+	assert.Equal(t, full.Dropped, uint64(2))
+
+	assert.Equal(t, handled.Handlers, 1)
+	assert.Equal(t, handled.Handled, uint64(3))
+	assert.Equal(t, handled.Failed, uint64(1))
+	assert.Equal(t, handled.Latency.Max >= handled.Latency.Mean, true)
+}
+
+func TestRequest(t *testing.T) {
+	for _, scope := range []pubsub.ScopeTy{pubsub.ScopeProc, pubsub.ScopeCluster} {
+		ps := newPubsub("TestRequest")
+
+		_, err := pubsub.Respond(ps, "TestRequest", scope, "responder", func(req *pubsub.Message) (*pubsub.Message, error) {
+			switch string(req.Body) {
+			case "fail":
+				return nil, errors.New("fail")
+			case "panic":
+				panic("boom")
+			case "slow":
+				time.Sleep(time.Millisecond * 100)
+			}
+			return pubsub.NewMessage(append([]byte("re:"), req.Body...)), nil
+		})
+		assert.Equal(t, err, nil)
+
+		r, err := pubsub.NewRequester(ps, scope)
+		assert.Equal(t, err, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		res, err := r.Request(ctx, "TestRequest", pubsub.NewMessage([]byte("ping")))
+		cancel()
+		assert.Equal(t, err, nil)
+		assert.Equal(t, string(res.Body), "re:ping")
+
+		var rerr *pubsub.ReplyError
+		_, err = r.Request(context.Background(), "TestRequest", pubsub.NewMessage([]byte("fail")))
+		assert.Equal(t, errors.As(err, &rerr), true)
+		assert.Equal(t, rerr.Msg, "fail")
+
+		_, err = r.Request(context.Background(), "TestRequest", pubsub.NewMessage([]byte("panic")))
+		assert.Equal(t, errors.As(err, &rerr), true)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+		_, err = r.Request(ctx, "TestRequest", pubsub.NewMessage([]byte("slow")))
+		cancel()
+		assert.Equal(t, err, context.DeadlineExceeded)
+
+		assert.Equal(t, r.Close(), nil)
+		_, err = r.Request(context.Background(), "TestRequest", pubsub.NewMessage([]byte("ping")))
+		assert.Equal(t, err, pubsub.ErrRequesterClosed)
+
+		ps.(module.IModule).Close()
+	}
+}
+
+func TestDedup(t *testing.T) {
+	ps := newPubsub("TestDedup", WithRetry(3, time.Millisecond, time.Millisecond))
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestDedup", pubsub.ScopeProc)
+
+	var lock sync.Mutex
+	deduped := make(map[string]int)
+	var plain int32
+
+	done := make(chan struct{}, 8)
+	topic.Sub("dedup", pubsub.WithDedup(pubsub.NewMemDedup(), time.Minute)).ArrivedAck(func(msg *pubsub.Message) error {
+		defer func() { done <- struct{}{} }()
+
+		lock.Lock()
+		deduped[string(msg.Body)]++
+		lock.Unlock()
+
+		// 失败的消息释放去重记录，重试时可以被再次处理
+		if string(msg.Body) == "fail" && msg.Attempts == 1 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	topic.Sub("plain").Arrived(func(msg *pubsub.Message) {
+		atomic.AddInt32(&plain, 1)
+	})
+
+	dup := &pubsub.Message{ID: "reward-1", Body: []byte("dup")}
+	topic.Pub(dup)
+	topic.Pub(&pubsub.Message{ID: "reward-1", Body: []byte("dup")})
+	topic.Pub(pubsub.NewMessage([]byte("fail")))
+
+	// dup 处理一次，fail 处理两次（跳过的消息不会调用句柄
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	lock.Lock()
+	assert.Equal(t, deduped["dup"], 1)
+	assert.Equal(t, deduped["fail"], 2)
+	lock.Unlock()
+	assert.Equal(t, atomic.LoadInt32(&plain), int32(3))
+
+	stats := topic.Stats()
+	assert.Equal(t, stats.Channels[0].Name, "dedup")
+	assert.Equal(t, stats.Channels[0].Skipped, uint64(1))
+	assert.Equal(t, stats.Channels[1].Skipped, uint64(0))
+}
+
+func TestOverflowBlock(t *testing.T) {
+	ps := newPubsub("TestOverflowBlock")
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestOverflowBlock", pubsub.ScopeProc)
+	topic.Sub("full", pubsub.WithCapacity(1, pubsub.OverflowBlock))
+	assert.Equal(t, topic.Pub(pubsub.NewMessage([]byte("1"))), nil)
+
+	// 已满的 channel 阻塞发布者直到 ctx 结束
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	assert.Equal(t, topic.PubContext(ctx, pubsub.NewMessage([]byte("2"))), context.DeadlineExceeded)
+
+	blocked := make(chan error)
+	go func() {
+		blocked <- topic.Pub(pubsub.NewMessage([]byte("3")))
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	// 阻塞的发布者不影响 topic 上的其他操作
+	done := make(chan struct{})
+	go func() {
+		topic.Sub("other")
+		topic.Stats()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("topic is blocked by the full channel")
+	}
+
+	// 删除已满的 channel 后阻塞的发布者返回
+	topic.RemoveChannel("full")
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("publisher is still blocked")
+	}
+}
+
+func TestRespondReply(t *testing.T) {
+	requester := newPubsub("TestRespondReply_requester")
+	defer requester.(module.IModule).Close()
+	responder := newPubsub("TestRespondReply_responder")
+	defer responder.(module.IModule).Close()
+
+	var handled int32
+	reported := make(chan error, 1)
+	_, err := pubsub.Respond(responder, "TestRespondReply", pubsub.ScopeCluster, "responder", func(req *pubsub.Message) (*pubsub.Message, error) {
+		atomic.AddInt32(&handled, 1)
+		return pubsub.NewMessage(req.Body), nil
+	}, pubsub.WithErrorHandler(func(msg *pubsub.Message, err error) {
+		reported <- err
+	}))
+	assert.Equal(t, err, nil)
+
+	r, err := pubsub.NewRequester(requester, pubsub.ScopeCluster)
+	assert.Equal(t, err, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	res, err := r.Request(ctx, "TestRespondReply", pubsub.NewMessage([]byte("hello")))
+	cancel()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(res.Body), "hello")
+
+	// 应答方不会注册请求方的应答 topic
+	stats := responder.Stats()
+	assert.Equal(t, len(stats), 1)
+	assert.Equal(t, stats[0].Name, "TestRespondReply")
+
+	// 应答 topic 已经不存在时，发送应答的错误交给回调，不会重新处理请求
+	r.Close()
+	topic, _ := requester.RegistTopic("TestRespondReply", pubsub.ScopeCluster)
+	req := pubsub.NewMessage([]byte("gone"))
+	req.SetHeader(pubsub.HeaderReplyTo, "TestRespondReply_gone")
+	assert.Equal(t, topic.Pub(req), nil)
+
+	select {
+	case err = <-reported:
+		assert.Equal(t, err != nil, true)
+	case <-time.After(time.Second):
+		t.Fatal("reply error is not reported")
+	}
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, atomic.LoadInt32(&handled), int32(2))
+}
+
+func TestPartition(t *testing.T) {
+	ps := newPubsub("TestPartition", WithRetry(3, time.Millisecond*10, time.Millisecond*50))
+	defer ps.(module.IModule).Close()
+
+	keys := []string{"player1", "player2", "player3"}
+	const num = 20
+
+	var lock sync.Mutex
+	recv := make(map[string][]string)
+	var failed int32
+	var active, maxActive int32
+
+	topic, _ := ps.RegistTopic("TestPartition", pubsub.ScopeProc)
+	topic.Sub("Normal").ArrivedAck(func(msg *pubsub.Message) error {
+		cur := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			old := atomic.LoadInt32(&maxActive)
+			if cur <= old || atomic.CompareAndSwapInt32(&maxActive, old, cur) {
+				break
+			}
+		}
+
+		// 每个分区的第 5 条消息第一次处理失败，后续的消息需要等待它重试成功
+		if string(msg.Body) == "4" && atomic.AddInt32(&failed, 1) <= int32(len(keys)) {
+			return errors.New("retry")
+		}
+
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		recv[msg.PartitionKey()] = append(recv[msg.PartitionKey()], string(msg.Body))
+		lock.Unlock()
+		return nil
+	}, pubsub.WithConcurrency(4))
+
+	for i := 0; i < num; i++ {
+		for _, key := range keys {
+			topic.Pub(pubsub.NewMessage([]byte(strconv.Itoa(i))).SetPartitionKey(key))
+		}
+	}
+
+	assert.Equal(t, wait(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		cnt := 0
+		for _, bodies := range recv {
+			cnt += len(bodies)
+		}
+		return cnt == num*len(keys)
+	}), true)
+
+	lock.Lock()
+	for _, key := range keys {
+		expect := make([]string, num)
+		for i := range expect {
+			expect[i] = strconv.Itoa(i)
+		}
+		assert.Equal(t, recv[key], expect)
+	}
+	lock.Unlock()
+
+	// 不同分区的消息并行处理
+	assert.Equal(t, atomic.LoadInt32(&maxActive) > 1, true)
+	assert.Equal(t, wait(func() bool { return topic.Stats().Channels[0].Backlog == 0 }), true)
+}
+
+func TestPending(t *testing.T) {
+	topic := newTopic("TestPending", pubsub.ScopeProc, pubsub.ChannelParm{Capacity: 2, Overflow: pubsub.OverflowDropNewest})
+	defer topic.exit()
+
+	// 还没有 channel 时最多保存 2 条消息
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, topic.pub(context.Background(), pubsub.NewMessage([]byte("msg"))), nil)
+	}
+	assert.Equal(t, topic.pub(context.Background(), pubsub.NewMessage([]byte("msg"))) != nil, true)
+	assert.Equal(t, topic.stats().Dropped, uint64(1))
+
+	// 容量为 1 的阻塞 channel 不会阻塞 Sub，放不下的消息计入丢弃
+	cp := pubsub.NewChannelParm(pubsub.WithCapacity(1, pubsub.OverflowBlock))
+	done := make(chan *memChannel)
+	go func() {
+		done <- topic.sub("Normal", func() *memChannel {
+			return newChannel("TestPending", "Normal", cp, buffer.NewMsgQueue(cp, nil), metrics.Nop().Counter("dropped", "").With())
+		})
+	}()
+
+	select {
+	case c := <-done:
+		assert.Equal(t, c.backlog(), 1)
+		assert.Equal(t, c.stats().Dropped, uint64(1))
+	case <-time.After(time.Second):
+		t.Fatal("sub blocked")
+	}
+}
+
+func TestPendingBlock(t *testing.T) {
+	topic := newTopic("TestPendingBlock", pubsub.ScopeProc, pubsub.ChannelParm{Capacity: 1, Overflow: pubsub.OverflowBlock})
+	defer topic.exit()
+
+	assert.Equal(t, topic.pub(context.Background(), pubsub.NewMessage([]byte("msg"))), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	assert.Equal(t, topic.pub(ctx, pubsub.NewMessage([]byte("msg"))), context.DeadlineExceeded)
+
+	// 阻塞的发布者在第一个 channel 创建后投递到 channel
+	errc := make(chan error)
+	go func() {
+		errc <- topic.pub(context.Background(), pubsub.NewMessage([]byte("msg")))
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	cp := pubsub.NewChannelParm()
+	c := topic.sub("Normal", func() *memChannel {
+		return newChannel("TestPendingBlock", "Normal", cp, buffer.NewMsgQueue(cp, nil), metrics.Nop().Counter("dropped", "").With())
+	})
+	assert.Equal(t, <-errc, nil)
+	assert.Equal(t, c.backlog(), 2)
+}
@@ -0,0 +1,511 @@
+package pubsubmem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pojol/braid-go/internal/buffer"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// memTopic 话题，发布的消息会广播到 topic 中的所有 channel
+type memTopic struct {
+	Name  string
+	scope pubsub.ScopeTy
+
+	exitFlag int32
+	rec      pubsub.TopicRecorder
+
+	sync.RWMutex
+	channelMap map[string]*memChannel
+
+	// pending 还没有 channel 时发布的消息（容量和溢出策略由 pendingParm 决定），会投递给第一个创建的 channel
+	pending     *buffer.MsgQueue
+	pendingParm pubsub.ChannelParm
+}
+
+func newTopic(name string, scope pubsub.ScopeTy, pendingParm pubsub.ChannelParm) *memTopic {
+	return &memTopic{
+		Name:        name,
+		scope:       scope,
+		channelMap:  make(map[string]*memChannel),
+		pendingParm: pendingParm,
+	}
+}
+
+// pub 将消息放入所有的 channel
+//
+// 在锁外投递，OverflowBlock 的 channel 已满时只阻塞当前的发布者（直到 ctx 结束），不影响 topic 上的其他操作
+func (t *memTopic) pub(ctx context.Context, msg *pubsub.Message) error {
+	t.Lock()
+
+	for len(t.channelMap) == 0 {
+		if atomic.LoadInt32(&t.exitFlag) == 1 {
+			t.Unlock()
+			return errors.New("exiting")
+		}
+
+		if t.pending == nil {
+			t.pending = buffer.NewMsgQueue(t.pendingParm, nil)
+		}
+		pending := t.pending
+		t.Unlock()
+
+		dropped, err := pending.PutContext(ctx, msg)
+		if err == buffer.ErrClosed {
+			// 第一个 channel 已经创建（或者 topic 退出），重新检查
+			t.Lock()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if dropped != nil {
+			t.rec.Dropped(1)
+			if dropped == msg {
+				return fmt.Errorf("topic %v pending queue is full", t.Name)
+			}
+		}
+
+		return nil
+	}
+
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		t.Unlock()
+		return errors.New("exiting")
+	}
+
+	channels := make([]*memChannel, 0, len(t.channelMap))
+	for _, c := range t.channelMap {
+		channels = append(channels, c)
+	}
+	t.Unlock()
+
+	for _, c := range channels {
+		if err := c.put(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sub 获取 channel，不存在时通过 create 创建
+func (t *memTopic) sub(name string, create func() *memChannel) *memChannel {
+	t.Lock()
+
+	c, ok := t.channelMap[name]
+	if ok {
+		t.Unlock()
+		return c
+	}
+
+	c = create()
+	t.channelMap[name] = c
+	pending := t.pending
+	t.pending = nil
+	t.Unlock()
+
+	if pending != nil {
+		t.replay(c, pending)
+	}
+
+	return c
+}
+
+// replay 在锁外将 pending 中的消息投递给第一个 channel
+//
+// 此时 channel 还没有消费者，投递不会阻塞：OverflowBlock 的 channel 已满时余下的消息计入 channel 丢弃的消息
+func (t *memTopic) replay(c *memChannel, pending *buffer.MsgQueue) {
+	// 先关闭队列，阻塞在 pending 上的发布者会重新投递到 channel
+	pending.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for {
+		msg, ok := pending.Pop()
+		if !ok {
+			return
+		}
+
+		if err := c.put(ctx, msg); err != nil {
+			c.dropped.Inc()
+			c.rec.Dropped(1)
+		}
+	}
+}
+
+func (t *memTopic) removeChannel(name string) error {
+	t.Lock()
+	c, ok := t.channelMap[name]
+	delete(t.channelMap, name)
+	t.Unlock()
+
+	if !ok {
+		return fmt.Errorf("channel %v does not exist", name)
+	}
+
+	c.exit()
+	return nil
+}
+
+// drained topic 中的消息是否都已经被 channel 消费
+func (t *memTopic) drained() bool {
+	t.RLock()
+	defer t.RUnlock()
+
+	for _, c := range t.channelMap {
+		if !c.drained() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stats topic 的统计数据（集群作用域的 topic 包括总线上所有实例的数据
+func (t *memTopic) stats() pubsub.TopicStats {
+	s := pubsub.TopicStats{
+		Name:     t.Name,
+		Scope:    t.scope,
+		Channels: []pubsub.ChannelStats{},
+	}
+	t.rec.Fill(&s)
+
+	t.RLock()
+	for _, c := range t.channelMap {
+		s.Channels = append(s.Channels, c.stats())
+	}
+	t.RUnlock()
+
+	sort.Slice(s.Channels, func(i, j int) bool { return s.Channels[i].Name < s.Channels[j].Name })
+	return s
+}
+
+func (t *memTopic) exit() {
+	if !atomic.CompareAndSwapInt32(&t.exitFlag, 0, 1) {
+		return
+	}
+
+	t.Lock()
+	channels := make([]*memChannel, 0, len(t.channelMap))
+	for name, c := range t.channelMap {
+		delete(t.channelMap, name)
+		channels = append(channels, c)
+	}
+	pending := t.pending
+	t.pending = nil
+	t.Unlock()
+
+	if pending != nil {
+		pending.Close()
+	}
+
+	for _, c := range channels {
+		c.exit()
+	}
+}
+
+// topicView 某个 pubsub 实例所持有的 topic
+//
+// 集群作用域的 topic 由总线上的多个 pubsub 实例共享，每个实例只负责停止自己添加的消费者
+type topicView struct {
+	t  *memTopic
+	ps *memPubsub
+
+	published metrics.ICounter
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// deadLetter topic 的死信 topic，channelDead 单独设置了死信 topic 的 channel
+	deadLock    sync.RWMutex
+	deadLetter  string
+	channelDead map[string]string
+}
+
+func (tv *topicView) Pub(msg *pubsub.Message) error {
+	return tv.PubContext(context.Background(), msg)
+}
+
+// PubContext 消息放入所有 channel 后返回（ctx 只在放入之前检查，OverflowBlock 的 channel 已满时会一直阻塞
+func (tv *topicView) PubContext(ctx context.Context, msg *pubsub.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-tv.stop:
+		return errors.New("exiting")
+	default:
+	}
+
+	pubsub.Stamp(msg, tv.ps.serviceName, tv.ps.node)
+
+	err := tv.t.pub(ctx, msg)
+	if err == nil {
+		tv.published.Inc()
+		tv.t.rec.Published(1)
+	}
+
+	return err
+}
+
+func (tv *topicView) PubAsync(ctx context.Context, msg *pubsub.Message, done func(error)) {
+	go func() {
+		err := tv.PubContext(ctx, msg)
+		if done != nil {
+			done(err)
+		}
+	}()
+}
+
+// PubDelay 消息保存在进程内的时间轮中，到期后投递（关闭 pubsub 时未到期的消息会被丢弃
+//
+// 到期的消息在单独的 goroutine 中发布，阻塞时间不超过 DelayTimeout
+func (tv *topicView) PubDelay(ctx context.Context, msg *pubsub.Message, delay time.Duration) error {
+	if delay <= 0 {
+		return tv.PubContext(ctx, msg)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-tv.stop:
+		return errors.New("exiting")
+	default:
+	}
+
+	pubsub.Stamp(msg, tv.ps.serviceName, tv.ps.node)
+	ok := tv.ps.delayer.After(delay, func(ctx context.Context) {
+		if err := tv.PubContext(ctx, msg); err != nil {
+			tv.ps.log.Warnf("topic %v publish delayed message %v err %v", tv.t.Name, msg.ID, err)
+		}
+	})
+	if !ok {
+		return errors.New("exiting")
+	}
+
+	return nil
+}
+
+func (tv *topicView) PubBatch(ctx context.Context, msgs []*pubsub.Message) error {
+	for _, msg := range msgs {
+		if err := tv.PubContext(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tv *topicView) Sub(name string, opts ...pubsub.ChannelOption) pubsub.IChannel {
+	ps := tv.ps
+
+	c := tv.t.sub(name, func() *memChannel {
+		cp := pubsub.NewChannelParm(opts...)
+		queue := buffer.NewMsgQueue(cp, func(backlog int) {
+			if cp.OnBacklog != nil {
+				cp.OnBacklog(tv.t.Name, name, backlog)
+				return
+			}
+			ps.log.Warnf("channel %v/%v backlog %v reached the warning threshold", tv.t.Name, name, backlog)
+		})
+
+		return newChannel(tv.t.Name, name, cp, queue, ps.dropped.With(tv.t.Name, name))
+	})
+	ps.log.Infof("Topic %v new channel %v", tv.t.Name, name)
+
+	return &channelView{
+		c:        c,
+		tv:       tv,
+		consumed: tv.ps.consumed.With(tv.t.Name, name),
+		backlog:  tv.ps.backlog.With(tv.t.Name, name),
+	}
+}
+
+func (tv *topicView) Stats() pubsub.TopicStats {
+	return tv.t.stats()
+}
+
+func (tv *topicView) RemoveChannel(name string) error {
+	tv.ps.log.Infof("topic %v deleting channel %v", tv.t.Name, name)
+	return tv.t.removeChannel(name)
+}
+
+func (tv *topicView) DeadLetter(name string) error {
+	_, err := pubsub.PrepareDeadLetter(tv.ps, name, tv.t.scope)
+	if err != nil {
+		return err
+	}
+
+	tv.deadLock.Lock()
+	tv.deadLetter = name
+	tv.deadLock.Unlock()
+
+	return nil
+}
+
+// deadLetterOf channel 的死信 topic（没有设置时返回空字符串
+func (tv *topicView) deadLetterOf(channel string) string {
+	tv.deadLock.RLock()
+	defer tv.deadLock.RUnlock()
+
+	if name, ok := tv.channelDead[channel]; ok {
+		return name
+	}
+	return tv.deadLetter
+}
+
+// dead 将处理失败的消息发布到死信 topic，没有设置死信 topic 时丢弃
+func (tv *topicView) dead(channel string, msg *pubsub.Message, err error) {
+	ps := tv.ps
+
+	name := tv.deadLetterOf(channel)
+	if name == "" {
+		ps.log.Warnf("topic %v channel %v give up message %v after %v attempts, err %v",
+			tv.t.Name, channel, msg.ID, msg.Attempts, err)
+		return
+	}
+
+	dt, perr := ps.RegistTopic(name, tv.t.scope)
+	if perr == nil {
+		perr = dt.Pub(pubsub.NewDeadLetter(msg, tv.t.Name, channel, err))
+	}
+	if perr != nil {
+		ps.log.Warnf("topic %v channel %v dead letter message %v to %v err %v", tv.t.Name, channel, msg.ID, name, perr)
+	}
+}
+
+// close 停止本实例在 topic 上添加的所有消费者
+func (tv *topicView) close() {
+	tv.stopOnce.Do(func() {
+		close(tv.stop)
+	})
+}
+
+// channelView 某个 pubsub 实例所持有的 channel
+type channelView struct {
+	c  *memChannel
+	tv *topicView
+
+	consumed metrics.ICounter
+	backlog  metrics.IGauge
+}
+
+func (cv *channelView) Arrived(handler pubsub.Handler, opts ...pubsub.SubOption) pubsub.ISubscription {
+	return cv.ArrivedAck(func(msg *pubsub.Message) error {
+		handler(msg)
+		return nil
+	}, opts...)
+}
+
+// ArrivedAck 添加 Concurrency 个消费 goroutine，与 channel 中的其他句柄竞争消费
+func (cv *channelView) ArrivedAck(handler pubsub.AckHandler, opts ...pubsub.SubOption) pubsub.ISubscription {
+	ps := cv.tv.ps
+	sp := pubsub.NewSubParm(opts...)
+	handler = sp.Protect(handler)
+	handler = cv.c.parm.Deduplicate(cv.c.TopicName, cv.c.Name, handler, &cv.c.rec, func(err error) {
+		ps.log.Warnf("channel %v/%v dedup err %v", cv.c.TopicName, cv.c.Name, err)
+	})
+
+	handle := func(msg *pubsub.Message) error {
+		begin := time.Now()
+		err := handler(msg)
+		cv.c.rec.Handled(time.Since(begin), err)
+		cv.consumed.Inc()
+		cv.backlog.Set(float64(cv.c.backlog()))
+		if err != nil {
+			cv.report(err)
+		}
+		return err
+	}
+
+	sub := pubsub.NewSubscription(nil)
+	for i := 0; i < sp.Concurrency; i++ {
+		ps.handlers.Add(1)
+		cv.c.consume(func(msg *pubsub.Message) {
+			if err := handle(msg); err != nil {
+				cv.requeue(msg, err)
+			}
+		}, func(msg *pubsub.Message) {
+			cv.ordered(msg, handle)
+		}, sub, cv.tv.stop, ps.handlers.Done)
+	}
+
+	return sub
+}
+
+func (cv *channelView) DeadLetter(name string) error {
+	tv := cv.tv
+
+	_, err := pubsub.PrepareDeadLetter(tv.ps, name, tv.t.scope)
+	if err != nil {
+		return err
+	}
+
+	tv.deadLock.Lock()
+	tv.channelDead[cv.c.Name] = name
+	tv.deadLock.Unlock()
+
+	return nil
+}
+
+// requeue 处理失败的消息在退避之后重新投递到本 channel，超过最大投递次数后发布到死信 topic
+// report 记录句柄中的 panic
+func (cv *channelView) report(err error) {
+	var perr *pubsub.PanicError
+	if errors.As(err, &perr) {
+		cv.tv.ps.log.Errorf("channel %v/%v handler panic %v\n%s", cv.tv.t.Name, cv.c.Name, perr.Value, perr.Stack)
+	}
+}
+
+// ordered 处理带有分区键的消息，失败时在当前的 goroutine 中等待重试（保证不会被相同分区键的后续消息越过），
+// 超过最大投递次数后发布到死信 topic
+func (cv *channelView) ordered(msg *pubsub.Message, handle func(*pubsub.Message) error) {
+	ps := cv.tv.ps
+
+	// 同一条消息会广播给多个 channel，重试时修改的是副本
+	m := *msg
+	for {
+		err := handle(&m)
+		if err == nil {
+			return
+		}
+
+		delay, ok := ps.parm.Retry.Next(&m, err)
+		if !ok {
+			cv.tv.dead(cv.c.Name, &m, err)
+			return
+		}
+
+		if !cv.c.wait(delay, cv.tv.stop) {
+			return
+		}
+		m.Attempts++
+	}
+}
+
+func (cv *channelView) requeue(msg *pubsub.Message, err error) {
+	ps := cv.tv.ps
+
+	delay, ok := ps.parm.Retry.Next(msg, err)
+	if !ok {
+		cv.tv.dead(cv.c.Name, msg, err)
+		return
+	}
+
+	// 同一条消息会广播给多个 channel，重试时复制一份
+	retry := *msg
+	retry.Attempts++
+	cv.c.retry(&retry, delay)
+}
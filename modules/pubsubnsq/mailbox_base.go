@@ -2,14 +2,22 @@
 package pubsubnsq
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nsqio/go-nsq"
+	"github.com/pojol/braid-go/internal/timewheel"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 	"github.com/pojol/braid-go/modules/moduleparm"
 )
@@ -17,18 +25,31 @@ import (
 const (
 	// Name pub-sub plug-in name
 	Name = "PubsubNsq"
+
+	// WheelTick WheelSlots 进程内延迟消息的时间轮精度以及槽数
+	WheelTick  = time.Millisecond * 10
+	WheelSlots = 512
+
+	// DelayTimeout 进程内延迟消息到期后发布的超时时间
+	DelayTimeout = time.Second * 5
 )
 
 type nsqPubsubBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newNsqPubsub() module.IBuilder {
 	return &nsqPubsubBuilder{}
 }
 
-func (nb *nsqPubsubBuilder) AddModuleOption(opt interface{}) {
-	nb.opts = append(nb.opts, opt)
+func (nb *nsqPubsubBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	nb.opts = append(nb.opts, o)
+	return nil
 }
 
 func (nb *nsqPubsubBuilder) Name() string {
@@ -39,30 +60,66 @@ func (nb *nsqPubsubBuilder) Type() module.ModuleType {
 	return module.Pubsub
 }
 
-func (nb *nsqPubsubBuilder) Build(name string, buildOpts ...interface{}) interface{} {
-	bp := moduleparm.BuildParm{}
-	for _, opt := range buildOpts {
-		opt.(moduleparm.Option)(&bp)
+func (nb *nsqPubsubBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Metrics, Optional: true},
 	}
+}
 
+// parm 默认配置项加上用户设置的配置项
+func (nb *nsqPubsubBuilder) parm(name string) Parm {
 	p := Parm{
 		ServiceName:       name,
 		nsqLogLv:          nsq.LogLevelWarning,
 		ConcurrentHandler: 1,
+		Retry:             pubsub.DefaultRetry,
+		ProducerHealth:    time.Second * 5,
 	}
 	for _, opt := range nb.opts {
-		opt.(Option)(&p)
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (nb *nsqPubsubBuilder) Validate() error {
+	p := nb.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
 	}
 
+	return nil
+}
+
+func (nb *nsqPubsubBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := nb.parm(name)
+
 	rand.Seed(time.Now().UnixNano())
-	if len(p.NsqdAddress) != len(p.NsqdHttpAddress) {
-		panic(fmt.Errorf("parm nsqd len(tcp addr) != len(http addr)"))
+	if err := p.Validate(); err != nil {
+		panic(&module.OptionError{Module: Name, Err: err})
 	}
 
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
+	}
+
+	node, _ := os.Hostname()
+
 	nsqm := &nsqPubsub{
 		parm:     p,
+		node:     node,
 		log:      bp.Logger,
 		topicMap: make(map[string]*pubsubTopic),
+		delayer:  timewheel.NewDelayer(WheelTick, WheelSlots, DelayTimeout),
+
+		published: m.Counter("braid_pubsub_published_total", "Number of messages published to the topic.", "topic"),
+		consumed:  m.Counter("braid_pubsub_consumed_total", "Number of messages consumed by the channel.", "topic", "channel"),
+		backlog:   m.Gauge("braid_pubsub_backlog", "Number of messages waiting in the channel.", "topic", "channel"),
+		dropped:   m.Counter("braid_pubsub_dropped_total", "Number of messages dropped by the channel because of overflow.", "topic", "channel"),
 	}
 
 	return nsqm
@@ -72,9 +129,32 @@ type nsqPubsub struct {
 	parm Parm
 	log  logger.ILogger
 
+	// node 发布消息时填充的节点名
+	node string
+
+	published metrics.ICounterVec
+	consumed  metrics.ICounterVec
+	backlog   metrics.IGaugeVec
+	dropped   metrics.ICounterVec
+
 	sync.RWMutex
 
 	topicMap map[string]*pubsubTopic
+
+	// pool 集群作用域的 topic 共享的 producer，在注册第一个集群 topic 时创建
+	pool *producerPool
+
+	// delayer 进程作用域的延迟消息的调度，在第一次发送延迟消息时创建时间轮
+	delayer *timewheel.Delayer
+}
+
+// producers 获取（或者创建）producer pool，调用方持有锁
+func (nmb *nsqPubsub) producers() *producerPool {
+	if nmb.pool == nil {
+		nmb.pool = newProducerPool(nmb.parm.NsqdAddress, nsq.NewConfig(), nmb.parm.ProducerHealth, nmb.log)
+	}
+
+	return nmb.pool
 }
 
 func (nmb *nsqPubsub) RegistTopic(name string, scope pubsub.ScopeTy) (pubsub.ITopic, error) {
@@ -115,6 +195,36 @@ func (nmb *nsqPubsub) GetTopic(name string) pubsub.ITopic {
 	return nt
 }
 
+// Publish 向 topic 发布消息，集群作用域的消息直接通过 producer 发送到 nsqd（不会在本地创建 topic 以及它的 loop
+func (nmb *nsqPubsub) Publish(ctx context.Context, name string, scope pubsub.ScopeTy, msg *pubsub.Message) error {
+	nmb.RLock()
+	topic, ok := nmb.topicMap[name]
+	nmb.RUnlock()
+	if ok {
+		return topic.PubContext(ctx, msg)
+	}
+
+	if scope != pubsub.ScopeCluster {
+		return fmt.Errorf("topic %v does not exist", name)
+	}
+
+	pubsub.Stamp(msg, nmb.parm.ServiceName, nmb.node)
+
+	// producers 在持有锁时创建
+	nmb.Lock()
+	pool := nmb.producers()
+	nmb.Unlock()
+
+	invoker := func(ctx context.Context, topic string, msg *pubsub.Message) error {
+		return pool.publish(ctx, topic, msg.PartitionKey(), pubsub.Encode(msg))
+	}
+	if interceptor := nmb.parm.interceptors.Pub(name); interceptor != nil {
+		return interceptor(ctx, name, msg, invoker)
+	}
+
+	return invoker(ctx, name, msg)
+}
+
 func (nmb *nsqPubsub) RemoveTopic(name string) error {
 	nmb.RLock()
 	topic, ok := nmb.topicMap[name]
@@ -134,6 +244,170 @@ func (nmb *nsqPubsub) RemoveTopic(name string) error {
 	return nil
 }
 
+// DeleteTopic 删除 topic（同 RemoveTopic），集群作用域的 topic 同时从 nsqd 以及 nsqlookupd 中删除（包括其中的 channel 以及未消费的消息
+func (nmb *nsqPubsub) DeleteTopic(name string) error {
+	nmb.RLock()
+	topic, ok := nmb.topicMap[name]
+	nmb.RUnlock()
+
+	if err := nmb.RemoveTopic(name); err != nil || !ok || topic.scope != pubsub.ScopeCluster {
+		return err
+	}
+
+	var err error
+	for _, addr := range nmb.parm.NsqdHttpAddress {
+		if perr := post(fmt.Sprintf("http://%s/topic/delete?topic=%s", addr, name)); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	for _, addr := range nmb.parm.LookupdAddress {
+		if perr := post(fmt.Sprintf("http://%s/topic/delete?topic=%s", addr, name)); perr != nil && err == nil {
+			err = perr
+		}
+	}
+
+	return err
+}
+
+// post 向 nsqd 或者 nsqlookupd 的 http 接口发送请求
+func post(url string) error {
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v request status err %v", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Shutdown 等待 topic 中的消息投递完毕，随后停止所有的 topic（包括 nsq 的 producer 和 consumer
+func (nmb *nsqPubsub) Shutdown(ctx context.Context) error {
+	nmb.RLock()
+	topics := make([]*pubsubTopic, 0, len(nmb.topicMap))
+	for _, t := range nmb.topicMap {
+		topics = append(topics, t)
+	}
+	nmb.RUnlock()
+
+	var err error
+	for _, t := range topics {
+		ferr := t.flush(ctx)
+		if ferr != nil && err == nil {
+			err = ferr
+		}
+
+		t.Exit()
+	}
+
+	nmb.Lock()
+	pool := nmb.pool
+	nmb.pool = nil
+	nmb.Unlock()
+	if pool != nil {
+		pool.stop()
+	}
+
+	nmb.delayer.Stop()
+
+	return err
+}
+
+// Health 至少有一个健康的 producer，并且所有集群 channel 的 consumer 都至少连接上了一个 nsqd 时视为可用
+func (nmb *nsqPubsub) Health() module.Health {
+	nmb.RLock()
+	topics := make([]*pubsubTopic, 0, len(nmb.topicMap))
+	for _, t := range nmb.topicMap {
+		topics = append(topics, t)
+	}
+	pool := nmb.pool
+	nmb.RUnlock()
+
+	if pool != nil && pool.healthy() == 0 {
+		return module.Health{
+			Status:  module.HealthDown,
+			LastErr: ErrNoProducer,
+		}
+	}
+
+	for _, t := range topics {
+		t.RLock()
+		for _, c := range t.channelMap {
+			if consumer := c.getConsumer(); consumer != nil && consumer.Stats().Connections == 0 {
+				t.RUnlock()
+				return module.Health{
+					Status:  module.HealthDown,
+					LastErr: fmt.Errorf("channel %v/%v is not connected to nsqd", t.Name, c.Name),
+				}
+			}
+		}
+		t.RUnlock()
+	}
+
+	return module.Health{Status: module.HealthUp, LastSuccess: time.Now()}
+}
+
+// Introspect 配置项，以及所有 topic 和 channel 中积压的消息数
+func (nmb *nsqPubsub) Introspect() interface{} {
+	nmb.RLock()
+	topics := make([]*pubsubTopic, 0, len(nmb.topicMap))
+	for _, t := range nmb.topicMap {
+		topics = append(topics, t)
+	}
+	pool := nmb.pool
+	nmb.RUnlock()
+
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+
+	tinfos := []map[string]interface{}{}
+	for _, t := range topics {
+		t.RLock()
+		cinfos := []map[string]interface{}{}
+		for _, c := range t.channelMap {
+			cinfos = append(cinfos, map[string]interface{}{
+				"name":     c.Name,
+				"backlog":  c.queue.Len(),
+				"capacity": c.queue.Capacity(),
+				"handlers": atomic.LoadInt32(&c.handlers),
+				"cluster":  c.scope == pubsub.ScopeCluster,
+			})
+		}
+		t.RUnlock()
+
+		sort.Slice(cinfos, func(i, j int) bool { return cinfos[i]["name"].(string) < cinfos[j]["name"].(string) })
+		tinfos = append(tinfos, map[string]interface{}{
+			"name":     t.Name,
+			"scope":    t.scope,
+			"backlog":  len(t.msgch),
+			"channels": cinfos,
+		})
+	}
+
+	producers := []map[string]interface{}{}
+	if pool != nil {
+		producers = pool.introspect()
+	}
+
+	return map[string]interface{}{
+		"parm":      nmb.parm,
+		"topics":    tinfos,
+		"producers": producers,
+	}
+}
+
 func init() {
-	module.Register(newNsqPubsub())
+	module.Register(newNsqPubsub)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"channel":            WithChannel,
+		"lookup_addr":        WithLookupAddr,
+		"nsqd_addr":          WithNsqdAddr,
+		"nsq_log_lv":         WithNsqLogLv,
+		"handler_concurrent": WithHandlerConcurrent,
+		"retry":              WithRetry,
+		"producer_health":    WithProducerHealth,
+	})
 }
@@ -1,55 +1,344 @@
 package pubsubnsq
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nsqio/go-nsq"
+	"github.com/pojol/braid-go/internal/buffer"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 )
 
 type pubsubChannel struct {
 	sync.RWMutex
 
-	msgCh *UnboundedMsg
+	// queue 进程内 channel 中积压的消息
+	queue *buffer.MsgQueue
 
 	ps       *nsqPubsub
+	topic    *pubsubTopic
 	exitFlag int32
+	exitChan chan struct{}
+
+	// handlers 消费者的数量，inflight 正在被处理的集群消息数，retrying 等待重新投递的进程内消息数
+	handlers int32
+	inflight int32
+	retrying int32
+
+	// ackHandlers 集群 channel 的消费句柄，nsq 投递的消息轮流交给其中一个处理
+	ackHandlers []*clusterHandler
+	next        uint32
+	ready       chan struct{}
+
+	// partitions 带有分区键的消息，popLock 保证进程内 channel 的消息按照出队的顺序进入分区
+	partitions *buffer.Partitions
+	popLock    sync.Mutex
+
+	// jobs 集群 channel 的消息，由 ConcurrentHandler 个 worker 处理
+	jobs       chan *clusterJob
+	workerOnce sync.Once
+
+	// waitGroup 进程内 channel 的消费 goroutine 以及集群 channel 的 worker
+	waitGroup sync.WaitGroup
 
 	consumer *nsq.Consumer
 
+	// deadLetter channel 的死信 topic（覆盖 topic 上的设置
+	deadLetter string
+
+	// parm Sub 时传入的 channel 配置
+	parm pubsub.ChannelParm
+
+	consumed metrics.ICounter
+	backlog  metrics.IGauge
+	dropped  metrics.ICounter
+	rec      pubsub.ChannelRecorder
+
 	Name      string
 	TopicName string
 	scope     pubsub.ScopeTy
 }
 
+// touchInterval 集群消息在原地重试以及在分区中排队的期间刷新 nsqd 超时的间隔
+const touchInterval = time.Second * 10
+
+// clusterJob 集群 channel 中等待处理的消息
+type clusterJob struct {
+	raw *nsq.Message
+	msg *pubsub.Message
+}
+
 type consumerHandler struct {
 	channel string
 	c       *pubsubChannel
 }
 
+// clusterHandler 集群 channel 上的一个消费句柄，sem 限制句柄同时处理的消息数
+type clusterHandler struct {
+	handler pubsub.AckHandler
+	sub     *pubsub.Subscription
+	sem     chan struct{}
+}
+
+// acquire 等待句柄空闲，句柄已经取消订阅时返回 false
+func (h *clusterHandler) acquire() bool {
+	if !h.sub.Acquire() {
+		return false
+	}
+
+	select {
+	case h.sem <- struct{}{}:
+		return true
+	case <-h.sub.Done():
+		h.sub.Release()
+		return false
+	}
+}
+
+func (h *clusterHandler) release() {
+	<-h.sem
+	h.sub.Release()
+}
+
+// HandleMessage 将 nsq 投递的消息交给 worker 处理（consumer 只有一个接收 goroutine，保证消息按照到达的顺序进入分区
+//
+// 处理成功时回复 FIN，失败时按照重试策略回复 REQ（由 nsqd 在延迟之后重新投递），
+// 超过最大投递次数后回复 FIN 并丢弃消息；带有分区键的消息失败时在 worker 中原地重试
 func (ch *consumerHandler) HandleMessage(msg *nsq.Message) error {
+	c := ch.c
+	msg.DisableAutoResponse()
+
+	m, err := pubsub.Decode(msg.Body)
+	if err != nil {
+		c.ps.log.Warnf("channel %v decode message %s err %v", ch.channel, msg.ID[:], err.Error())
+		c.dead(&pubsub.Message{Body: msg.Body, Attempts: int(msg.Attempts)}, err)
+		msg.Finish()
+		return nil
+	}
+	m.Attempts = int(msg.Attempts)
 
-	ch.c.Put(&pubsub.Message{
-		Body: msg.Body,
-	})
+	job := &clusterJob{raw: msg, msg: m}
+	if key := m.PartitionKey(); key != "" && !c.partitions.Enter(key, job) {
+		// 排在相同分区键的消息之后，由分区的处理者处理
+		return nil
+	}
+
+	select {
+	case c.jobs <- job:
+	case <-c.exitChan:
+		c.requeue(job)
+	}
 	return nil
 }
 
-func newChannel(topicName, channelName string, scope pubsub.ScopeTy, n *nsqPubsub) *pubsubChannel {
+// work 集群 channel 的 worker，直到 channel 退出
+func (c *pubsubChannel) work() {
+	defer c.waitGroup.Done()
+
+	for {
+		select {
+		case job := <-c.jobs:
+			c.handleJob(job)
+		case <-c.exitChan:
+			return
+		}
+	}
+}
+
+// touch 定期刷新在分区中排队的消息在 nsqd 中的超时，避免等待期间被 nsqd 重新投递，直到 channel 退出
+func (c *pubsubChannel) touch() {
+	defer c.waitGroup.Done()
+
+	ticker := time.NewTicker(touchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.partitions.Range(func(v interface{}) {
+				v.(*clusterJob).raw.Touch()
+			})
+		case <-c.exitChan:
+			return
+		}
+	}
+}
+
+// handleJob 处理集群消息，带有分区键时依次处理分区中的消息直到分区为空
+func (c *pubsubChannel) handleJob(job *clusterJob) {
+	key := job.msg.PartitionKey()
+	if key == "" {
+		c.handleCluster(job)
+		return
+	}
+
+	ok := c.handleOrdered(job.msg, c.deliver, job.raw.Touch)
+	for {
+		if ok {
+			job.raw.Finish()
+		} else {
+			// 没有可用的句柄（或者 channel 已经退出），分区中的消息都交还给 nsqd
+			c.requeue(job)
+		}
+
+		next, has := c.partitions.Next(key)
+		if !has {
+			return
+		}
+		job = next.(*clusterJob)
+		if ok {
+			ok = c.handleOrdered(job.msg, c.deliver, job.raw.Touch)
+		}
+	}
+}
+
+// handleCluster 处理没有分区键的集群消息
+func (c *pubsubChannel) handleCluster(job *clusterJob) {
+	ok, err := c.deliver(job.msg)
+	if !ok {
+		c.requeue(job)
+		return
+	}
+	if err == nil {
+		job.raw.Finish()
+		return
+	}
+
+	delay, retry := c.ps.parm.Retry.Next(job.msg, err)
+	if !retry {
+		c.dead(job.msg, err)
+		job.raw.Finish()
+		return
+	}
+
+	job.raw.RequeueWithoutBackoff(delay)
+}
+
+// requeue 交还给 nsqd 投递给其他的消费者
+func (c *pubsubChannel) requeue(job *clusterJob) {
+	job.raw.RequeueWithoutBackoff(0)
+}
+
+// deliver 将集群消息交给一个消费句柄，没有可用的句柄时返回 false
+func (c *pubsubChannel) deliver(m *pubsub.Message) (bool, error) {
+	handler, ok := c.pick()
+	if !ok || !handler.acquire() {
+		return false, nil
+	}
+
+	err := c.handle(handler.handler, m)
+	handler.release()
+
+	return true, err
+}
+
+// handle 调用句柄并记录统计数据
+func (c *pubsubChannel) handle(handler pubsub.AckHandler, m *pubsub.Message) error {
+	atomic.AddInt32(&c.inflight, 1)
+	begin := time.Now()
+	err := handler(m)
+	c.rec.Handled(time.Since(begin), err)
+	atomic.AddInt32(&c.inflight, -1)
+
+	c.consumed.Inc()
+	if err != nil {
+		c.report(err)
+	}
+
+	return err
+}
+
+// handleOrdered 处理带有分区键的消息，失败时在当前的 goroutine 中等待重试（保证不会被相同分区键的后续消息越过），
+// 超过最大投递次数后发布到死信 topic；deliver 没有可用的句柄或者 channel 退出时返回 false
+//
+// touch 在等待期间定期调用（集群消息用于刷新 nsqd 的超时
+func (c *pubsubChannel) handleOrdered(m *pubsub.Message, deliver func(*pubsub.Message) (bool, error), touch func()) bool {
+	// 同一条进程内消息会广播给多个 channel，重试时修改的是副本
+	cm := *m
+	m = &cm
+
+	for {
+		ok, err := deliver(m)
+		if !ok {
+			return false
+		}
+		if err == nil {
+			return true
+		}
+
+		delay, retry := c.ps.parm.Retry.Next(m, err)
+		if !retry {
+			c.dead(m, err)
+			return true
+		}
+
+		if !c.wait(delay, touch) {
+			return false
+		}
+		m.Attempts++
+	}
+}
+
+// wait 等待 delay（期间每隔 touchInterval 调用 touch），channel 退出时返回 false
+func (c *pubsubChannel) wait(delay time.Duration, touch func()) bool {
+	atomic.AddInt32(&c.retrying, 1)
+	defer atomic.AddInt32(&c.retrying, -1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(touchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			if touch != nil {
+				touch()
+			}
+		case <-c.exitChan:
+			return false
+		}
+	}
+}
+
+func newChannel(t *pubsubTopic, channelName string, cp pubsub.ChannelParm) *pubsubChannel {
+	topicName, scope, n := t.Name, t.scope, t.ps
 
 	c := &pubsubChannel{
 		Name:      channelName,
 		TopicName: topicName,
 		scope:     scope,
 		ps:        n,
-		msgCh:     NewUnbounded(),
+		topic:     t,
+		exitChan:  make(chan struct{}),
+		ready:     make(chan struct{}),
+		jobs:      make(chan *clusterJob),
+		parm:      cp,
+
+		partitions: buffer.NewPartitions(),
+		consumed:   n.consumed.With(topicName, channelName),
+		backlog:    n.backlog.With(topicName, channelName),
+		dropped:    n.dropped.With(topicName, channelName),
 	}
 
+	c.queue = buffer.NewMsgQueue(cp, func(backlog int) {
+		if cp.OnBacklog != nil {
+			cp.OnBacklog(topicName, channelName, backlog)
+			return
+		}
+		n.log.Warnf("channel %v/%v backlog %v reached the warning threshold", topicName, channelName, backlog)
+	})
+
 	if scope == pubsub.ScopeCluster {
 
 		for _, addr := range n.parm.NsqdHttpAddress {
@@ -74,72 +363,356 @@ func newChannel(topicName, channelName string, scope pubsub.ScopeTy, n *nsqPubsu
 				resp.Body.Close()
 			}
 		}
+	}
 
-		cfg := nsq.NewConfig()
-		cfg.MaxInFlight = len(n.parm.NsqdHttpAddress)
-		nsqConsumer, err := nsq.NewConsumer(topicName, channelName, cfg)
-		if err != nil {
-			n.log.Errorf("channel %v nsq.NewConsumer err %v", channelName, err)
-			return nil
-		}
-		nsqConsumer.SetLoggerLevel(n.parm.nsqLogLv)
+	return c
+}
 
-		nsqConsumer.AddConcurrentHandlers(&consumerHandler{
-			c:       c,
-			channel: channelName,
-		}, int(n.parm.ConcurrentHandler))
+// connect 在添加第一个消费句柄时创建 nsq 的 consumer（没有消费者的 channel 中的消息保留在 nsqd 中
+func (c *pubsubChannel) connect() error {
+	n, topicName, channelName := c.ps, c.TopicName, c.Name
 
-		if len(n.parm.LookupdAddress) == 0 { // 不推荐的处理方式
-			err = nsqConsumer.ConnectToNSQDs(n.parm.NsqdAddress)
-			if err != nil {
-				n.log.Errorf("channel %v nsq.ConnectToNSQDs err %v", channelName, err)
-				return nil
-			}
-		} else {
-			err = nsqConsumer.ConnectToNSQLookupds(n.parm.LookupdAddress)
-			if err != nil {
-				n.log.Errorf("channel %v nsq.ConnectToNSQLookupds err %v", channelName, err)
-				return nil
-			}
+	cfg := nsq.NewConfig()
+	cfg.MaxInFlight = len(n.parm.NsqdHttpAddress)
+	if cfg.MaxInFlight < int(n.parm.ConcurrentHandler) {
+		cfg.MaxInFlight = int(n.parm.ConcurrentHandler)
+	}
+	// 投递次数由 Parm.Retry 控制
+	cfg.MaxAttempts = 0
+	nsqConsumer, err := nsq.NewConsumer(topicName, channelName, cfg)
+	if err != nil {
+		return fmt.Errorf("nsq.NewConsumer err %w", err)
+	}
+	nsqConsumer.SetLoggerLevel(n.parm.nsqLogLv)
+
+	// 只用一个 goroutine 接收消息，由 ConcurrentHandler 个 worker 处理（worker 在 channel 退出时停止
+	nsqConsumer.AddHandler(&consumerHandler{
+		c:       c,
+		channel: channelName,
+	})
+	c.workerOnce.Do(func() {
+		for i := 0; i < int(n.parm.ConcurrentHandler); i++ {
+			c.waitGroup.Add(1)
+			go c.work()
 		}
 
-		c.consumer = nsqConsumer
-		n.log.Infof("Cluster consumer %v created", channelName)
+		c.waitGroup.Add(1)
+		go c.touch()
+	})
+
+	if len(n.parm.LookupdAddress) == 0 { // 不推荐的处理方式
+		err = nsqConsumer.ConnectToNSQDs(n.parm.NsqdAddress)
+		if err != nil {
+			return fmt.Errorf("nsq.ConnectToNSQDs err %w", err)
+		}
+	} else {
+		err = nsqConsumer.ConnectToNSQLookupds(n.parm.LookupdAddress)
+		if err != nil {
+			return fmt.Errorf("nsq.ConnectToNSQLookupds err %w", err)
+		}
 	}
 
-	return c
+	c.consumer = nsqConsumer
+	n.log.Infof("Cluster consumer %v created", channelName)
+
+	return nil
+}
+
+func (c *pubsubChannel) getConsumer() *nsq.Consumer {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.consumer
 }
 
 func (c *pubsubChannel) Put(msg *pubsub.Message) {
+	c.put(context.Background(), msg)
+}
+
+// put 将消息放入 channel 的队列，OverflowBlock 策略下队列已满时阻塞到有空位、channel 退出或者 ctx 结束
+func (c *pubsubChannel) put(ctx context.Context, msg *pubsub.Message) {
 
 	if atomic.LoadInt32(&c.exitFlag) == 1 {
 		c.ps.log.Warnf("cannot write to the exiting channel %v", c.Name)
 		return
 	}
 
-	c.msgCh.Put(msg)
+	dropped, err := c.queue.PutContext(ctx, msg)
+	if err != nil {
+		c.ps.log.Warnf("channel %v put message %v err %v", c.Name, msg.ID, err)
+		c.dropped.Inc()
+		c.rec.Dropped(1)
+	} else if dropped != nil {
+		c.dropped.Inc()
+		c.rec.Dropped(1)
+	}
+	c.backlog.Set(float64(c.queue.Len()))
+}
+
+// drained channel 中积压的消息是否都已经被消费（没有消费者的 channel 视为已消费
+func (c *pubsubChannel) drained() bool {
+	if atomic.LoadInt32(&c.handlers) == 0 {
+		return true
+	}
+
+	return c.queue.Len() == 0 && c.partitions.Len() == 0 &&
+		atomic.LoadInt32(&c.inflight) == 0 && atomic.LoadInt32(&c.retrying) == 0
+}
+
+// pick 选择一个集群消息的消费句柄，在还没有句柄时等待，channel 退出（或者所有的句柄都已经取消订阅）后返回 false
+func (c *pubsubChannel) pick() (*clusterHandler, bool) {
+	c.RLock()
+	ready := c.ready
+	c.RUnlock()
+
+	select {
+	case <-ready:
+	case <-c.exitChan:
+		return nil, false
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if len(c.ackHandlers) == 0 {
+		return nil, false
+	}
+
+	n := atomic.AddUint32(&c.next, 1)
+	return c.ackHandlers[int(n)%len(c.ackHandlers)], true
+}
+
+// report 记录句柄中的 panic
+func (c *pubsubChannel) report(err error) {
+	var perr *pubsub.PanicError
+	if errors.As(err, &perr) {
+		c.ps.log.Errorf("channel %v/%v handler panic %v\n%s", c.TopicName, c.Name, perr.Value, perr.Stack)
+	}
+}
+
+func (c *pubsubChannel) DeadLetter(name string) error {
+	_, err := pubsub.PrepareDeadLetter(c.ps, name, c.scope)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	c.deadLetter = name
+	c.Unlock()
+
+	return nil
+}
+
+// dead 将处理失败的消息发布到死信 topic，没有设置死信 topic 时丢弃
+func (c *pubsubChannel) dead(msg *pubsub.Message, err error) {
+	c.RLock()
+	name := c.deadLetter
+	c.RUnlock()
+	if name == "" {
+		name = c.topic.deadLetterName()
+	}
+
+	if name == "" {
+		c.ps.log.Warnf("channel %v give up message %v after %v attempts, err %v", c.Name, msg.ID, msg.Attempts, err)
+		return
+	}
+
+	dt, perr := c.ps.RegistTopic(name, c.scope)
+	if perr == nil {
+		perr = dt.Pub(pubsub.NewDeadLetter(msg, c.TopicName, c.Name, err))
+	}
+	if perr != nil {
+		c.ps.log.Warnf("channel %v dead letter message %v to %v err %v", c.Name, msg.ID, name, perr)
+	}
+}
+
+// retry 在 delay 之后将处理失败的进程内消息重新放回 channel，超过最大投递次数后发布到死信 topic
+func (c *pubsubChannel) retry(msg *pubsub.Message, err error) {
+	delay, ok := c.ps.parm.Retry.Next(msg, err)
+	if !ok {
+		c.dead(msg, err)
+		return
+	}
+
+	// 同一条消息会广播给多个 channel，重试时复制一份
+	m := *msg
+	m.Attempts++
+
+	atomic.AddInt32(&c.retrying, 1)
+	time.AfterFunc(delay, func() {
+		c.Put(&m)
+		atomic.AddInt32(&c.retrying, -1)
+	})
+}
+
+// addHandlers 添加消费句柄
+//
+// 集群 channel 的句柄共享 ConcurrentHandler 个 worker，Concurrency 限制其中同时交给这个句柄的消息数；
+// 进程内 channel 为每个句柄启动 Concurrency 个消费 goroutine
+func (c *pubsubChannel) addHandlers(handler pubsub.AckHandler, sp pubsub.SubParm) pubsub.ISubscription {
+	if interceptor := c.topic.consumeInterceptor; interceptor != nil {
+		next := handler
+		handler = func(msg *pubsub.Message) error {
+			return interceptor(c.TopicName, c.Name, msg, next)
+		}
+	}
+	handler = sp.Protect(handler)
+	handler = c.parm.Deduplicate(c.TopicName, c.Name, handler, &c.rec, func(err error) {
+		c.ps.log.Warnf("channel %v/%v dedup err %v", c.TopicName, c.Name, err)
+	})
+
+	if c.scope == pubsub.ScopeCluster {
+		ch := &clusterHandler{
+			handler: handler,
+			sem:     make(chan struct{}, sp.Concurrency),
+		}
+		ch.sub = pubsub.NewSubscription(func() { c.removeHandler(ch) })
+
+		c.Lock()
+		defer c.Unlock()
+
+		if atomic.LoadInt32(&c.exitFlag) == 1 {
+			c.ps.log.Warnf("cannot subscribe to the exiting channel %v", c.Name)
+			return ch.sub
+		}
+
+		atomic.AddInt32(&c.handlers, 1)
+		c.ackHandlers = append(c.ackHandlers, ch)
+		if len(c.ackHandlers) == 1 {
+			close(c.ready)
+			if err := c.connect(); err != nil {
+				c.ps.log.Errorf("channel %v connect err %v", c.Name, err)
+			}
+		}
+
+		return ch.sub
+	}
+
+	sub := pubsub.NewSubscription(nil)
+	for i := 0; i < sp.Concurrency; i++ {
+		c.consume(handler, sub)
+	}
+
+	return sub
 }
 
-func (c *pubsubChannel) addHandlers(handler pubsub.Handler) {
-	go func() {
+// removeHandler 删除集群 channel 的消费句柄，删除最后一个句柄时停止 nsq 的 consumer（消息保留在 nsqd 中
+func (c *pubsubChannel) removeHandler(ch *clusterHandler) {
+	c.Lock()
+
+	for i, h := range c.ackHandlers {
+		if h == ch {
+			c.ackHandlers = append(c.ackHandlers[:i], c.ackHandlers[i+1:]...)
+			atomic.AddInt32(&c.handlers, -1)
+			break
+		}
+	}
+
+	var consumer *nsq.Consumer
+	if len(c.ackHandlers) == 0 && c.consumer != nil {
+		consumer = c.consumer
+		c.consumer = nil
+		c.ready = make(chan struct{})
+	}
+
+	c.Unlock()
+
+	if consumer != nil {
+		consumer.Stop()
+		c.ps.log.Infof("Cluster consumer %v stopped", c.Name)
+	}
+}
+
+// pop 取出一条进程内消息，带有分区键的消息在分区已经有处理者时排在分区中（返回 owner 为 false
+func (c *pubsubChannel) pop() (m *pubsub.Message, owner bool, ok bool) {
+	c.popLock.Lock()
+	defer c.popLock.Unlock()
+
+	m, ok = c.queue.Pop()
+	if !ok {
+		return nil, false, false
+	}
+
+	if key := m.PartitionKey(); key != "" && !c.partitions.Enter(key, m) {
+		return m, false, true
+	}
+
+	return m, true, true
+}
+
+// consume 添加一个进程内 channel 的消费 goroutine，直到 channel 退出或者取消订阅
+//
+// 获得分区的 goroutine 会处理完分区中所有排队的消息（即使已经取消订阅），channel 退出时丢弃分区中的消息
+func (c *pubsubChannel) consume(handler pubsub.AckHandler, sub *pubsub.Subscription) {
+	c.Lock()
+	if atomic.LoadInt32(&c.exitFlag) == 1 {
+		c.Unlock()
+		c.ps.log.Warnf("cannot subscribe to the exiting channel %v", c.Name)
+		return
+	}
+	atomic.AddInt32(&c.handlers, 1)
+	c.waitGroup.Add(1)
+	c.Unlock()
+
+	deliver := func(m *pubsub.Message) (bool, error) {
+		return true, c.handle(handler, m)
+	}
+
+	sub.Go(func() {
+		defer func() {
+			atomic.AddInt32(&c.handlers, -1)
+			c.waitGroup.Done()
+		}()
+
 		for {
-			m, ok := <-c.msgCh.Get()
+			m, owner, ok := c.pop()
 			if !ok {
-				goto EXT
+				select {
+				case <-c.queue.Notify():
+					continue
+				case <-c.exitChan:
+					goto EXT
+				case <-sub.Done():
+					goto EXT
+				}
+			}
+
+			if owner {
+				key := m.PartitionKey()
+				if key == "" {
+					if err := c.handle(handler, m); err != nil {
+						c.retry(m, err)
+					}
+				} else {
+					for c.handleOrdered(m, deliver, nil) {
+						next, has := c.partitions.Next(key)
+						if !has {
+							break
+						}
+						m = next.(*pubsub.Message)
+					}
+				}
 			}
-			c.msgCh.Load()
 
-			handler(m)
+			c.backlog.Set(float64(c.queue.Len() + c.partitions.Len()))
 		}
 	EXT:
 		c.ps.log.Infof("channel %v stopping handler", c.Name)
-	}()
+	})
+}
+
+func (c *pubsubChannel) Arrived(handler pubsub.Handler, opts ...pubsub.SubOption) pubsub.ISubscription {
+	return c.addHandlers(func(msg *pubsub.Message) error {
+		handler(msg)
+		return nil
+	}, pubsub.NewSubParm(opts...))
 }
 
-func (c *pubsubChannel) Arrived(handler pubsub.Handler) {
-	c.addHandlers(handler)
+func (c *pubsubChannel) ArrivedAck(handler pubsub.AckHandler, opts ...pubsub.SubOption) pubsub.ISubscription {
+	return c.addHandlers(handler, pubsub.NewSubParm(opts...))
 }
 
+// Exit 停止 channel，并等待进程内 channel 的消费 goroutine 退出（不要在 channel 的句柄中删除自己所在的 channel
 func (c *pubsubChannel) Exit() error {
 	if !atomic.CompareAndSwapInt32(&c.exitFlag, 0, 1) {
 		return errors.New("exiting")
@@ -147,9 +720,16 @@ func (c *pubsubChannel) Exit() error {
 
 	c.ps.log.Infof("channel %v exiting", c.Name)
 
-	if c.scope == pubsub.ScopeCluster {
-		c.consumer.Stop()
+	c.Lock()
+	close(c.exitChan)
+	consumer := c.consumer
+	c.Unlock()
+
+	c.queue.Close()
+	if consumer != nil {
+		consumer.Stop()
 	}
 
+	c.waitGroup.Wait()
 	return nil
 }
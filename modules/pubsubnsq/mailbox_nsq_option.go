@@ -1,6 +1,12 @@
 package pubsubnsq
 
-import "github.com/nsqio/go-nsq"
+import (
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/pubsub"
+)
 
 // Parm nsq config
 type Parm struct {
@@ -15,6 +21,15 @@ type Parm struct {
 
 	ConcurrentHandler int32 // consumer 接收句柄的并发数（默认1
 
+	// Retry AckHandler 处理失败后的重试策略（集群消息通过 nsq 的 REQ 重新投递
+	Retry pubsub.Retry
+
+	// ProducerHealth 检查 producer 健康状态（ping nsqd）的间隔
+	ProducerHealth time.Duration
+
+	// interceptors 发布和消费拦截器
+	interceptors pubsub.Interceptors
+
 	nsqLogLv nsq.LogLevel
 }
 
@@ -42,13 +57,9 @@ func WithLookupAddr(addr []string) Option {
 	}
 }
 
-// WithNsqdAddr nsqd addr
+// WithNsqdAddr nsqd addr（tcp 地址和 http 地址需要一一对应
 func WithNsqdAddr(tcpAddr []string, httpAddr []string) Option {
 	return func(c *Parm) {
-		if len(tcpAddr) != len(httpAddr) {
-			panic("The addresses of tcp and http should match")
-		}
-
 		c.NsqdAddress = tcpAddr
 		c.NsqdHttpAddress = httpAddr
 	}
@@ -67,3 +78,60 @@ func WithHandlerConcurrent(cnt int32) Option {
 		c.ConcurrentHandler = cnt
 	}
 }
+
+// WithRetry 消息处理失败后的重试策略（默认最多投递 5 次，退避 1s 起翻倍，最长 1m，maxBackoff 为 0 时不限制
+//
+// 集群消息的重试延迟不能超过 nsqd 的 max-req-timeout（默认 1h
+func WithRetry(maxAttempts int, backoff time.Duration, maxBackoff time.Duration) Option {
+	return func(c *Parm) {
+		c.Retry = pubsub.Retry{
+			MaxAttempts: maxAttempts,
+			Backoff:     backoff,
+			MaxBackoff:  maxBackoff,
+		}
+	}
+}
+
+// WithProducerHealth 检查 producer 健康状态的间隔（默认 5s，发布时会跳过不健康的 nsqd
+func WithProducerHealth(interval time.Duration) Option {
+	return func(c *Parm) {
+		c.ProducerHealth = interval
+	}
+}
+
+// AppendPubInterceptors 添加发布拦截器，按照添加的顺序串联（第一个在最外层）
+//
+// topicPatterns 为拦截器作用的 topic（语法参考 path.Match），不传时作用于所有的 topic
+func AppendPubInterceptors(interceptor pubsub.PubInterceptor, topicPatterns ...string) Option {
+	return func(c *Parm) {
+		c.interceptors.AppendPub(interceptor, topicPatterns...)
+	}
+}
+
+// AppendConsumeInterceptors 添加消费拦截器，包装 Arrived 和 ArrivedAck 的句柄（topicPatterns 同 AppendPubInterceptors
+func AppendConsumeInterceptors(interceptor pubsub.ConsumeInterceptor, topicPatterns ...string) Option {
+	return func(c *Parm) {
+		c.interceptors.AppendConsume(interceptor, topicPatterns...)
+	}
+}
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if len(p.NsqdAddress) != len(p.NsqdHttpAddress) {
+		return module.InvalidOption("nsqd tcp address %v does not match http address %v", p.NsqdAddress, p.NsqdHttpAddress)
+	}
+	if p.ConcurrentHandler <= 0 {
+		return module.InvalidOption("concurrent handler must be positive")
+	}
+	if p.ProducerHealth <= 0 {
+		return module.InvalidOption("producer health interval must be positive")
+	}
+	if err := p.Retry.Validate(); err != nil {
+		return module.InvalidOption("retry %v", err)
+	}
+	if err := p.interceptors.Validate(); err != nil {
+		return module.InvalidOption("interceptor topic pattern %v", err)
+	}
+
+	return nil
+}
@@ -1,15 +1,20 @@
 package pubsubnsq
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/nsqio/go-nsq"
 	"github.com/pojol/braid-go/mock"
 	"github.com/pojol/braid-go/module"
 	"github.com/pojol/braid-go/module/logger"
@@ -246,3 +251,70 @@ func TestClusterMailboxParm(t *testing.T) {
 	assert.Equal(t, cm.parm.Address, []string{mock.NsqdAddr})
 }
 */
+
+func TestProducerFailover(t *testing.T) {
+	log := module.GetBuilder(zaplogger.Name).Build("TestProducerFailover").(logger.ILogger)
+
+	pool := newProducerPool([]string{"127.0.0.1:1", "127.0.0.1:2"}, nsq.NewConfig(), time.Millisecond*10, log)
+	defer pool.stop()
+
+	// ping 失败的 nsqd 被标记为不健康，依旧作为最后的选择
+	assert.Equal(t, pool.healthy(), 0)
+	assert.Equal(t, len(pool.candidates("")), 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := pool.publish(ctx, "TestProducerFailover", "", []byte("msg"))
+	assert.Equal(t, err != nil, true)
+
+	// 没有任何 producer
+	empty := newProducerPool(nil, nsq.NewConfig(), time.Second, log)
+	defer empty.stop()
+	assert.Equal(t, errors.Is(empty.publish(ctx, "TestProducerFailover", "", []byte("msg")), ErrNoProducer), true)
+
+	// 恢复健康的 producer 排在前面
+	pool.producers[1].record(nil)
+	assert.Equal(t, pool.candidates("")[0].addr, "127.0.0.1:2")
+
+	// 带有分区键的消息固定使用同一个 producer，不论它是否健康
+	pinned := pool.candidates("player1")
+	assert.Equal(t, len(pinned), 1)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, pool.candidates("player1")[0].addr, pinned[0].addr)
+	}
+}
+
+func TestClusterStats(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"topics":[{"topic_name":"TestClusterStats","depth":5,"message_count":20,"channels":[
+			{"channel_name":"local","depth":2,"in_flight_count":1,"message_count":10,"clients":[{}]},
+			{"channel_name":"other","depth":3,"requeue_count":4,"message_count":10,"clients":[{},{}]}]}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestClusterStats").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithNsqdAddr([]string{"127.0.0.1:1", "127.0.0.1:2"}, []string{addr, "127.0.0.1:1"}))
+	mb := b.Build("TestClusterStats", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	topic, _ := mb.RegistTopic("TestClusterStats", pubsub.ScopeCluster)
+	defer mb.RemoveTopic("TestClusterStats")
+	topic.Sub("local")
+
+	stats := topic.Stats()
+	assert.Equal(t, len(stats.Remote), 2)
+	assert.Equal(t, stats.Remote[0], pubsub.RemoteTopicStats{Addr: addr, Depth: 5, Messages: 20})
+	assert.Equal(t, stats.Remote[1].Err != "", true)
+
+	// 只在其他节点上消费的 channel 也会出现在统计数据中
+	assert.Equal(t, len(stats.Channels), 2)
+	assert.Equal(t, stats.Channels[0].Name, "local")
+	assert.Equal(t, stats.Channels[0].Remote[0].InFlight, int64(1))
+	assert.Equal(t, stats.Channels[1].Name, "other")
+	assert.Equal(t, stats.Channels[1].Handlers, 0)
+	assert.Equal(t, stats.Channels[1].Remote[0].Requeued, uint64(4))
+	assert.Equal(t, stats.Channels[1].Remote[0].Clients, 2)
+}
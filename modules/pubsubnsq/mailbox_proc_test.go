@@ -1,6 +1,9 @@
 package pubsubnsq
 
 import (
+	"context"
+	"errors"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -142,3 +145,311 @@ func BenchmarkTestProc(b *testing.B) {
 		}
 	})
 }
+
+func TestProcRetry(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcRetry").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithRetry(3, time.Millisecond*10, time.Millisecond*50))
+	mb := b.Build("TestProcRetry", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	var attempts, handled uint64
+
+	topic, _ := mb.RegistTopic("TestProcRetry", pubsub.ScopeProc)
+	topic.Sub("Normal").ArrivedAck(func(msg *pubsub.Message) error {
+		atomic.AddUint64(&attempts, 1)
+		if msg.Attempts < 2 {
+			return errors.New("retry")
+		}
+		atomic.AddUint64(&handled, 1)
+		return nil
+	})
+
+	topic.Pub(&pubsub.Message{Body: []byte("msg")})
+
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, atomic.LoadUint64(&attempts), uint64(2))
+	assert.Equal(t, atomic.LoadUint64(&handled), uint64(1))
+
+	mb.RemoveTopic("TestProcRetry")
+}
+
+func TestProcDeadLetter(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcDeadLetter").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithRetry(1, time.Millisecond, time.Millisecond))
+	mb := b.Build("TestProcDeadLetter", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	topic, _ := mb.RegistTopic("TestProcDeadLetter", pubsub.ScopeProc)
+	channel := topic.Sub("Normal")
+	assert.Equal(t, channel.DeadLetter("TestProcDeadLetter-dlq"), nil)
+
+	channel.ArrivedAck(func(msg *pubsub.Message) error {
+		return errors.New("bug")
+	})
+
+	dead := make(chan *pubsub.Message, 1)
+	mb.GetTopic("TestProcDeadLetter-dlq").Sub(pubsub.DeadLetterChannel).Arrived(func(msg *pubsub.Message) {
+		dead <- msg
+	})
+
+	topic.Pub(&pubsub.Message{Body: []byte("msg")})
+
+	select {
+	case msg := <-dead:
+		assert.Equal(t, msg.Header(pubsub.HeaderDeadTopic), "TestProcDeadLetter")
+		assert.Equal(t, msg.Header(pubsub.HeaderDeadAttempts), "1")
+		assert.Equal(t, string(msg.Body), "msg")
+	case <-time.After(time.Second):
+		t.Fatal("dead letter timeout")
+	}
+
+	mb.RemoveTopic("TestProcDeadLetter")
+	mb.RemoveTopic("TestProcDeadLetter-dlq")
+}
+
+func TestProcOverflow(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcOverflow").(logger.ILogger)
+	mb := module.GetBuilder(Name).Build("TestProcOverflow", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	topic, _ := mb.RegistTopic("TestProcOverflow", pubsub.ScopeProc)
+	channel := topic.Sub("Normal", pubsub.WithCapacity(2, pubsub.OverflowDropNewest))
+
+	for i := 0; i < 5; i++ {
+		topic.Pub(&pubsub.Message{Body: []byte("msg")})
+	}
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, channel.(*pubsubChannel).queue.Len(), 2)
+
+	var tick uint64
+	channel.Arrived(func(msg *pubsub.Message) {
+		atomic.AddUint64(&tick, 1)
+	})
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, atomic.LoadUint64(&tick), uint64(2))
+
+	mb.RemoveTopic("TestProcOverflow")
+}
+
+func TestProcExitBlocked(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcExitBlocked").(logger.ILogger)
+	mb := module.GetBuilder(Name).Build("TestProcExitBlocked", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	topic, _ := mb.RegistTopic("TestProcExitBlocked", pubsub.ScopeProc)
+	channel := topic.Sub("Normal", pubsub.WithCapacity(1, pubsub.OverflowBlock))
+
+	// 没有消费者的 channel 已满，loop 阻塞在第二条消息上
+	for i := 0; i < 3; i++ {
+		topic.Pub(&pubsub.Message{Body: []byte("msg")})
+	}
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, channel.(*pubsubChannel).queue.Len(), 1)
+
+	done := make(chan error)
+	go func() {
+		done <- mb.RemoveTopic("TestProcExitBlocked")
+	}()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, err, nil)
+	case <-time.After(time.Second * 2):
+		t.Fatal("remove topic blocked")
+	}
+}
+
+func TestProcDelay(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcDelay").(logger.ILogger)
+	mb := module.GetBuilder(Name).Build("TestProcDelay", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	topic, _ := mb.RegistTopic("TestProcDelay", pubsub.ScopeProc)
+	recv := make(chan string, 4)
+	topic.Sub("Normal").Arrived(func(msg *pubsub.Message) { recv <- string(msg.Body) })
+
+	begin := time.Now()
+	err := topic.PubDelay(context.Background(), &pubsub.Message{Body: []byte("delay")}, time.Millisecond*50)
+	assert.Equal(t, err, nil)
+
+	err = topic.PubBatch(context.Background(), []*pubsub.Message{
+		{Body: []byte("1")},
+		{Body: []byte("2")},
+	})
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, <-recv, "1")
+	assert.Equal(t, <-recv, "2")
+	assert.Equal(t, <-recv, "delay")
+	assert.Equal(t, time.Since(begin) >= time.Millisecond*40, true)
+
+	mb.RemoveTopic("TestProcDelay")
+}
+
+func TestProcUnsubscribe(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcUnsubscribe").(logger.ILogger)
+	mb := module.GetBuilder(Name).Build("TestProcUnsubscribe", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	topic, _ := mb.RegistTopic("TestProcUnsubscribe", pubsub.ScopeProc)
+	channel := topic.Sub("Normal")
+
+	var first, second uint64
+	sub := channel.Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&first, 1) })
+	channel.Arrived(func(msg *pubsub.Message) {
+		if string(msg.Body) == "panic" {
+			panic("boom")
+		}
+		atomic.AddUint64(&second, 1)
+	}, pubsub.WithConcurrency(2))
+
+	assert.Equal(t, sub.Unsubscribe(), nil)
+
+	// 句柄中的 panic 不会影响其他的消息
+	topic.Pub(&pubsub.Message{Body: []byte("panic")})
+	for i := 0; i < 10; i++ {
+		topic.Pub(&pubsub.Message{Body: []byte("msg")})
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	assert.Equal(t, atomic.LoadUint64(&first), uint64(0))
+	assert.Equal(t, atomic.LoadUint64(&second), uint64(10))
+
+	// 删除 topic 时等待所有的消费 goroutine 退出
+	mb.RemoveTopic("TestProcUnsubscribe")
+	assert.Equal(t, atomic.LoadInt32(&channel.(*pubsubChannel).handlers), int32(0))
+}
+
+func TestProcInterceptors(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcInterceptors").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(AppendPubInterceptors(func(ctx context.Context, topic string, msg *pubsub.Message, invoker pubsub.PubInvoker) error {
+		msg.SetHeader("x-trace", topic)
+		return invoker(ctx, topic, msg)
+	}, "TestProcInterceptors.*"))
+	b.AddModuleOption(AppendPubInterceptors(func(ctx context.Context, topic string, msg *pubsub.Message, invoker pubsub.PubInvoker) error {
+		if string(msg.Body) == "invalid" {
+			return errors.New("invalid message")
+		}
+		return invoker(ctx, topic, msg)
+	}, "TestProcInterceptors.validate"))
+	b.AddModuleOption(AppendConsumeInterceptors(func(topic, channel string, msg *pubsub.Message, handler pubsub.AckHandler) error {
+		msg.SetHeader("x-channel", topic+"/"+channel)
+		return handler(msg)
+	}, "TestProcInterceptors.*"))
+	mb := b.Build("TestProcInterceptors", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	recv := make(chan *pubsub.Message, 8)
+
+	topic, _ := mb.RegistTopic("TestProcInterceptors.validate", pubsub.ScopeProc)
+	topic.Sub("Normal").Arrived(func(msg *pubsub.Message) { recv <- msg })
+
+	assert.Equal(t, topic.Pub(pubsub.NewMessage([]byte("invalid"))) != nil, true)
+	assert.Equal(t, topic.Pub(pubsub.NewMessage([]byte("valid"))), nil)
+	err := topic.PubBatch(context.Background(), []*pubsub.Message{
+		pubsub.NewMessage([]byte("1")),
+		pubsub.NewMessage([]byte("invalid")),
+	})
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, topic.PubDelay(context.Background(), pubsub.NewMessage([]byte("delay")), time.Millisecond*10), nil)
+
+	msg := <-recv
+	assert.Equal(t, string(msg.Body), "valid")
+	assert.Equal(t, msg.Header("x-trace"), "TestProcInterceptors.validate")
+	assert.Equal(t, msg.Header("x-channel"), "TestProcInterceptors.validate/Normal")
+
+	msg = <-recv
+	assert.Equal(t, string(msg.Body), "delay")
+	assert.Equal(t, msg.Header("x-trace"), "TestProcInterceptors.validate")
+	assert.Equal(t, topic.Stats().Published, uint64(2))
+
+	// 不匹配模式的 topic 不经过拦截器
+	other, _ := mb.RegistTopic("TestProcInterceptorsOther", pubsub.ScopeProc)
+	other.Sub("Normal").Arrived(func(msg *pubsub.Message) { recv <- msg })
+	assert.Equal(t, other.Pub(pubsub.NewMessage([]byte("invalid"))), nil)
+
+	msg = <-recv
+	assert.Equal(t, msg.Header("x-trace"), "")
+	assert.Equal(t, msg.Header("x-channel"), "")
+
+	mb.RemoveTopic("TestProcInterceptors.validate")
+	mb.RemoveTopic("TestProcInterceptorsOther")
+}
+
+func TestProcPartition(t *testing.T) {
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestProcPartition").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithRetry(3, time.Millisecond*10, time.Millisecond*50))
+	mb := b.Build("TestProcPartition", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+
+	keys := []string{"player1", "player2", "player3"}
+	const num = 20
+
+	var lock sync.Mutex
+	recv := make(map[string][]string)
+	var failed int32
+	var active, maxActive int32
+
+	topic, _ := mb.RegistTopic("TestProcPartition", pubsub.ScopeProc)
+	topic.Sub("Normal").ArrivedAck(func(msg *pubsub.Message) error {
+		cur := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			old := atomic.LoadInt32(&maxActive)
+			if cur <= old || atomic.CompareAndSwapInt32(&maxActive, old, cur) {
+				break
+			}
+		}
+
+		// 每个分区的第 5 条消息第一次处理失败，后续的消息需要等待它重试成功
+		if string(msg.Body) == "4" && atomic.AddInt32(&failed, 1) <= int32(len(keys)) {
+			return errors.New("retry")
+		}
+
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		recv[msg.PartitionKey()] = append(recv[msg.PartitionKey()], string(msg.Body))
+		lock.Unlock()
+		return nil
+	}, pubsub.WithConcurrency(4))
+
+	for i := 0; i < num; i++ {
+		for _, key := range keys {
+			topic.Pub(pubsub.NewMessage([]byte(strconv.Itoa(i))).SetPartitionKey(key))
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		lock.Lock()
+		cnt := 0
+		for _, bodies := range recv {
+			cnt += len(bodies)
+		}
+		lock.Unlock()
+		if cnt == num*len(keys) {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	lock.Lock()
+	for _, key := range keys {
+		expect := make([]string, num)
+		for i := range expect {
+			expect[i] = strconv.Itoa(i)
+		}
+		assert.Equal(t, recv[key], expect)
+	}
+	lock.Unlock()
+
+	// 不同分区的消息并行处理
+	assert.Equal(t, atomic.LoadInt32(&maxActive) > 1, true)
+	assert.Equal(t, topic.Stats().Channels[0].Backlog, 0)
+
+	mb.RemoveTopic("TestProcPartition")
+}
@@ -0,0 +1,251 @@
+package pubsubnsq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/pojol/braid-go/internal/braidsync"
+	"github.com/pojol/braid-go/module/logger"
+)
+
+// ErrNoProducer 没有可用的 nsqd
+var ErrNoProducer = errors.New("no nsqd producer available")
+
+// ErrMixedPartition 集群作用域的 PubBatch 中包含分区键不同的消息
+var ErrMixedPartition = errors.New("batch messages have different partition keys")
+
+// producer 连接到某个 nsqd 的 producer，以及它的健康状态
+type producer struct {
+	addr string
+	p    *nsq.Producer
+
+	healthy int32
+
+	sync.Mutex
+	lastErr error
+}
+
+func (p *producer) isHealthy() bool {
+	return atomic.LoadInt32(&p.healthy) == 1
+}
+
+func (p *producer) err() error {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.lastErr
+}
+
+func (p *producer) record(err error) {
+	p.Lock()
+	p.lastErr = err
+	p.Unlock()
+
+	if err == nil {
+		atomic.StoreInt32(&p.healthy, 1)
+	} else {
+		atomic.StoreInt32(&p.healthy, 0)
+	}
+}
+
+// sendFunc 通过 producer 异步发送命令（PUB DPUB MPUB
+type sendFunc func(p *nsq.Producer, done chan *nsq.ProducerTransaction) error
+
+// send 发送命令并等待 nsqd 的确认（或者 ctx 结束
+func (p *producer) send(ctx context.Context, fn sendFunc) error {
+	done := make(chan *nsq.ProducerTransaction, 1)
+	if err := fn(p.p, done); err != nil {
+		return err
+	}
+
+	select {
+	case trans := <-done:
+		return trans.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// producerPool 所有 nsqd 的 producer，集群作用域的 topic 共享
+//
+// 发布时从健康的 producer 中随机选择一个，失败后依次尝试其他的 producer（最后才尝试不健康的）；
+// 带有分区键的消息固定发布到分区键哈希对应的 nsqd（失败时不切换，保证同一分区键的消息在同一个 nsqd 中排队）；
+// 后台定期 ping 所有的 producer 以更新它们的健康状态
+type producerPool struct {
+	log       logger.ILogger
+	producers []*producer
+
+	interval time.Duration
+	done     *braidsync.Switch
+	wg       braidsync.WaitGroupWrapper
+}
+
+func newProducerPool(addrs []string, cfg *nsq.Config, interval time.Duration, log logger.ILogger) *producerPool {
+	pp := &producerPool{
+		log:      log,
+		interval: interval,
+		done:     braidsync.NewSwitch(),
+	}
+
+	for _, addr := range addrs {
+		p, err := nsq.NewProducer(addr, cfg)
+		if err != nil {
+			log.Errorf("new nsq producer %v err %v", addr, err.Error())
+			continue
+		}
+
+		np := &producer{addr: addr, p: p}
+		err = p.Ping()
+		np.record(err)
+		if err != nil {
+			log.Errorf("nsq producer ping err %v addr %v", err.Error(), addr)
+		}
+
+		pp.producers = append(pp.producers, np)
+	}
+
+	pp.wg.Wrap(pp.loop)
+	return pp
+}
+
+// candidates 发布时尝试的顺序，健康的 producer（从随机的位置开始）在前；有分区键时只返回哈希对应的 producer
+func (pp *producerPool) candidates(key string) []*producer {
+	n := len(pp.producers)
+	lst := make([]*producer, 0, n)
+	if n == 0 {
+		return lst
+	}
+
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return append(lst, pp.producers[h.Sum32()%uint32(n)])
+	}
+
+	offset := rand.Intn(n)
+	for i := 0; i < n; i++ {
+		if p := pp.producers[(offset+i)%n]; p.isHealthy() {
+			lst = append(lst, p)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if p := pp.producers[(offset+i)%n]; !p.isHealthy() {
+			lst = append(lst, p)
+		}
+	}
+
+	return lst
+}
+
+// publish 发布消息，直到某个 nsqd 确认或者 ctx 结束
+func (pp *producerPool) publish(ctx context.Context, topic, key string, body []byte) error {
+	return pp.send(ctx, topic, key, func(p *nsq.Producer, done chan *nsq.ProducerTransaction) error {
+		return p.PublishAsync(topic, body, done)
+	})
+}
+
+// deferredPublish 发布延迟消息，由 nsqd 在 delay 之后投递
+func (pp *producerPool) deferredPublish(ctx context.Context, topic, key string, delay time.Duration, body []byte) error {
+	return pp.send(ctx, topic, key, func(p *nsq.Producer, done chan *nsq.ProducerTransaction) error {
+		return p.DeferredPublishAsync(topic, delay, body, done)
+	})
+}
+
+// multiPublish 在一次请求中发布多条消息
+func (pp *producerPool) multiPublish(ctx context.Context, topic, key string, bodies [][]byte) error {
+	return pp.send(ctx, topic, key, func(p *nsq.Producer, done chan *nsq.ProducerTransaction) error {
+		return p.MultiPublishAsync(topic, bodies, done)
+	})
+}
+
+// send 依次通过 producer 发送命令，直到某个 nsqd 确认或者 ctx 结束
+func (pp *producerPool) send(ctx context.Context, topic, key string, fn sendFunc) error {
+	var err error = ErrNoProducer
+
+	for _, p := range pp.candidates(key) {
+		if cerr := ctx.Err(); cerr != nil {
+			return cerr
+		}
+
+		err = p.send(ctx, fn)
+		if err == nil {
+			p.record(nil)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		p.record(err)
+		pp.log.Warnf("nsq producer %v publish topic %v err %v, try the next one", p.addr, topic, err)
+	}
+
+	return fmt.Errorf("publish topic %v err %w", topic, err)
+}
+
+// healthy 健康的 producer 数量
+func (pp *producerPool) healthy() int {
+	cnt := 0
+	for _, p := range pp.producers {
+		if p.isHealthy() {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func (pp *producerPool) loop() {
+	ticker := time.NewTicker(pp.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, p := range pp.producers {
+				healthy := p.isHealthy()
+				p.record(p.p.Ping())
+
+				if healthy && !p.isHealthy() {
+					pp.log.Warnf("nsq producer %v is unhealthy, err %v", p.addr, p.err())
+				} else if !healthy && p.isHealthy() {
+					pp.log.Infof("nsq producer %v is healthy again", p.addr)
+				}
+			}
+		case <-pp.done.Done():
+			return
+		}
+	}
+}
+
+func (pp *producerPool) introspect() []map[string]interface{} {
+	infos := []map[string]interface{}{}
+	for _, p := range pp.producers {
+		lastErr := ""
+		if err := p.err(); err != nil {
+			lastErr = err.Error()
+		}
+
+		infos = append(infos, map[string]interface{}{
+			"addr":     p.addr,
+			"healthy":  p.isHealthy(),
+			"last_err": lastErr,
+		})
+	}
+	return infos
+}
+
+func (pp *producerPool) stop() {
+	pp.done.Open()
+	pp.wg.Wait()
+
+	for _, p := range pp.producers {
+		p.p.Stop()
+	}
+}
@@ -0,0 +1,185 @@
+package pubsubnsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// StatsTimeout 获取 nsqd 统计数据的超时时间
+const StatsTimeout = time.Second * 2
+
+var statsClient = &http.Client{Timeout: StatsTimeout}
+
+// nsqdStats nsqd /stats?format=json 的返回值（1.0 之前的版本包装在 data 中
+type nsqdStats struct {
+	Topics []nsqdTopicStats `json:"topics"`
+	Data   *nsqdStats       `json:"data"`
+}
+
+type nsqdTopicStats struct {
+	Name         string             `json:"topic_name"`
+	Depth        int64              `json:"depth"`
+	MessageCount uint64             `json:"message_count"`
+	Channels     []nsqdChannelStats `json:"channels"`
+}
+
+type nsqdChannelStats struct {
+	Name          string            `json:"channel_name"`
+	Depth         int64             `json:"depth"`
+	InFlightCount int64             `json:"in_flight_count"`
+	DeferredCount int64             `json:"deferred_count"`
+	MessageCount  uint64            `json:"message_count"`
+	RequeueCount  uint64            `json:"requeue_count"`
+	TimeoutCount  uint64            `json:"timeout_count"`
+	Clients       []json.RawMessage `json:"clients"`
+}
+
+// fetchNsqdStats 获取 nsqd 中 topic 的统计数据（nsqd 中还没有这个 topic 时返回空的统计数据
+func fetchNsqdStats(addr, topic string) (*nsqdTopicStats, error) {
+	u := fmt.Sprintf("http://%s/stats?format=json&topic=%s", addr, url.QueryEscape(topic))
+
+	resp, err := statsClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nsqd stats request status err %v", resp.StatusCode)
+	}
+
+	var stats nsqdStats
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("nsqd stats decode err %w", err)
+	}
+	if stats.Data != nil {
+		stats.Topics = stats.Data.Topics
+	}
+
+	for _, ts := range stats.Topics {
+		if ts.Name == topic {
+			return &ts, nil
+		}
+	}
+
+	return &nsqdTopicStats{Name: topic}, nil
+}
+
+func (c *pubsubChannel) stats() pubsub.ChannelStats {
+	s := pubsub.ChannelStats{
+		Name:     c.Name,
+		Handlers: int(atomic.LoadInt32(&c.handlers)),
+		Backlog:  c.queue.Len() + c.partitions.Len(),
+		InFlight: int(atomic.LoadInt32(&c.inflight)),
+	}
+	c.rec.Fill(&s)
+
+	return s
+}
+
+// Stats topic 的统计数据，集群作用域的 topic 会合并所有 nsqd 的统计数据（包括只在其他节点上消费的 channel
+func (t *pubsubTopic) Stats() pubsub.TopicStats {
+	s := pubsub.TopicStats{
+		Name:     t.Name,
+		Scope:    t.scope,
+		Channels: []pubsub.ChannelStats{},
+	}
+	t.rec.Fill(&s)
+
+	t.RLock()
+	for _, c := range t.channelMap {
+		s.Channels = append(s.Channels, c.stats())
+	}
+	t.RUnlock()
+
+	if t.scope == pubsub.ScopeCluster {
+		t.mergeRemote(&s)
+	}
+
+	sort.Slice(s.Channels, func(i, j int) bool { return s.Channels[i].Name < s.Channels[j].Name })
+	return s
+}
+
+// mergeRemote 并发地获取所有 nsqd 的统计数据，合并到 s 中
+func (t *pubsubTopic) mergeRemote(s *pubsub.TopicStats) {
+	addrs := t.ps.parm.NsqdHttpAddress
+
+	remotes := make([]*nsqdTopicStats, len(addrs))
+	errs := make([]error, len(addrs))
+
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			remotes[i], errs[i] = fetchNsqdStats(addr, t.Name)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	channels := make(map[string]int, len(s.Channels))
+	for i, cs := range s.Channels {
+		channels[cs.Name] = i
+	}
+
+	for i, addr := range addrs {
+		if errs[i] != nil {
+			t.ps.log.Warnf("topic %v nsqd %v stats err %v", t.Name, addr, errs[i])
+			s.Remote = append(s.Remote, pubsub.RemoteTopicStats{Addr: addr, Err: errs[i].Error()})
+			continue
+		}
+
+		ts := remotes[i]
+		s.Remote = append(s.Remote, pubsub.RemoteTopicStats{
+			Addr:     addr,
+			Depth:    ts.Depth,
+			Messages: ts.MessageCount,
+		})
+
+		for _, rc := range ts.Channels {
+			idx, ok := channels[rc.Name]
+			if !ok {
+				idx = len(s.Channels)
+				channels[rc.Name] = idx
+				s.Channels = append(s.Channels, pubsub.ChannelStats{Name: rc.Name})
+			}
+
+			s.Channels[idx].Remote = append(s.Channels[idx].Remote, pubsub.RemoteChannelStats{
+				Addr:     addr,
+				Depth:    rc.Depth,
+				InFlight: rc.InFlightCount,
+				Deferred: rc.DeferredCount,
+				Messages: rc.MessageCount,
+				Requeued: rc.RequeueCount,
+				TimedOut: rc.TimeoutCount,
+				Clients:  len(rc.Clients),
+			})
+		}
+	}
+}
+
+func (nmb *nsqPubsub) Stats() []pubsub.TopicStats {
+	nmb.RLock()
+	topics := make([]*pubsubTopic, 0, len(nmb.topicMap))
+	for _, t := range nmb.topicMap {
+		topics = append(topics, t)
+	}
+	nmb.RUnlock()
+
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+
+	stats := make([]pubsub.TopicStats, 0, len(topics))
+	for _, t := range topics {
+		stats = append(stats, t.Stats())
+	}
+
+	return stats
+}
@@ -1,16 +1,17 @@
 package pubsubnsq
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/nsqio/go-nsq"
 	"github.com/pojol/braid-go/internal/braidsync"
+	"github.com/pojol/braid-go/module/metrics"
 	"github.com/pojol/braid-go/module/pubsub"
 )
 
@@ -19,9 +20,19 @@ type pubsubTopic struct {
 	ps    *nsqPubsub
 	scope pubsub.ScopeTy
 
-	msgch    chan *pubsub.Message
-	exitFlag int32
-	producer []*nsq.Producer
+	msgch     chan *pubsub.Message
+	exitFlag  int32
+	producers *producerPool
+
+	published metrics.ICounter
+	rec       pubsub.TopicRecorder
+
+	// pubInterceptor consumeInterceptor 匹配这个 topic 的拦截器链（没有时为 nil
+	pubInterceptor     pubsub.PubInterceptor
+	consumeInterceptor pubsub.ConsumeInterceptor
+
+	// deadLetter topic 中所有 channel 的死信 topic
+	deadLetter string
 
 	waitGroup braidsync.WaitGroupWrapper
 
@@ -29,6 +40,10 @@ type pubsubTopic struct {
 	exitChan          chan int
 	channelUpdateChan chan int
 
+	// ctx 在 topic 退出时取消，loop 向 channel 投递消息时使用（避免阻塞在已满的 channel 上无法退出
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	sync.RWMutex
 
 	channelMap map[string]*pubsubChannel
@@ -36,22 +51,25 @@ type pubsubTopic struct {
 
 func newTopic(name string, scope pubsub.ScopeTy, n *nsqPubsub) *pubsubTopic {
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	topic := &pubsubTopic{
-		Name:              name,
-		ps:                n,
-		scope:             scope,
-		startChan:         make(chan int, 1),
-		exitChan:          make(chan int),
-		channelUpdateChan: make(chan int),
-		msgch:             make(chan *pubsub.Message, 4096),
-		channelMap:        make(map[string]*pubsubChannel),
+		Name:               name,
+		ps:                 n,
+		scope:              scope,
+		startChan:          make(chan int, 1),
+		exitChan:           make(chan int),
+		channelUpdateChan:  make(chan int),
+		msgch:              make(chan *pubsub.Message, 4096),
+		channelMap:         make(map[string]*pubsubChannel),
+		published:          n.published.With(name),
+		pubInterceptor:     n.parm.interceptors.Pub(name),
+		consumeInterceptor: n.parm.interceptors.Consume(name),
+		ctx:                ctx,
+		cancel:             cancel,
 	}
 
 	if scope == pubsub.ScopeCluster {
-		cps := make([]*nsq.Producer, 0, len(n.parm.NsqdAddress))
-		var err error
-		var cp *nsq.Producer
-
 		for _, addr := range n.parm.LookupdAddress {
 
 			url := fmt.Sprintf("http://%s/topic/create?topic=%s",
@@ -73,20 +91,7 @@ func newTopic(name string, scope pubsub.ScopeTy, n *nsqPubsub) *pubsubTopic {
 
 		}
 
-		for k, addr := range n.parm.NsqdHttpAddress {
-			cp, err = nsq.NewProducer(n.parm.NsqdAddress[k], nsq.NewConfig())
-			if err != nil {
-				n.log.Errorf("Channel new nsq producer err %v", err.Error())
-				continue
-			}
-
-			if err = cp.Ping(); err != nil {
-				n.log.Errorf("Channel nsq producer ping err %v addr %v", err.Error(), addr)
-				continue
-			}
-
-			cps = append(cps, cp)
-
+		for _, addr := range n.parm.NsqdHttpAddress {
 			url := fmt.Sprintf("http://%s/topic/create?topic=%s", addr, name)
 			resp, err := http.Post(url, "application/json", nil)
 			if err != nil {
@@ -102,7 +107,7 @@ func newTopic(name string, scope pubsub.ScopeTy, n *nsqPubsub) *pubsubTopic {
 			}
 		}
 
-		topic.producer = cps
+		topic.producers = n.producers()
 	}
 
 	topic.waitGroup.Wrap(topic.loop)
@@ -118,10 +123,10 @@ func (t *pubsubTopic) start() {
 	}
 }
 
-func (t *pubsubTopic) Sub(name string) pubsub.IChannel {
+func (t *pubsubTopic) Sub(name string, opts ...pubsub.ChannelOption) pubsub.IChannel {
 
 	t.Lock()
-	c, isNew := t.getOrCreateChannel(name, t.scope)
+	c, isNew := t.getOrCreateChannel(name, pubsub.NewChannelParm(opts...))
 	t.Unlock()
 
 	if isNew {
@@ -135,11 +140,11 @@ func (t *pubsubTopic) Sub(name string) pubsub.IChannel {
 	return c
 }
 
-func (t *pubsubTopic) getOrCreateChannel(name string, scope pubsub.ScopeTy) (pubsub.IChannel, bool) {
+func (t *pubsubTopic) getOrCreateChannel(name string, cp pubsub.ChannelParm) (pubsub.IChannel, bool) {
 
 	channel, ok := t.channelMap[name]
 	if !ok {
-		channel = newChannel(t.Name, name, scope, t.ps)
+		channel = newChannel(t, name, cp)
 		t.channelMap[name] = channel
 
 		t.ps.log.Infof("Topic %v new channel %v", t.Name, name)
@@ -173,13 +178,50 @@ func (t *pubsubTopic) RemoveChannel(name string) error {
 	return nil
 }
 
-func (t *pubsubTopic) put(msg *pubsub.Message) error {
+func (t *pubsubTopic) DeadLetter(name string) error {
+	_, err := pubsub.PrepareDeadLetter(t.ps, name, t.scope)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.deadLetter = name
+	t.Unlock()
+
+	return nil
+}
+
+func (t *pubsubTopic) deadLetterName() string {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.deadLetter
+}
+
+// put 将消息放入 topic 的队列（调用方持有读锁
+//
+// 队列已满时，如果 topic 中已经有 channel，则等待 loop 将消息投递到 channel（channel 的溢出策略为 OverflowBlock 时会一直阻塞到发布者或者 ctx 结束）；
+// 还没有 channel 的 topic 直接返回错误
+func (t *pubsubTopic) put(ctx context.Context, msg *pubsub.Message) error {
 	select {
 	case t.msgch <- msg:
+		return nil
 	default:
+	}
+
+	if len(t.channelMap) == 0 {
+		t.rec.Dropped(1)
 		return fmt.Errorf("the pubsub topic %v queue is full", t.Name)
 	}
 
+	select {
+	case t.msgch <- msg:
+	case <-t.exitChan:
+		return errors.New("exiting")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
 	return nil
 }
 
@@ -228,8 +270,9 @@ func (t *pubsubTopic) loop() {
 			goto EXT
 		}
 
+		// OverflowBlock 的 channel 已满时会阻塞 loop，直到它有空位、被删除或者 topic 退出
 		for _, channel := range chans {
-			channel.Put(msg)
+			channel.put(t.ctx, msg)
 		}
 	}
 
@@ -238,6 +281,37 @@ EXT:
 }
 
 func (t *pubsubTopic) Pub(msg *pubsub.Message) error {
+	return t.PubContext(context.Background(), msg)
+}
+
+// intercept 通过发布拦截器调用 invoker（没有拦截器时直接调用
+func (t *pubsubTopic) intercept(ctx context.Context, msg *pubsub.Message, invoker pubsub.PubInvoker) error {
+	if t.pubInterceptor == nil {
+		return invoker(ctx, t.Name, msg)
+	}
+
+	return t.pubInterceptor(ctx, t.Name, msg, invoker)
+}
+
+// publish 发布一条已经填充元数据的消息（调用方持有读锁
+func (t *pubsubTopic) publish(ctx context.Context, topic string, msg *pubsub.Message) error {
+	var err error
+	if t.scope == pubsub.ScopeProc {
+		err = t.put(ctx, msg)
+	} else {
+		err = t.producers.publish(ctx, topic, msg.PartitionKey(), pubsub.Encode(msg))
+	}
+	if err != nil {
+		return err
+	}
+
+	t.published.Inc()
+	t.rec.Published(1)
+	return nil
+}
+
+// PubContext 进程作用域的消息放入 topic 的队列后返回；集群作用域的消息在 nsqd 确认后返回，失败时切换到其他的 nsqd
+func (t *pubsubTopic) PubContext(ctx context.Context, msg *pubsub.Message) error {
 	t.RLock()
 	defer t.RUnlock()
 
@@ -245,13 +319,176 @@ func (t *pubsubTopic) Pub(msg *pubsub.Message) error {
 		return errors.New("exiting")
 	}
 
-	if t.scope == pubsub.ScopeProc {
-		err := t.put(msg)
+	pubsub.Stamp(msg, t.ps.parm.ServiceName, t.ps.node)
+
+	return t.intercept(ctx, msg, t.publish)
+}
+
+func (t *pubsubTopic) PubAsync(ctx context.Context, msg *pubsub.Message, done func(error)) {
+	go func() {
+		err := t.PubContext(ctx, msg)
+		if done != nil {
+			done(err)
+		}
+	}()
+}
+
+// publishDelayed 进程作用域的延迟消息到期（发布拦截器已经在 PubDelay 中调用过
+func (t *pubsubTopic) publishDelayed(ctx context.Context, msg *pubsub.Message) {
+	t.RLock()
+	defer t.RUnlock()
+
+	err := errors.New("exiting")
+	if atomic.LoadInt32(&t.exitFlag) == 0 {
+		err = t.publish(ctx, t.Name, msg)
+	}
+	if err != nil {
+		t.ps.log.Warnf("topic %v publish delayed message %v err %v", t.Name, msg.ID, err)
+	}
+}
+
+// PubDelay 集群作用域的消息通过 nsqd 的 DPUB 延迟投递（delay 不能超过 nsqd 的 max-req-timeout，默认 1h）；
+// 进程作用域的消息保存在进程内的时间轮中，到期后在单独的 goroutine 中放入 topic 的队列（阻塞时间不超过 DelayTimeout，关闭 pubsub 时未到期的消息会被丢弃
+//
+// 发布拦截器在调用 PubDelay 时执行，而不是在消息到期时
+func (t *pubsubTopic) PubDelay(ctx context.Context, msg *pubsub.Message, delay time.Duration) error {
+	if delay <= 0 {
+		return t.PubContext(ctx, msg)
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+
+	pubsub.Stamp(msg, t.ps.parm.ServiceName, t.ps.node)
+
+	return t.intercept(ctx, msg, func(ctx context.Context, topic string, msg *pubsub.Message) error {
+		if t.scope == pubsub.ScopeProc {
+			ok := t.ps.delayer.After(delay, func(ctx context.Context) {
+				t.publishDelayed(ctx, msg)
+			})
+			if !ok {
+				return errors.New("exiting")
+			}
+			return nil
+		}
+
+		err := t.producers.deferredPublish(ctx, topic, msg.PartitionKey(), delay, pubsub.Encode(msg))
 		if err != nil {
 			return err
 		}
+
+		t.published.Inc()
+		t.rec.Published(1)
+		return nil
+	})
+}
+
+// PubBatch 集群作用域的消息通过 nsqd 的 MPUB 在一次请求中发送（全部成功或者全部失败
+//
+// 集群作用域的一批消息发送到同一个 nsqd，所以它们的分区键必须相同（都没有分区键也可以），否则返回 ErrMixedPartition
+//
+// 每条消息分别经过发布拦截器，拦截器中的 invoker 只是收集消息，消息在所有的拦截器返回后一起发送；
+// 任意一个拦截器返回错误时整批消息都不会发送
+func (t *pubsubTopic) PubBatch(ctx context.Context, msgs []*pubsub.Message) error {
+	t.RLock()
+	defer t.RUnlock()
+
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	for _, msg := range msgs {
+		pubsub.Stamp(msg, t.ps.parm.ServiceName, t.ps.node)
+	}
+
+	if t.pubInterceptor != nil {
+		batch := make([]*pubsub.Message, 0, len(msgs))
+		collect := func(ctx context.Context, topic string, msg *pubsub.Message) error {
+			batch = append(batch, msg)
+			return nil
+		}
+
+		for _, msg := range msgs {
+			if err := t.intercept(ctx, msg, collect); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+		msgs = batch
+	}
+
+	if t.scope == pubsub.ScopeProc {
+		for i, msg := range msgs {
+			if err := t.put(ctx, msg); err != nil {
+				t.published.Add(float64(i))
+				t.rec.Published(i)
+				return err
+			}
+		}
 	} else {
-		t.producer[rand.Intn(len(t.producer))].Publish(t.Name, msg.Body)
+		key := msgs[0].PartitionKey()
+		bodies := make([][]byte, len(msgs))
+		for i, msg := range msgs {
+			if msg.PartitionKey() != key {
+				return fmt.Errorf("topic %v publish batch err %w", t.Name, ErrMixedPartition)
+			}
+			bodies[i] = pubsub.Encode(msg)
+		}
+
+		if err := t.producers.multiPublish(ctx, t.Name, key, bodies); err != nil {
+			return err
+		}
+	}
+
+	t.published.Add(float64(len(msgs)))
+	t.rec.Published(len(msgs))
+	return nil
+}
+
+// drained topic 中的消息是否都已经被 channel 消费
+func (t *pubsubTopic) drained() bool {
+	t.RLock()
+	defer t.RUnlock()
+
+	// 没有 channel 的 topic 不会投递消息
+	if len(t.channelMap) == 0 {
+		return true
+	}
+
+	if len(t.msgch) > 0 {
+		return false
+	}
+
+	for _, c := range t.channelMap {
+		if !c.drained() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// flush 等待 topic 中的消息投递完毕
+func (t *pubsubTopic) flush(ctx context.Context) error {
+	tick := time.NewTicker(time.Millisecond * 10)
+	defer tick.Stop()
+
+	for !t.drained() {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return fmt.Errorf("topic %v flush %w", t.Name, ctx.Err())
+		}
 	}
 
 	return nil
@@ -266,15 +503,23 @@ func (t *pubsubTopic) Exit() error {
 	t.ps.log.Infof("topic %v exiting", t.Name)
 
 	close(t.exitChan)
-	// 等待 loop 中止后处理余下逻辑
-	t.waitGroup.Wait()
+	t.cancel()
 
 	t.Lock()
+	channels := make([]*pubsubChannel, 0, len(t.channelMap))
 	for _, channel := range t.channelMap {
 		delete(t.channelMap, channel.Name)
-		channel.Exit()
+		channels = append(channels, channel)
 	}
 	t.Unlock()
 
+	// 先停止 channel（关闭队列），loop 不会阻塞在已满的 channel 上
+	for _, channel := range channels {
+		channel.Exit()
+	}
+
+	// 等待 loop 中止
+	t.waitGroup.Wait()
+
 	return nil
 }
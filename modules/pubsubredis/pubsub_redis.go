@@ -0,0 +1,437 @@
+// 实现文件 pubsubredis 基于 redis streams 实现的 pubsub
+//
+// 集群作用域的 topic 对应一个 stream（StreamPrefix + topic），channel 对应 stream 上的 consumer group，
+// 因此不同的 channel 之间是广播，同一 channel 中的消费者（包括其他节点上的）竞争消费。
+// 消息在处理完成后确认（XACK），崩溃的消费者所持有的未确认消息会被同一 channel 中的其他消费者回收。
+// 进程作用域的 topic 不经过 redis，由内置的 pubsubmem 处理。
+package pubsubredis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pojol/braid-go/internal/timewheel"
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/pojol/braid-go/modules/moduleparm"
+	"github.com/pojol/braid-go/modules/pubsubmem"
+)
+
+const (
+	// Name pub-sub plug-in name
+	Name = "PubsubRedis"
+)
+
+type redisPubsubBuilder struct {
+	opts []Option
+}
+
+func newRedisPubsub() module.IBuilder {
+	return &redisPubsubBuilder{}
+}
+
+func (rb *redisPubsubBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	rb.opts = append(rb.opts, o)
+	return nil
+}
+
+func (rb *redisPubsubBuilder) Name() string {
+	return Name
+}
+
+func (rb *redisPubsubBuilder) Type() module.ModuleType {
+	return module.Pubsub
+}
+
+func (rb *redisPubsubBuilder) Depends() []module.Dependency {
+	return []module.Dependency{
+		{Type: module.Logger},
+		{Type: module.Metrics, Optional: true},
+	}
+}
+
+// parm 默认配置项加上用户设置的配置项
+func (rb *redisPubsubBuilder) parm(name string) Parm {
+	host, _ := os.Hostname()
+
+	p := Parm{
+		RedisAddr:     "redis://127.0.0.1:6379/0",
+		Consumer:      name + "-" + host,
+		MaxLen:        10000,
+		BatchSize:     16,
+		BlockTime:     time.Second,
+		ClaimIdle:     time.Minute * 2,
+		ClaimInterval: time.Second * 10,
+		Retry:         pubsub.DefaultRetry,
+	}
+	for _, opt := range rb.opts {
+		opt(&p)
+	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (rb *redisPubsubBuilder) Validate() error {
+	p := rb.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (rb *redisPubsubBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+	bp := moduleparm.Parse(Name, buildOpts)
+
+	p := rb.parm(name)
+	if err := p.Validate(); err != nil {
+		panic(&module.OptionError{Module: Name, Err: err})
+	}
+
+	m := bp.Metrics
+	if m == nil {
+		m = metrics.Nop()
+	}
+
+	// 进程作用域的 topic 交给 pubsubmem 处理
+	procOpts := []interface{}{moduleparm.WithLogger(bp.Logger)}
+	if bp.Metrics != nil {
+		procOpts = append(procOpts, moduleparm.WithMetrics(bp.Metrics))
+	}
+	pb := module.GetBuilder(pubsubmem.Name)
+	pb.AddModuleOption(pubsubmem.WithRetry(p.Retry.MaxAttempts, p.Retry.Backoff, p.Retry.MaxBackoff))
+	proc := pb.Build(name, procOpts...).(pubsub.IPubsub)
+
+	host, _ := os.Hostname()
+
+	rps := &redisPubsub{
+		serviceName: name,
+		node:        host,
+		parm:        p,
+		log:         bp.Logger,
+		proc:        proc,
+		topicMap:    make(map[string]*redisTopic),
+		pool: &redis.Pool{
+			MaxIdle:     16,
+			IdleTimeout: time.Second * 120,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(
+					p.RedisAddr,
+					redis.DialReadTimeout(p.BlockTime+5*time.Second),
+					redis.DialWriteTimeout(5*time.Second),
+					redis.DialConnectTimeout(2*time.Second),
+				)
+			},
+		},
+
+		published: m.Counter("braid_pubsub_published_total", "Number of messages published to the topic.", "topic"),
+		consumed:  m.Counter("braid_pubsub_consumed_total", "Number of messages consumed by the channel.", "topic", "channel"),
+
+		delayer: timewheel.NewDelayer(pubsubmem.WheelTick, pubsubmem.WheelSlots, pubsubmem.DelayTimeout),
+	}
+
+	return rps
+}
+
+type redisPubsub struct {
+	// serviceName node 发布消息时填充的服务名和节点名
+	serviceName string
+	node        string
+
+	parm Parm
+	log  logger.ILogger
+
+	pool *redis.Pool
+	proc pubsub.IPubsub
+
+	health module.HealthRecorder
+
+	// consumers 用于生成消费者名的序号
+	consumers uint64
+
+	published metrics.ICounterVec
+	consumed  metrics.ICounterVec
+
+	sync.RWMutex
+	topicMap map[string]*redisTopic
+
+	// delayer 延迟消息的调度，在第一次发送延迟消息时创建时间轮
+	delayer *timewheel.Delayer
+}
+
+func (rps *redisPubsub) Init() error {
+	conn := rps.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	if err != nil {
+		return fmt.Errorf("%v Dependency check error %v [%v]", rps.serviceName, "redis", rps.parm.RedisAddr)
+	}
+	rps.health.Record(nil)
+
+	return nil
+}
+
+func (rps *redisPubsub) Run() {
+
+}
+
+func (rps *redisPubsub) consumerName() string {
+	seq := atomic.AddUint64(&rps.consumers, 1)
+	return fmt.Sprintf("%v-%v-%v", rps.parm.Consumer, os.Getpid(), seq)
+}
+
+func (rps *redisPubsub) RegistTopic(name string, scope pubsub.ScopeTy) (pubsub.ITopic, error) {
+	if scope != pubsub.ScopeCluster {
+		return rps.proc.RegistTopic(name, scope)
+	}
+
+	rps.Lock()
+	defer rps.Unlock()
+
+	t, ok := rps.topicMap[name]
+	if !ok {
+		t = newTopic(name, rps)
+		rps.topicMap[name] = t
+		rps.log.Infof("Topic %v created", name)
+	}
+
+	return t, nil
+}
+
+// GetTopic 获取 topic，如果该 topic 不存在，则创建一个进程作用域的 topic
+func (rps *redisPubsub) GetTopic(name string) pubsub.ITopic {
+	rps.RLock()
+	t, ok := rps.topicMap[name]
+	rps.RUnlock()
+	if ok {
+		return t
+	}
+
+	return rps.proc.GetTopic(name)
+}
+
+// checkPartition consumer group 中的多个消费者竞争读取，失败的消息也由其他消费者认领后重试，
+// 无法保证分区键的顺序，所以拒绝带有分区键的消息
+func checkPartition(name string, msgs ...*pubsub.Message) error {
+	for _, msg := range msgs {
+		if msg.PartitionKey() != "" {
+			return fmt.Errorf("topic %v publish err %w", name, pubsub.ErrPartitionUnsupported)
+		}
+	}
+
+	return nil
+}
+
+// xadd 将已经填充元数据的消息写入 topic 的 stream，ctx 的截止时间同时作为 redis 命令的超时时间
+func (rps *redisPubsub) xadd(ctx context.Context, name string, msg *pubsub.Message) error {
+	if err := checkPartition(name, msg); err != nil {
+		return err
+	}
+
+	args := redis.Args{StreamPrefix + name}
+	if rps.parm.MaxLen > 0 {
+		args = args.Add("MAXLEN", "~", rps.parm.MaxLen)
+	}
+	args = args.Add("*", bodyField, pubsub.Encode(msg))
+
+	conn, err := rps.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("topic %v publish err %w", name, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		_, err = redis.DoWithTimeout(conn, timeout, "XADD", args...)
+	} else {
+		_, err = conn.Do("XADD", args...)
+	}
+	rps.health.Record(err)
+	if err != nil {
+		return fmt.Errorf("topic %v publish err %w", name, err)
+	}
+
+	return nil
+}
+
+// Publish 向 topic 发布消息，集群作用域的消息直接写入 stream（不会在本地注册 topic
+func (rps *redisPubsub) Publish(ctx context.Context, name string, scope pubsub.ScopeTy, msg *pubsub.Message) error {
+	if scope != pubsub.ScopeCluster {
+		return rps.proc.Publish(ctx, name, scope, msg)
+	}
+
+	rps.RLock()
+	t, ok := rps.topicMap[name]
+	rps.RUnlock()
+	if ok {
+		return t.PubContext(ctx, msg)
+	}
+
+	pubsub.Stamp(msg, rps.serviceName, rps.node)
+	return rps.xadd(ctx, name, msg)
+}
+
+// RemoveTopic 删除 topic，并停止本地的消费者（stream 依旧保留在 redis 中
+func (rps *redisPubsub) RemoveTopic(name string) error {
+	rps.Lock()
+	t, ok := rps.topicMap[name]
+	delete(rps.topicMap, name)
+	rps.Unlock()
+
+	if !ok {
+		return rps.proc.RemoveTopic(name)
+	}
+
+	rps.log.Infof("deleting topic %v", name)
+	t.exit()
+
+	return nil
+}
+
+// DeleteTopic 删除 topic 并停止本地的消费者，集群作用域的 topic 同时删除 redis 中的 stream（包括 consumer group 以及未消费的消息
+func (rps *redisPubsub) DeleteTopic(name string) error {
+	rps.RLock()
+	t, ok := rps.topicMap[name]
+	rps.RUnlock()
+
+	if err := rps.RemoveTopic(name); err != nil || !ok {
+		return err
+	}
+
+	conn := rps.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", t.key)
+	return err
+}
+
+func (rps *redisPubsub) topics() []*redisTopic {
+	rps.RLock()
+	defer rps.RUnlock()
+
+	topics := make([]*redisTopic, 0, len(rps.topicMap))
+	for _, t := range rps.topicMap {
+		topics = append(topics, t)
+	}
+
+	return topics
+}
+
+// Shutdown 投递进程内剩余的消息，随后停止所有的消费者
+//
+// 集群 topic 中还没有被消费的消息保留在 stream 中，由其他节点（或者重启后）继续消费
+func (rps *redisPubsub) Shutdown(ctx context.Context) error {
+	err := rps.proc.(module.IShutdown).Shutdown(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		for _, t := range rps.topics() {
+			t.exit()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("stop consumers %w", ctx.Err())
+		}
+	}
+
+	return err
+}
+
+// Stats 集群 topic 以及进程内 topic 的统计数据
+func (rps *redisPubsub) Stats() []pubsub.TopicStats {
+	stats := rps.proc.Stats()
+	for _, t := range rps.topics() {
+		stats = append(stats, t.Stats())
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Health 最近一次访问 redis 的结果
+func (rps *redisPubsub) Health() module.Health {
+	return rps.health.Health()
+}
+
+// Introspect 配置项，集群 topic 的 channel 以及本地的消费者数量，进程内的 topic 参考 proc
+func (rps *redisPubsub) Introspect() interface{} {
+	topics := rps.topics()
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+
+	tinfos := []map[string]interface{}{}
+	for _, t := range topics {
+		t.RLock()
+		cinfos := []map[string]interface{}{}
+		for _, c := range t.channelMap {
+			cinfos = append(cinfos, map[string]interface{}{
+				"name":     c.Name,
+				"handlers": atomic.LoadInt32(&c.handlers),
+			})
+		}
+		t.RUnlock()
+
+		sort.Slice(cinfos, func(i, j int) bool { return cinfos[i]["name"].(string) < cinfos[j]["name"].(string) })
+		tinfos = append(tinfos, map[string]interface{}{
+			"name":     t.Name,
+			"stream":   t.key,
+			"channels": cinfos,
+		})
+	}
+
+	var proc interface{}
+	if in, ok := rps.proc.(module.IIntrospect); ok {
+		proc = in.Introspect()
+	}
+
+	return map[string]interface{}{
+		"parm":   rps.parm,
+		"topics": tinfos,
+		"proc":   proc,
+	}
+}
+
+func (rps *redisPubsub) Close() {
+	rps.delayer.Stop()
+
+	for _, t := range rps.topics() {
+		t.exit()
+	}
+
+	rps.proc.(module.IModule).Close()
+	rps.pool.Close()
+}
+
+func init() {
+	module.Register(newRedisPubsub)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"redis_addr": WithRedisAddr,
+		"consumer":   WithConsumer,
+		"max_len":    WithMaxLen,
+		"batch_size": WithBatchSize,
+		"block_time": WithBlockTime,
+		"claim":      WithClaim,
+		"retry":      WithRetry,
+	})
+}
@@ -0,0 +1,122 @@
+package pubsubredis
+
+import (
+	"time"
+
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// Parm redis streams pubsub 配置
+type Parm struct {
+	RedisAddr string
+
+	// Consumer 消费者名的前缀（默认 服务名-主机名），每个消费句柄会在这之后加上进程号和序号
+	Consumer string
+
+	// MaxLen stream 的最大长度（近似裁剪），0 表示不裁剪
+	MaxLen int64
+
+	// BatchSize 每次读取（或者回收）的消息数
+	BatchSize int
+
+	// BlockTime 读取消息时的阻塞时间，同时也是消费者响应退出的最长时间
+	BlockTime time.Duration
+
+	// ClaimIdle 未确认的消息空闲超过这个时间后，会被同一 channel 中的其他消费者回收（通常是因为消费者崩溃，
+	// 没有未确认消息的消费者空闲超过这个时间后会从 consumer group 中删除；必须大于 BlockTime 和 Retry.MaxBackoff
+	ClaimIdle time.Duration
+
+	// ClaimInterval 检查未确认消息的间隔
+	ClaimInterval time.Duration
+
+	// Retry AckHandler 处理失败后的重试策略
+	Retry pubsub.Retry
+}
+
+// Option config wraps
+type Option func(*Parm)
+
+// WithRedisAddr redis 地址
+func WithRedisAddr(addr string) Option {
+	return func(c *Parm) {
+		c.RedisAddr = addr
+	}
+}
+
+// WithConsumer 消费者名的前缀
+func WithConsumer(consumer string) Option {
+	return func(c *Parm) {
+		c.Consumer = consumer
+	}
+}
+
+// WithMaxLen stream 的最大长度（默认 10000，0 表示不裁剪
+func WithMaxLen(maxLen int64) Option {
+	return func(c *Parm) {
+		c.MaxLen = maxLen
+	}
+}
+
+// WithBatchSize 每次读取的消息数（默认 16
+func WithBatchSize(size int) Option {
+	return func(c *Parm) {
+		c.BatchSize = size
+	}
+}
+
+// WithBlockTime 读取消息时的阻塞时间（默认 1s
+func WithBlockTime(t time.Duration) Option {
+	return func(c *Parm) {
+		c.BlockTime = t
+	}
+}
+
+// WithClaim 未确认消息的回收时间（默认空闲 2m 后回收，必须大于重试的最大退避）以及检查的间隔（默认 10s
+func WithClaim(idle time.Duration, interval time.Duration) Option {
+	return func(c *Parm) {
+		c.ClaimIdle = idle
+		c.ClaimInterval = interval
+	}
+}
+
+// WithRetry 消息处理失败后的重试策略（默认最多投递 5 次，退避 1s 起翻倍，最长 1m，maxBackoff 必须大于 0 并且小于 ClaimIdle
+func WithRetry(maxAttempts int, backoff time.Duration, maxBackoff time.Duration) Option {
+	return func(c *Parm) {
+		c.Retry = pubsub.Retry{
+			MaxAttempts: maxAttempts,
+			Backoff:     backoff,
+			MaxBackoff:  maxBackoff,
+		}
+	}
+}
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.RedisAddr == "" {
+		return module.InvalidOption("empty redis address")
+	}
+	if p.MaxLen < 0 {
+		return module.InvalidOption("max len must not be negative")
+	}
+	if p.BatchSize <= 0 {
+		return module.InvalidOption("batch size must be positive")
+	}
+	if p.BlockTime < time.Millisecond {
+		return module.InvalidOption("block time must be at least 1ms")
+	}
+	if p.ClaimIdle <= 0 || p.ClaimInterval <= 0 {
+		return module.InvalidOption("claim idle and claim interval must be positive")
+	}
+	if err := p.Retry.Validate(); err != nil {
+		return module.InvalidOption("retry %v", err)
+	}
+	if p.Retry.MaxBackoff <= 0 {
+		return module.InvalidOption("retry max backoff must be positive")
+	}
+	if p.ClaimIdle <= p.Retry.MaxBackoff || p.ClaimIdle <= p.BlockTime {
+		return module.InvalidOption("claim idle must be greater than retry max backoff and block time")
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package pubsubredis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// StreamPrefix topic 对应的 stream key 的前缀
+	StreamPrefix = "braid_stream-"
+
+	// bodyField 消息体在 stream entry 中的字段名
+	bodyField = "body"
+)
+
+// streamEntry stream 中的一条消息
+type streamEntry struct {
+	ID     string
+	Fields map[string][]byte
+}
+
+// pendingEntry consumer group 中已经投递但还没有确认的消息
+type pendingEntry struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration
+	Deliveries int64
+}
+
+// parseStreams 解析 XREADGROUP 的返回值 [[key, entries], ...]，超时返回 nil
+func parseStreams(reply interface{}) ([]streamEntry, error) {
+	if reply == nil {
+		return nil, nil
+	}
+
+	streams, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []streamEntry
+	for _, stream := range streams {
+		kv, err := redis.Values(stream, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("unexpected stream reply length %d", len(kv))
+		}
+
+		se, err := parseEntries(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, se...)
+	}
+
+	return entries, nil
+}
+
+// parseEntries 解析 [[id, [field, value, ...]], ...]
+//
+// 已经被裁剪掉的消息（XCLAIM 或者读取历史消息时）字段为空
+func parseEntries(reply interface{}) ([]streamEntry, error) {
+	items, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]streamEntry, 0, len(items))
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+
+		kv, err := redis.Values(item, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("unexpected entry reply length %d", len(kv))
+		}
+
+		id, err := redis.String(kv[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := streamEntry{ID: id, Fields: make(map[string][]byte)}
+		if kv[1] != nil {
+			fields, err := redis.ByteSlices(kv[1], nil)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i+1 < len(fields); i += 2 {
+				entry.Fields[string(fields[i])] = fields[i+1]
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// parsePending 解析 XPENDING key group start end count 的返回值 [[id, consumer, idle(ms), deliveries], ...]
+func parsePending(reply interface{}) ([]pendingEntry, error) {
+	items, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]pendingEntry, 0, len(items))
+	for _, item := range items {
+		var pe pendingEntry
+		var idle int64
+
+		vals, err := redis.Values(item, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = redis.Scan(vals, &pe.ID, &pe.Consumer, &idle, &pe.Deliveries)
+		if err != nil {
+			return nil, err
+		}
+
+		pe.Idle = time.Duration(idle) * time.Millisecond
+		entries = append(entries, pe)
+	}
+
+	return entries, nil
+}
+
+// groupInfo consumer group 的信息
+type groupInfo struct {
+	Name      string
+	Consumers int64
+	Pending   int64
+
+	// Lag 还没有投递给 group 的消息数（redis 7.0 之后才有，无法计算时为 0
+	Lag int64
+}
+
+// parseGroups 解析 XINFO GROUPS 的返回值 [[name, <name>, consumers, <n>, pending, <n>, ...], ...]
+func parseGroups(reply interface{}) ([]groupInfo, error) {
+	items, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]groupInfo, 0, len(items))
+	for _, item := range items {
+		kv, err := redis.Values(item, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(kv)%2 != 0 {
+			return nil, fmt.Errorf("unexpected group reply length %d", len(kv))
+		}
+
+		var g groupInfo
+		for i := 0; i < len(kv); i += 2 {
+			key, err := redis.String(kv[i], nil)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key {
+			case "name":
+				g.Name, err = redis.String(kv[i+1], nil)
+			case "consumers":
+				g.Consumers, err = redis.Int64(kv[i+1], nil)
+			case "pending":
+				g.Pending, err = redis.Int64(kv[i+1], nil)
+			case "lag":
+				if g.Lag, err = redis.Int64(kv[i+1], nil); err == redis.ErrNil {
+					err = nil
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
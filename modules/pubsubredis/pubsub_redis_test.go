@@ -0,0 +1,251 @@
+package pubsubredis
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pojol/braid-go/mock"
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+	"github.com/pojol/braid-go/module/pubsub"
+	"github.com/pojol/braid-go/modules/moduleparm"
+	"github.com/pojol/braid-go/modules/zaplogger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	mock.Init()
+	m.Run()
+}
+
+func TestParseReply(t *testing.T) {
+	entry := func(id string, fields ...string) interface{} {
+		if len(fields) == 0 {
+			return []interface{}{[]byte(id), nil}
+		}
+		kv := []interface{}{}
+		for _, f := range fields {
+			kv = append(kv, []byte(f))
+		}
+		return []interface{}{[]byte(id), kv}
+	}
+
+	reply := []interface{}{
+		[]interface{}{[]byte("braid_stream-topic"), []interface{}{
+			entry("1-0", "body", "hello"),
+			entry("2-0"),
+		}},
+	}
+
+	entries, err := parseStreams(reply)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[0].ID, "1-0")
+	assert.Equal(t, string(entries[0].Fields[bodyField]), "hello")
+	assert.Equal(t, len(entries[1].Fields), 0)
+
+	// 阻塞读取超时
+	entries, err = parseStreams(nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(entries), 0)
+
+	pending, err := parsePending([]interface{}{
+		[]interface{}{[]byte("1-0"), []byte("consumer-1"), int64(31000), int64(2)},
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pending[0].Consumer, "consumer-1")
+	assert.Equal(t, pending[0].Idle, time.Second*31)
+	assert.Equal(t, pending[0].Deliveries, int64(2))
+}
+
+func TestParseGroups(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			[]byte("name"), []byte("channel"),
+			[]byte("consumers"), int64(2),
+			[]byte("pending"), int64(3),
+			[]byte("last-delivered-id"), []byte("1-0"),
+		},
+	}
+
+	groups, err := parseGroups(reply)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, groups, []groupInfo{{Name: "channel", Consumers: 2, Pending: 3}})
+
+	// redis 7.0 之后的 lag（无法计算时为 nil
+	groups, err = parseGroups([]interface{}{
+		[]interface{}{[]byte("name"), []byte("a"), []byte("lag"), int64(4)},
+		[]interface{}{[]byte("name"), []byte("b"), []byte("lag"), nil},
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, groups, []groupInfo{{Name: "a", Lag: 4}, {Name: "b"}})
+
+	_, err = parseGroups([]interface{}{[]interface{}{[]byte("name")}})
+	assert.Equal(t, err != nil, true)
+}
+
+func TestValidate(t *testing.T) {
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithMaxLen(-1))
+
+	err := b.(module.IValidator).Validate()
+	assert.Equal(t, err != nil, true)
+
+	// 重试的最大退避不小于 ClaimIdle 时，消息会在重试之前被其他消费者回收
+	p := Parm{
+		RedisAddr:     mock.RedisAddr,
+		BatchSize:     16,
+		BlockTime:     time.Second,
+		ClaimIdle:     time.Second * 30,
+		ClaimInterval: time.Second * 10,
+		Retry:         pubsub.DefaultRetry,
+	}
+	assert.Equal(t, p.Validate() != nil, true)
+
+	p.ClaimIdle = time.Minute * 2
+	assert.Equal(t, p.Validate(), nil)
+
+	p.Retry.MaxBackoff = 0
+	assert.Equal(t, p.Validate() != nil, true)
+}
+
+func TestPartitionUnsupported(t *testing.T) {
+	assert.Equal(t, checkPartition("TestPartition", pubsub.NewMessage([]byte("msg"))), nil)
+
+	err := checkPartition("TestPartition", pubsub.NewMessage([]byte("msg")), pubsub.NewMessage([]byte("msg")).SetPartitionKey("player1"))
+	assert.Equal(t, errors.Is(err, pubsub.ErrPartitionUnsupported), true)
+}
+
+func TestStreams(t *testing.T) {
+	conn, err := redis.DialURL(mock.RedisAddr)
+	if err != nil {
+		t.Skipf("redis %v unavailable", mock.RedisAddr)
+	}
+	conn.Do("DEL", StreamPrefix+"TestStreams")
+	conn.Close()
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestStreams").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithRedisAddr(mock.RedisAddr))
+	b.AddModuleOption(WithBlockTime(time.Millisecond * 100))
+	ps := b.Build("TestStreams", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+	assert.Equal(t, ps.(module.IModule).Init(), nil)
+	defer ps.(module.IModule).Close()
+
+	var shared, other uint64
+
+	topic, _ := ps.RegistTopic("TestStreams", pubsub.ScopeCluster)
+	topic.Sub("shared").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&shared, 1) })
+	topic.Sub("shared").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&shared, 1) })
+	topic.Sub("other").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&other, 1) })
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, topic.Pub(&pubsub.Message{Body: []byte("msg")}), nil)
+	}
+
+	for i := 0; i < 100; i++ {
+		if atomic.LoadUint64(&shared) == 10 && atomic.LoadUint64(&other) == 10 {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	assert.Equal(t, atomic.LoadUint64(&shared), uint64(10))
+	assert.Equal(t, atomic.LoadUint64(&other), uint64(10))
+}
+
+func TestRecreateGroup(t *testing.T) {
+	conn, err := redis.DialURL(mock.RedisAddr)
+	if err != nil {
+		t.Skipf("redis %v unavailable", mock.RedisAddr)
+	}
+	defer conn.Close()
+	conn.Do("DEL", StreamPrefix+"TestRecreateGroup")
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestRecreateGroup").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithRedisAddr(mock.RedisAddr))
+	b.AddModuleOption(WithBlockTime(time.Millisecond * 100))
+	ps := b.Build("TestRecreateGroup", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+	assert.Equal(t, ps.(module.IModule).Init(), nil)
+	defer ps.(module.IModule).Close()
+
+	var recv uint64
+
+	topic, _ := ps.RegistTopic("TestRecreateGroup", pubsub.ScopeCluster)
+	topic.Sub("Normal").Arrived(func(msg *pubsub.Message) { atomic.AddUint64(&recv, 1) })
+
+	// consumer group 在外部被删除后，消费者重新创建并继续消费
+	_, err = conn.Do("XGROUP", "DESTROY", StreamPrefix+"TestRecreateGroup", "Normal")
+	assert.Equal(t, err, nil)
+	time.Sleep(time.Millisecond * 300)
+
+	assert.Equal(t, topic.Pub(pubsub.NewMessage([]byte("msg"))), nil)
+
+	for i := 0; i < 100 && atomic.LoadUint64(&recv) == 0; i++ {
+		time.Sleep(time.Millisecond * 20)
+	}
+	assert.Equal(t, atomic.LoadUint64(&recv), uint64(1))
+}
+
+func TestReleaseConsumer(t *testing.T) {
+	conn, err := redis.DialURL(mock.RedisAddr)
+	if err != nil {
+		t.Skipf("redis %v unavailable", mock.RedisAddr)
+	}
+	defer conn.Close()
+	conn.Do("DEL", StreamPrefix+"TestReleaseConsumer")
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestReleaseConsumer").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithRedisAddr(mock.RedisAddr))
+	b.AddModuleOption(WithBlockTime(time.Millisecond * 100))
+	ps := b.Build("TestReleaseConsumer", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+	assert.Equal(t, ps.(module.IModule).Init(), nil)
+	defer ps.(module.IModule).Close()
+
+	topic, _ := ps.RegistTopic("TestReleaseConsumer", pubsub.ScopeCluster)
+	sub := topic.Sub("Normal").Arrived(func(msg *pubsub.Message) {}, pubsub.WithConcurrency(2))
+	time.Sleep(time.Millisecond * 300)
+
+	consumers, err := redis.Values(conn.Do("XINFO", "CONSUMERS", StreamPrefix+"TestReleaseConsumer", "Normal"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(consumers), 2)
+
+	// 取消订阅后，没有未确认消息的消费者从 consumer group 中删除
+	assert.Equal(t, sub.Unsubscribe(), nil)
+
+	consumers, err = redis.Values(conn.Do("XINFO", "CONSUMERS", StreamPrefix+"TestReleaseConsumer", "Normal"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(consumers), 0)
+}
+
+func TestRequesterDelete(t *testing.T) {
+	conn, err := redis.DialURL(mock.RedisAddr)
+	if err != nil {
+		t.Skipf("redis %v unavailable", mock.RedisAddr)
+	}
+	defer conn.Close()
+
+	log := module.GetBuilder(zaplogger.Name).Build("TestRequesterDelete").(logger.ILogger)
+	b := module.GetBuilder(Name)
+	b.AddModuleOption(WithRedisAddr(mock.RedisAddr))
+	b.AddModuleOption(WithBlockTime(time.Millisecond * 100))
+	ps := b.Build("TestRequesterDelete", moduleparm.WithLogger(log)).(pubsub.IPubsub)
+	assert.Equal(t, ps.(module.IModule).Init(), nil)
+	defer ps.(module.IModule).Close()
+
+	r, err := pubsub.NewRequester(ps, pubsub.ScopeCluster)
+	assert.Equal(t, err, nil)
+	key := StreamPrefix + r.ReplyTopic()
+
+	exists, _ := redis.Int(conn.Do("EXISTS", key))
+	assert.Equal(t, exists, 1)
+
+	// 关闭后应答 topic 对应的 stream 从 redis 中删除
+	assert.Equal(t, r.Close(), nil)
+	exists, _ = redis.Int(conn.Do("EXISTS", key))
+	assert.Equal(t, exists, 0)
+}
@@ -0,0 +1,805 @@
+package pubsubredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pojol/braid-go/internal/braidsync"
+	"github.com/pojol/braid-go/module/metrics"
+	"github.com/pojol/braid-go/module/pubsub"
+)
+
+// redisTopic 集群作用域的 topic，对应一个 redis stream
+type redisTopic struct {
+	Name string
+	key  string
+	ps   *redisPubsub
+
+	exitFlag int32
+
+	published metrics.ICounter
+	rec       pubsub.TopicRecorder
+
+	sync.RWMutex
+	channelMap map[string]*redisChannel
+
+	// deadLetter topic 中所有 channel 的死信 topic
+	deadLetter string
+}
+
+func newTopic(name string, ps *redisPubsub) *redisTopic {
+	return &redisTopic{
+		Name:       name,
+		key:        StreamPrefix + name,
+		ps:         ps,
+		published:  ps.published.With(name),
+		channelMap: make(map[string]*redisChannel),
+	}
+}
+
+func (t *redisTopic) Pub(msg *pubsub.Message) error {
+	return t.PubContext(context.Background(), msg)
+}
+
+// PubContext 消息写入 stream 后返回，ctx 的截止时间同时作为 redis 命令的超时时间
+func (t *redisTopic) PubContext(ctx context.Context, msg *pubsub.Message) error {
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+
+	pubsub.Stamp(msg, t.ps.serviceName, t.ps.node)
+	if err := t.ps.xadd(ctx, t.Name, msg); err != nil {
+		return err
+	}
+
+	t.published.Inc()
+	t.rec.Published(1)
+	return nil
+}
+
+// PubDelay redis streams 不支持延迟投递，消息保存在进程内的时间轮中，到期后写入 stream（进程退出时未到期的消息会被丢弃
+//
+// 到期的消息在单独的 goroutine 中写入，阻塞时间不超过 pubsubmem.DelayTimeout
+func (t *redisTopic) PubDelay(ctx context.Context, msg *pubsub.Message, delay time.Duration) error {
+	if delay <= 0 {
+		return t.PubContext(ctx, msg)
+	}
+
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	if err := checkPartition(t.Name, msg); err != nil {
+		return err
+	}
+
+	pubsub.Stamp(msg, t.ps.serviceName, t.ps.node)
+	ok := t.ps.delayer.After(delay, func(ctx context.Context) {
+		if err := t.PubContext(ctx, msg); err != nil {
+			t.ps.log.Warnf("topic %v publish delayed message %v err %v", t.Name, msg.ID, err)
+		}
+	})
+	if !ok {
+		return errors.New("exiting")
+	}
+
+	return nil
+}
+
+// PubBatch 通过 pipeline 在一次往返中写入所有的消息
+func (t *redisTopic) PubBatch(ctx context.Context, msgs []*pubsub.Message) error {
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return errors.New("exiting")
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	if err := checkPartition(t.Name, msgs...); err != nil {
+		return err
+	}
+
+	conn, err := t.ps.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("topic %v publish err %w", t.Name, err)
+	}
+	defer conn.Close()
+
+	for _, msg := range msgs {
+		pubsub.Stamp(msg, t.ps.serviceName, t.ps.node)
+
+		args := redis.Args{t.key}
+		if t.ps.parm.MaxLen > 0 {
+			args = args.Add("MAXLEN", "~", t.ps.parm.MaxLen)
+		}
+		args = args.Add("*", bodyField, pubsub.Encode(msg))
+
+		if err = conn.Send("XADD", args...); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = conn.Flush()
+	}
+
+	published := 0
+	for i := 0; err == nil && i < len(msgs); i++ {
+		if _, err = conn.Receive(); err == nil {
+			published++
+		}
+	}
+
+	t.published.Add(float64(published))
+	t.rec.Published(published)
+	t.ps.health.Record(err)
+	if err != nil {
+		return fmt.Errorf("topic %v publish batch err %w", t.Name, err)
+	}
+
+	return nil
+}
+
+// Stats topic 的统计数据，合并 stream 的长度以及 consumer group 中未确认的消息数（包括其他节点上的 channel
+func (t *redisTopic) Stats() pubsub.TopicStats {
+	s := pubsub.TopicStats{
+		Name:     t.Name,
+		Scope:    pubsub.ScopeCluster,
+		Channels: []pubsub.ChannelStats{},
+	}
+	t.rec.Fill(&s)
+
+	t.RLock()
+	for _, c := range t.channelMap {
+		cs := pubsub.ChannelStats{
+			Name:     c.Name,
+			Handlers: int(atomic.LoadInt32(&c.handlers)),
+			InFlight: int(atomic.LoadInt32(&c.inflight)),
+		}
+		c.rec.Fill(&cs)
+		s.Channels = append(s.Channels, cs)
+	}
+	t.RUnlock()
+
+	t.mergeRemote(&s)
+
+	sort.Slice(s.Channels, func(i, j int) bool { return s.Channels[i].Name < s.Channels[j].Name })
+	return s
+}
+
+func (t *redisTopic) mergeRemote(s *pubsub.TopicStats) {
+	addr := t.ps.parm.RedisAddr
+
+	conn := t.ps.pool.Get()
+	defer conn.Close()
+
+	length, err := redis.Int64(conn.Do("XLEN", t.key))
+	if err != nil {
+		s.Remote = append(s.Remote, pubsub.RemoteTopicStats{Addr: addr, Err: err.Error()})
+		return
+	}
+	s.Remote = append(s.Remote, pubsub.RemoteTopicStats{Addr: addr, Depth: length})
+
+	if length == 0 {
+		// stream 不存在时 XINFO GROUPS 会返回错误
+		if exists, _ := redis.Bool(conn.Do("EXISTS", t.key)); !exists {
+			return
+		}
+	}
+
+	reply, err := conn.Do("XINFO", "GROUPS", t.key)
+	if err == nil {
+		var groups []groupInfo
+		groups, err = parseGroups(reply)
+		for _, g := range groups {
+			idx := -1
+			for i := range s.Channels {
+				if s.Channels[i].Name == g.Name {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				idx = len(s.Channels)
+				s.Channels = append(s.Channels, pubsub.ChannelStats{Name: g.Name})
+			}
+
+			s.Channels[idx].Remote = append(s.Channels[idx].Remote, pubsub.RemoteChannelStats{
+				Addr:     addr,
+				Depth:    g.Lag,
+				InFlight: g.Pending,
+				Clients:  int(g.Consumers),
+			})
+		}
+	}
+	if err != nil {
+		t.ps.log.Warnf("topic %v groups err %v", t.Name, err)
+		s.Remote[0].Err = err.Error()
+	}
+}
+
+func (t *redisTopic) PubAsync(ctx context.Context, msg *pubsub.Message, done func(error)) {
+	go func() {
+		err := t.PubContext(ctx, msg)
+		if done != nil {
+			done(err)
+		}
+	}()
+}
+
+// Sub 获取 channel（对应 stream 上的 consumer group），新创建的 channel 只会收到之后发布的消息
+//
+// 消息积压在 redis 的 stream 中（受 MaxLen 限制），消费者每次只读取 BatchSize 条消息，因此忽略 opts 中的容量配置（去重配置仍然生效
+func (t *redisTopic) Sub(name string, opts ...pubsub.ChannelOption) pubsub.IChannel {
+	t.Lock()
+	defer t.Unlock()
+
+	c, ok := t.channelMap[name]
+	if ok {
+		return c
+	}
+
+	// 创建失败时（例如 redis 暂时不可用）依旧缓存 channel，消费者读取时遇到 NOGROUP 会重新创建 consumer group
+	if err := t.createGroup(name); err != nil {
+		t.ps.health.Record(err)
+		t.ps.log.Errorf("topic %v create channel %v err %v", t.Name, name, err.Error())
+	}
+
+	c = newChannel(name, t, pubsub.NewChannelParm(opts...))
+	t.channelMap[name] = c
+	t.ps.log.Infof("Topic %v new channel %v", t.Name, name)
+
+	return c
+}
+
+// createGroup 创建 channel 对应的 consumer group（stream 不存在时一并创建），group 已经存在时不返回错误
+func (t *redisTopic) createGroup(name string) error {
+	conn := t.ps.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("XGROUP", "CREATE", t.key, name, "$", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveChannel 停止本地的消费者，并删除 consumer group
+func (t *redisTopic) RemoveChannel(name string) error {
+	t.Lock()
+	c, ok := t.channelMap[name]
+	delete(t.channelMap, name)
+	t.Unlock()
+
+	if !ok {
+		return fmt.Errorf("channel %v does not exist", name)
+	}
+
+	t.ps.log.Infof("topic %v deleting channel %v", t.Name, name)
+	c.exit()
+
+	conn := t.ps.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("XGROUP", "DESTROY", t.key, name)
+	return err
+}
+
+func (t *redisTopic) DeadLetter(name string) error {
+	_, err := pubsub.PrepareDeadLetter(t.ps, name, pubsub.ScopeCluster)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	t.deadLetter = name
+	t.Unlock()
+
+	return nil
+}
+
+// exit 停止 topic 上所有本地的消费者（stream 以及 consumer group 依旧保留在 redis 中
+func (t *redisTopic) exit() {
+	if !atomic.CompareAndSwapInt32(&t.exitFlag, 0, 1) {
+		return
+	}
+
+	t.Lock()
+	channels := make([]*redisChannel, 0, len(t.channelMap))
+	for name, c := range t.channelMap {
+		delete(t.channelMap, name)
+		channels = append(channels, c)
+	}
+	t.Unlock()
+
+	for _, c := range channels {
+		c.exit()
+	}
+}
+
+// redisChannel channel，同一 channel 中的消费者（包括其他节点上的）竞争消费 stream 中的消息
+type redisChannel struct {
+	Name  string
+	topic *redisTopic
+
+	// handlers 消费者的数量，inflight 正在被处理的消息数
+	handlers int32
+	inflight int32
+	done     *braidsync.Switch
+
+	deadLock sync.RWMutex
+	// deadLetter channel 的死信 topic（覆盖 topic 上的设置
+	deadLetter string
+
+	// parm Sub 时传入的 channel 配置
+	parm pubsub.ChannelParm
+
+	waitGroup braidsync.WaitGroupWrapper
+
+	consumed metrics.ICounter
+	rec      pubsub.ChannelRecorder
+}
+
+func newChannel(name string, t *redisTopic, cp pubsub.ChannelParm) *redisChannel {
+	return &redisChannel{
+		Name:     name,
+		topic:    t,
+		parm:     cp,
+		done:     braidsync.NewSwitch(),
+		consumed: t.ps.consumed.With(t.Name, name),
+	}
+}
+
+func (c *redisChannel) DeadLetter(name string) error {
+	_, err := pubsub.PrepareDeadLetter(c.topic.ps, name, pubsub.ScopeCluster)
+	if err != nil {
+		return err
+	}
+
+	c.deadLock.Lock()
+	c.deadLetter = name
+	c.deadLock.Unlock()
+
+	return nil
+}
+
+// dead 将处理失败的消息发布到死信 topic，没有设置死信 topic 时丢弃
+func (c *redisChannel) dead(msg *pubsub.Message, err error) {
+	ps := c.topic.ps
+
+	c.deadLock.RLock()
+	name := c.deadLetter
+	c.deadLock.RUnlock()
+	if name == "" {
+		c.topic.RLock()
+		name = c.topic.deadLetter
+		c.topic.RUnlock()
+	}
+
+	if name == "" {
+		ps.log.Warnf("channel %v/%v give up message %v after %v attempts, err %v",
+			c.topic.Name, c.Name, msg.ID, msg.Attempts, err)
+		return
+	}
+
+	dt, perr := ps.RegistTopic(name, pubsub.ScopeCluster)
+	if perr == nil {
+		perr = dt.Pub(pubsub.NewDeadLetter(msg, c.topic.Name, c.Name, err))
+	}
+	if perr != nil {
+		ps.log.Warnf("channel %v/%v dead letter message %v to %v err %v", c.topic.Name, c.Name, msg.ID, name, perr)
+	}
+}
+
+// redisConsumer channel 中的一个消费者
+type redisConsumer struct {
+	name    string
+	handler pubsub.AckHandler
+	sub     *pubsub.Subscription
+
+	// retries 本消费者处理失败、等待重新投递的消息
+	retries map[string]retryEntry
+}
+
+// retryEntry 等待重新投递的消息
+type retryEntry struct {
+	// at 重新投递的时间
+	at time.Time
+	// delay 重新投递的延迟
+	delay time.Duration
+}
+
+// due 是否有到期需要重新投递的消息
+func (rc *redisConsumer) due() bool {
+	now := time.Now()
+	for _, r := range rc.retries {
+		if !now.Before(r.at) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// minDelay 等待重新投递的消息中最短的延迟
+func (rc *redisConsumer) minDelay() time.Duration {
+	min := time.Duration(-1)
+	for _, r := range rc.retries {
+		if min < 0 || r.delay < min {
+			min = r.delay
+		}
+	}
+
+	if min < 0 {
+		return 0
+	}
+	return min
+}
+
+// Arrived 添加消费者，消息在句柄返回后确认
+func (c *redisChannel) Arrived(handler pubsub.Handler, opts ...pubsub.SubOption) pubsub.ISubscription {
+	return c.ArrivedAck(func(msg *pubsub.Message) error {
+		handler(msg)
+		return nil
+	}, opts...)
+}
+
+// ArrivedAck 添加 Concurrency 个消费者，每个消费者在 consumer group 中有独立的名字
+//
+// 处理失败的消息不会被确认，在退避之后由同一个消费者重新认领并投递（重试的精度受 BlockTime 影响，
+// pubsub.Requeue 指定的延迟不会超过 Retry.MaxBackoff）；消费者停止后会从 consumer group 中删除，
+// 还没有确认的消息在 ClaimIdle 之后由同一 channel 中的其他消费者回收
+func (c *redisChannel) ArrivedAck(handler pubsub.AckHandler, opts ...pubsub.SubOption) pubsub.ISubscription {
+	sp := pubsub.NewSubParm(opts...)
+	handler = sp.Protect(handler)
+	handler = c.parm.Deduplicate(c.topic.Name, c.Name, handler, &c.rec, func(err error) {
+		c.topic.ps.log.Warnf("channel %v/%v dedup err %v", c.topic.Name, c.Name, err)
+	})
+
+	sub := pubsub.NewSubscription(nil)
+	for i := 0; i < sp.Concurrency; i++ {
+		rc := &redisConsumer{
+			name:    c.topic.ps.consumerName(),
+			handler: handler,
+			sub:     sub,
+			retries: make(map[string]retryEntry),
+		}
+		atomic.AddInt32(&c.handlers, 1)
+
+		c.waitGroup.Add(1)
+		sub.Go(func() {
+			defer func() {
+				atomic.AddInt32(&c.handlers, -1)
+				c.waitGroup.Done()
+			}()
+			c.consume(rc)
+		})
+	}
+
+	return sub
+}
+
+// stopped 消费者是否需要停止（channel 退出或者取消订阅
+func (c *redisChannel) stopped(rc *redisConsumer) bool {
+	select {
+	case <-rc.sub.Done():
+		return true
+	default:
+		return c.done.HasOpend()
+	}
+}
+
+func (c *redisChannel) consume(rc *redisConsumer) {
+	ps := c.topic.ps
+	var lastClaim time.Time
+
+	defer c.release(rc)
+
+	for !c.stopped(rc) {
+		if time.Since(lastClaim) >= ps.parm.ClaimInterval {
+			lastClaim = time.Now()
+			c.retry(rc)
+			c.claimIdle(rc)
+			c.sweep()
+		} else if rc.due() {
+			c.retry(rc)
+		}
+
+		entries, err := c.read(rc.name)
+		ps.health.Record(err)
+		if err != nil {
+			ps.log.Warnf("channel %v/%v read err %v", c.topic.Name, c.Name, err.Error())
+
+			select {
+			case <-time.After(ps.parm.BlockTime):
+			case <-c.done.Done():
+			case <-rc.sub.Done():
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			c.handle(rc, entry, 1)
+		}
+	}
+}
+
+// read 读取新的消息，consumer group 不存在（Sub 时创建失败，或者在外部被删除）时重新创建后再次读取
+func (c *redisChannel) read(consumer string) ([]streamEntry, error) {
+	entries, err := c.readGroup(consumer)
+	if err == nil || !strings.HasPrefix(err.Error(), "NOGROUP") {
+		return entries, err
+	}
+
+	c.topic.ps.log.Warnf("channel %v/%v consumer group missing, recreating", c.topic.Name, c.Name)
+	if err = c.topic.createGroup(c.Name); err != nil {
+		return nil, err
+	}
+
+	return c.readGroup(consumer)
+}
+
+func (c *redisChannel) readGroup(consumer string) ([]streamEntry, error) {
+	ps := c.topic.ps
+
+	conn := ps.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("XREADGROUP", "GROUP", c.Name, consumer,
+		"COUNT", ps.parm.BatchSize,
+		"BLOCK", int64(ps.parm.BlockTime/time.Millisecond),
+		"STREAMS", c.topic.key, ">")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStreams(reply)
+}
+
+// pending 查询 PEL 中空闲时间不小于 idle 的消息（从 start 开始，最多 BatchSize 条），consumer 不为空时只查询这个消费者的消息
+//
+// XPENDING 的 IDLE 参数需要 redis 6.2 以上的版本
+func (c *redisChannel) pending(conn redis.Conn, idle time.Duration, start string, consumer string) ([]pendingEntry, error) {
+	args := redis.Args{c.topic.key, c.Name, "IDLE", int64(idle / time.Millisecond), start, "+", c.topic.ps.parm.BatchSize}
+	if consumer != "" {
+		args = args.Add(consumer)
+	}
+
+	reply, err := conn.Do("XPENDING", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePending(reply)
+}
+
+// retry 重新认领本消费者处理失败、已经到达重试时间的消息
+//
+// 只查询本消费者的 PEL，并且过滤掉空闲时间小于最短重试延迟的消息，分页查询直到认领 BatchSize 条（剩下的留到下一次
+func (c *redisChannel) retry(rc *redisConsumer) {
+	if len(rc.retries) == 0 {
+		return
+	}
+
+	ps := c.topic.ps
+
+	conn := ps.pool.Get()
+	defer conn.Close()
+
+	now := time.Now()
+	minIdle := rc.minDelay()
+	// 只认领空闲时间不小于最短延迟的消息，避免把刚刚被其他消费者回收的消息抢回来
+	args := redis.Args{c.topic.key, c.Name, rc.name, int64(minIdle / time.Millisecond)}
+	deliveries := make(map[string]int64)
+	seen := make(map[string]bool)
+	exhausted := false
+
+	for start := "-"; len(deliveries) < ps.parm.BatchSize; {
+		pending, err := c.pending(conn, minIdle, start, rc.name)
+		if err != nil {
+			ps.log.Warnf("channel %v/%v pending err %v", c.topic.Name, c.Name, err.Error())
+			return
+		}
+
+		for _, pe := range pending {
+			seen[pe.ID] = true
+			if r, ok := rc.retries[pe.ID]; ok && now.Before(r.at) {
+				continue
+			}
+			if len(deliveries) < ps.parm.BatchSize {
+				args = args.Add(pe.ID)
+				deliveries[pe.ID] = pe.Deliveries
+			}
+		}
+
+		if len(pending) < ps.parm.BatchSize {
+			exhausted = true
+			break
+		}
+		start = "(" + pending[len(pending)-1].ID
+	}
+
+	if exhausted {
+		// 已经到期却不在本消费者 PEL 中的消息（被其他消费者回收，或者 consumer group 被删除），不再等待
+		for id, r := range rc.retries {
+			if !seen[id] && !now.Before(r.at) {
+				delete(rc.retries, id)
+			}
+		}
+	}
+
+	c.claim(conn, rc, args, deliveries)
+}
+
+// claimIdle 回收同一 channel 中其他消费者空闲超过 ClaimIdle 的消息（通常是因为消费者崩溃或者已经退出
+func (c *redisChannel) claimIdle(rc *redisConsumer) {
+	ps := c.topic.ps
+
+	conn := ps.pool.Get()
+	defer conn.Close()
+
+	pending, err := c.pending(conn, ps.parm.ClaimIdle, "-", "")
+	if err != nil {
+		ps.log.Warnf("channel %v/%v pending err %v", c.topic.Name, c.Name, err.Error())
+		return
+	}
+
+	args := redis.Args{c.topic.key, c.Name, rc.name, int64(ps.parm.ClaimIdle / time.Millisecond)}
+	deliveries := make(map[string]int64)
+	for _, pe := range pending {
+		// 本消费者的消息由 retry 认领
+		if pe.Consumer == rc.name {
+			continue
+		}
+
+		args = args.Add(pe.ID)
+		deliveries[pe.ID] = pe.Deliveries
+	}
+
+	c.claim(conn, rc, args, deliveries)
+}
+
+// claim 通过 XCLAIM 认领消息并处理
+func (c *redisChannel) claim(conn redis.Conn, rc *redisConsumer, args redis.Args, deliveries map[string]int64) {
+	if len(deliveries) == 0 {
+		return
+	}
+
+	ps := c.topic.ps
+
+	reply, err := conn.Do("XCLAIM", args...)
+	if err != nil {
+		ps.log.Warnf("channel %v/%v claim err %v", c.topic.Name, c.Name, err.Error())
+		return
+	}
+
+	entries, err := parseEntries(reply)
+	if err != nil {
+		ps.log.Warnf("channel %v/%v claim err %v", c.topic.Name, c.Name, err.Error())
+		return
+	}
+
+	ps.log.Infof("channel %v/%v reclaimed %v messages", c.topic.Name, c.Name, len(entries))
+	for _, entry := range entries {
+		delete(rc.retries, entry.ID)
+		// XCLAIM 会增加消息的投递次数
+		c.handle(rc, entry, int(deliveries[entry.ID])+1)
+	}
+}
+
+// sweepScript 删除 consumer group 中没有未确认消息、并且空闲不小于 ARGV[2] 毫秒的消费者，返回删除的数量
+var sweepScript = redis.NewScript(1, `
+local removed = 0
+for _, consumer in ipairs(redis.call("XINFO", "CONSUMERS", KEYS[1], ARGV[1])) do
+	local info = {}
+	for i = 1, #consumer, 2 do
+		info[consumer[i]] = consumer[i + 1]
+	end
+	if info["pending"] == 0 and info["idle"] >= tonumber(ARGV[2]) then
+		redis.call("XGROUP", "DELCONSUMER", KEYS[1], ARGV[1], info["name"])
+		removed = removed + 1
+	end
+end
+return removed`)
+
+// sweep 删除 consumer group 中遗留的消费者（崩溃的进程留下的消费者，在未确认的消息被回收之后删除
+//
+// 正常运行的消费者每个 BlockTime 都会读取一次，空闲时间不会超过 ClaimIdle
+func (c *redisChannel) sweep() {
+	ps := c.topic.ps
+
+	conn := ps.pool.Get()
+	defer conn.Close()
+
+	removed, err := redis.Int(sweepScript.Do(conn, c.topic.key, c.Name, int64(ps.parm.ClaimIdle/time.Millisecond)))
+	if err != nil {
+		ps.log.Warnf("channel %v/%v sweep consumers err %v", c.topic.Name, c.Name, err.Error())
+		return
+	}
+
+	if removed > 0 {
+		ps.log.Infof("channel %v/%v removed %v idle consumers", c.topic.Name, c.Name, removed)
+	}
+}
+
+// release 消费者停止（取消订阅或者 channel 退出）后从 consumer group 中删除
+//
+// 还有未确认的消息时保留这个消费者，消息在 ClaimIdle 之后由其他消费者回收，之后再由 sweep 删除
+func (c *redisChannel) release(rc *redisConsumer) {
+	ps := c.topic.ps
+
+	conn := ps.pool.Get()
+	defer conn.Close()
+
+	pending, err := c.pending(conn, 0, "-", rc.name)
+	if err == nil && len(pending) == 0 {
+		_, err = conn.Do("XGROUP", "DELCONSUMER", c.topic.key, c.Name, rc.name)
+	}
+
+	// consumer group 已经被删除（RemoveChannel
+	if err != nil && !strings.HasPrefix(err.Error(), "NOGROUP") {
+		ps.log.Warnf("channel %v/%v release consumer %v err %v", c.topic.Name, c.Name, rc.name, err.Error())
+	}
+}
+
+// handle 处理一条消息，处理成功后确认（已经被裁剪掉的消息直接确认，无法解码的消息发布到死信 topic 后确认
+//
+// 处理失败的消息保持未确认的状态，等待重新投递，超过最大投递次数后发布到死信 topic 并确认
+func (c *redisChannel) handle(rc *redisConsumer, entry streamEntry, attempts int) {
+	ps := c.topic.ps
+
+	if body, ok := entry.Fields[bodyField]; ok {
+		msg, err := pubsub.Decode(body)
+		if err != nil {
+			ps.log.Warnf("channel %v/%v decode %v err %v", c.topic.Name, c.Name, entry.ID, err.Error())
+			c.dead(&pubsub.Message{Body: body, Attempts: attempts}, err)
+		} else {
+			msg.Attempts = attempts
+
+			atomic.AddInt32(&c.inflight, 1)
+			begin := time.Now()
+			err = rc.handler(msg)
+			c.rec.Handled(time.Since(begin), err)
+			atomic.AddInt32(&c.inflight, -1)
+			c.consumed.Inc()
+
+			if err != nil {
+				var perr *pubsub.PanicError
+				if errors.As(err, &perr) {
+					ps.log.Errorf("channel %v/%v handler panic %v\n%s", c.topic.Name, c.Name, perr.Value, perr.Stack)
+				}
+
+				delay, ok := ps.parm.Retry.Next(msg, err)
+				if ok {
+					// 延迟不能超过 ClaimIdle，否则消息在重试之前会被其他消费者回收
+					if delay > ps.parm.Retry.MaxBackoff {
+						delay = ps.parm.Retry.MaxBackoff
+					}
+					rc.retries[entry.ID] = retryEntry{at: time.Now().Add(delay), delay: delay}
+					return
+				}
+
+				c.dead(msg, err)
+			}
+		}
+	}
+
+	conn := ps.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("XACK", c.topic.key, c.Name, entry.ID)
+	if err != nil {
+		ps.log.Warnf("channel %v/%v ack %v err %v", c.topic.Name, c.Name, entry.ID, err.Error())
+	}
+}
+
+// exit 停止 channel 中的消费者，并等待正在处理的消息完成
+func (c *redisChannel) exit() {
+	c.done.Open()
+	c.waitGroup.Wait()
+}
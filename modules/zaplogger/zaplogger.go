@@ -17,7 +17,7 @@ var (
 )
 
 type zaplogBuilder struct {
-	opts []interface{}
+	opts []Option
 }
 
 func newZapLogger() module.IBuilder {
@@ -32,12 +32,22 @@ func (zb *zaplogBuilder) Type() module.ModuleType {
 	return module.Logger
 }
 
-func (zb *zaplogBuilder) AddModuleOption(opt interface{}) {
-	zb.opts = append(zb.opts, opt)
+func (zb *zaplogBuilder) Depends() []module.Dependency {
+	return nil
 }
 
-func (zb *zaplogBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+func (zb *zaplogBuilder) AddModuleOption(opt interface{}) error {
+	o, ok := opt.(Option)
+	if !ok {
+		return module.MismatchOption(Name, opt)
+	}
+
+	zb.opts = append(zb.opts, o)
+	return nil
+}
 
+// parm 默认配置项加上用户设置的配置项
+func (zb *zaplogBuilder) parm(name string) Parm {
 	p := Parm{
 		filename:    "log.braid",
 		lv:          logger.DEBUG,
@@ -45,10 +55,25 @@ func (zb *zaplogBuilder) Build(name string, buildOpts ...interface{}) interface{
 		maxBackups:  30,
 		maxAge:      7,
 	}
-
 	for _, opt := range zb.opts {
-		opt.(Option)(&p)
+		opt(&p)
 	}
+	return p
+}
+
+// Validate 在构建模块之前检查配置项
+func (zb *zaplogBuilder) Validate() error {
+	p := zb.parm("")
+	if err := p.Validate(); err != nil {
+		return &module.OptionError{Module: Name, Err: err}
+	}
+
+	return nil
+}
+
+func (zb *zaplogBuilder) Build(name string, buildOpts ...interface{}) interface{} {
+
+	p := zb.parm(name)
 
 	var atom zap.AtomicLevel
 	var ws zapcore.WriteSyncer
@@ -96,5 +121,12 @@ func (zb *zaplogBuilder) Build(name string, buildOpts ...interface{}) interface{
 }
 
 func init() {
-	module.Register(newZapLogger())
+	module.Register(newZapLogger)
+	module.RegisterOptions(Name, map[string]interface{}{
+		"file_name":     WithFileName,
+		"lv":            WithLv,
+		"max_file_size": WithMaxFileSize,
+		"max_backups":   WithMaxBackups,
+		"max_age":       WithMaxAge,
+	})
 }
@@ -1,6 +1,9 @@
 package zaplogger
 
-import "github.com/pojol/braid-go/module/logger"
+import (
+	"github.com/pojol/braid-go/module"
+	"github.com/pojol/braid-go/module/logger"
+)
 
 // Parm nsq config
 type Parm struct {
@@ -54,3 +57,15 @@ func WithMaxAge(age int) Option {
 		c.maxAge = age
 	}
 }
+
+// Validate 检查配置项
+func (p *Parm) Validate() error {
+	if p.filename == "" {
+		return module.InvalidOption("empty file name")
+	}
+	if p.maxFileSize <= 0 || p.maxBackups < 0 || p.maxAge < 0 {
+		return module.InvalidOption("invalid rotation (size %d, backups %d, age %d)", p.maxFileSize, p.maxBackups, p.maxAge)
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package braid
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"github.com/pojol/braid-go/module"
+)
+
+// ShutdownResult 模块在某个关闭阶段中的结果
+type ShutdownResult struct {
+	Stage   Stage
+	Module  string
+	Err     error
+	Elapsed time.Duration
+
+	// Unfinished 截止时间到达时关闭函数还没有返回（函数在后台继续执行，Shutdown 返回之后可能仍在运行
+	Unfinished bool
+}
+
+// ShutdownReport 关闭过程中所有模块的结果
+type ShutdownReport []ShutdownResult
+
+// Err 将关闭过程中失败（包括超时）的结果聚合为 Errors，全部成功时返回 nil
+func (sr ShutdownReport) Err() error {
+	var errs Errors
+
+	for _, r := range sr {
+		if r.Err == nil {
+			continue
+		}
+
+		kind := KindShutdown
+		if r.Stage == StageClose {
+			kind = KindClose
+		}
+		errs = append(errs, &ModuleError{
+			Module: r.Module,
+			Kind:   kind,
+			Err:    fmt.Errorf("stage %v: %w", r.Stage, r.Err),
+		})
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Unfinished 截止时间到达时还没有完成的模块（模块名/阶段
+func (sr ShutdownReport) Unfinished() []string {
+	var names []string
+
+	for _, r := range sr {
+		if r.Unfinished {
+			names = append(names, r.Module+"/"+r.Stage.String())
+		}
+	}
+
+	return names
+}
+
+// stageOf 模块类型所属的关闭阶段
+func stageOf(ty module.ModuleType) Stage {
+	switch ty {
+	case module.Server:
+		return StageServer
+	case module.Discover, module.Elector:
+		return StageDeregister
+	case module.Pubsub:
+		return StageFlush
+	}
+	return StageLoops
+}
+
+// RunContext 运行 braid 并阻塞，直到 ctx 结束或者收到退出信号（默认 SIGINT SIGTERM
+//
+// 随后按阶段关闭 braid，并返回关闭过程中产生的错误
+func (b *Braid) RunContext(ctx context.Context) error {
+	err := b.Run()
+	if err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	if len(b.parm.Signals) > 0 {
+		signal.Notify(sigs, b.parm.Signals...)
+		defer signal.Stop(sigs)
+	}
+
+	select {
+	case <-ctx.Done():
+		b.logInfof("braid context done, shutting down")
+	case sig := <-sigs:
+		b.logInfof("braid receive signal %v, shutting down", sig)
+	}
+
+	return b.Shutdown(context.Background()).Err()
+}
+
+// Shutdown 按阶段关闭 braid
+//
+// 1. 停止接收 rpc 请求 2. 从服务发现中注销 3. 投递 pubsub 中剩余的消息
+// 4. 停止模块的后台循环 5. 关闭模块
+//
+// 每个阶段都有独立的截止时间（WithStageTimeout），同一阶段中的模块按依赖的逆序执行。
+// 超过截止时间的模块会被记录为失败（ShutdownReport.Unfinished），并继续执行后续的阶段。
+func (b *Braid) Shutdown(ctx context.Context) ShutdownReport {
+	var report ShutdownReport
+
+	b.inited = false
+	atomic.StoreInt32(&b.phase, phaseStopping)
+
+	for _, stage := range []Stage{StageServer, StageDeregister, StageFlush, StageLoops} {
+		sctx, cancel := context.WithTimeout(ctx, b.parm.StageTimeout[stage])
+
+		for i := len(b.entries) - 1; i >= 0; i-- {
+			e := b.entries[i]
+			if stageOf(e.key.Type) != stage || e.state == stateClosed {
+				continue
+			}
+
+			sd, ok := e.mod.(module.IShutdown)
+			if !ok {
+				continue
+			}
+
+			_, isModule := e.mod.(module.IModule)
+			if isModule && e.state == stateBuilt {
+				continue
+			}
+
+			report = append(report, runStage(sctx, stage, e.name(), func() error {
+				return sd.Shutdown(sctx)
+			}))
+
+			if !isModule {
+				atomic.StoreInt32(&e.state, stateClosed)
+			}
+		}
+
+		cancel()
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, b.parm.StageTimeout[StageClose])
+	defer cancel()
+
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		e := b.entries[i]
+		if e.state != stateInited && e.state != stateRunning {
+			continue
+		}
+
+		im := e.mod.(module.IModule)
+		report = append(report, runStage(sctx, StageClose, e.name(), func() error {
+			im.Close()
+			return nil
+		}))
+		atomic.StoreInt32(&e.state, stateClosed)
+	}
+
+	b.stopHealth()
+
+	for _, r := range report {
+		if r.Err != nil {
+			b.logErrorf("braid shutdown module %v stage %v err %v", r.Module, r.Stage, r.Err)
+		}
+	}
+	if unfinished := report.Unfinished(); len(unfinished) > 0 {
+		b.logErrorf("braid shutdown %v did not finish before the deadline, still running in the background", unfinished)
+	}
+
+	return report
+}
+
+// runStage 在截止时间内执行模块的关闭函数，超时后不再等待（函数会在后台继续执行
+func runStage(ctx context.Context, stage Stage, name string, fn func() error) ShutdownResult {
+	begin := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- recoverErr(r)
+			}
+		}()
+		done <- fn()
+	}()
+
+	var err error
+	unfinished := false
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		unfinished = true
+	}
+
+	return ShutdownResult{
+		Stage:      stage,
+		Module:     name,
+		Err:        err,
+		Elapsed:    time.Since(begin),
+		Unfinished: unfinished,
+	}
+}